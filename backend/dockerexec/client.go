@@ -0,0 +1,149 @@
+package dockerexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// apiVersion is the oldest Engine API version providing everything used here.
+const apiVersion = "/v1.40"
+
+// Client is a minimal client of the Docker Engine API listening on a Unix socket.
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+func NewClient(socket string) *Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return &Client{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+type execConfig struct {
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	Tty          bool
+	Env          []string
+	Cmd          []string
+	User         string `json:",omitempty"`
+}
+
+type execStartConfig struct {
+	Detach bool
+	Tty    bool
+}
+
+type execInspect struct {
+	Running  bool
+	ExitCode int
+}
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// call sends a request with a JSON body and decodes the JSON response into result.
+func (client *Client) call(method string, path string, body interface{}, result interface{}) error {
+	req, err := client.newRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to docker at `%s`", client.socket)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrapf(err, "invalid response from docker")
+		}
+	}
+	return nil
+}
+
+// hijack sends a request upgrading the connection to a raw stream and returns the stream.
+func (client *Client) hijack(path string, body interface{}) (net.Conn, *bufio.Reader, error) {
+	req, err := client.newRequest(http.MethodPost, path, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := net.Dial("unix", client.socket)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to connect to docker at `%s`", client.socket)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrapf(err, "failed to send request to docker")
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrapf(err, "invalid response from docker")
+	}
+	// old engines answer with 200 and stream on the same connection
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		if err := checkResponse(resp); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.Errorf("unexpected status from docker: %s", resp.Status)
+	}
+	return conn, reader, nil
+}
+
+func (client *Client) newRequest(method string, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	// the host is ignored, requests always go to the socket
+	req, err := http.NewRequest(method, "http://docker"+apiVersion+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	var apiErr apiError
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Message == "" {
+		return errors.Errorf("docker returned %s", resp.Status)
+	}
+	return errors.Errorf("docker returned %s: %s", resp.Status, apiErr.Message)
+}
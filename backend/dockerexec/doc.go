@@ -0,0 +1,3 @@
+// Package dockerexec provides an implementation of webtty.Slave
+// that runs a command in a running container through the Docker Engine API.
+package dockerexec
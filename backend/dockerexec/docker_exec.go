@@ -0,0 +1,97 @@
+package dockerexec
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// DockerExec is a command running with a TTY in a container.
+type DockerExec struct {
+	client    *Client
+	container string
+	command   []string
+	user      string
+	env       map[string]string
+
+	execID string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// New creates an exec instance of command in container and attaches to it.
+func New(client *Client, container string, command []string, options ...Option) (*DockerExec, error) {
+	dexec := &DockerExec{
+		client:    client,
+		container: container,
+		command:   command,
+	}
+	for _, option := range options {
+		option(dexec)
+	}
+
+	env := []string{"TERM=xterm-256color"}
+	for key, value := range dexec.env {
+		env = append(env, key+"="+value)
+	}
+
+	var created struct{ Id string }
+	err := client.call("POST", "/containers/"+url.PathEscape(container)+"/exec", &execConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Env:          env,
+		Cmd:          command,
+		User:         dexec.user,
+	}, &created)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create exec in container `%s`", container)
+	}
+	dexec.execID = created.Id
+
+	dexec.conn, dexec.reader, err = client.hijack("/exec/"+url.PathEscape(dexec.execID)+"/start", &execStartConfig{Tty: true})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start exec in container `%s`", container)
+	}
+
+	return dexec, nil
+}
+
+func (dexec *DockerExec) Read(p []byte) (n int, err error) {
+	return dexec.reader.Read(p)
+}
+
+func (dexec *DockerExec) Write(p []byte) (n int, err error) {
+	return dexec.conn.Write(p)
+}
+
+// Close detaches from the exec instance.
+// The engine can't kill exec instances, closing the stream ends the input
+// of the command, which makes shells exit.
+func (dexec *DockerExec) Close() error {
+	err := dexec.conn.Close()
+
+	var inspect execInspect
+	if dexec.client.call("GET", "/exec/"+url.PathEscape(dexec.execID)+"/json", nil, &inspect) == nil && !inspect.Running {
+		log.Printf("Command in container %s exited with code %d", dexec.container, inspect.ExitCode)
+	}
+	return err
+}
+
+func (dexec *DockerExec) WindowTitleVariables() map[string]interface{} {
+	return map[string]interface{}{
+		"command":   dexec.command[0],
+		"argv":      dexec.command[1:],
+		"container": dexec.container,
+	}
+}
+
+func (dexec *DockerExec) ResizeTerminal(width int, height int) error {
+	path := fmt.Sprintf("/exec/%s/resize?h=%d&w=%d", url.PathEscape(dexec.execID), height, width)
+	return dexec.client.call("POST", path, nil, nil)
+}
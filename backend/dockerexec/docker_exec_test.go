@@ -0,0 +1,144 @@
+package dockerexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeEngine serves the parts of the Engine API used by DockerExec on a Unix socket.
+// The started command echoes its input back.
+type fakeEngine struct {
+	socket  string
+	created chan execConfig
+	resizes chan string
+}
+
+func newFakeEngine(t *testing.T) *fakeEngine {
+	engine := &fakeEngine{
+		socket:  filepath.Join(t.TempDir(), "docker.sock"),
+		created: make(chan execConfig, 1),
+		resizes: make(chan string, 1),
+	}
+	listener, err := net.Listen("unix", engine.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.40/containers/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.40/containers/web/exec" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"No such container"}`)
+			return
+		}
+		var config execConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		engine.created <- config
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"Id":"abc"}`)
+	})
+	mux.HandleFunc("/v1.40/exec/abc/start", func(w http.ResponseWriter, r *http.Request) {
+		var config execStartConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(buf, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buf.Flush()
+		data := make([]byte, 1024)
+		for {
+			n, err := buf.Read(data)
+			if err != nil {
+				return
+			}
+			conn.Write(data[:n])
+		}
+	})
+	mux.HandleFunc("/v1.40/exec/abc/resize", func(w http.ResponseWriter, r *http.Request) {
+		engine.resizes <- r.URL.RawQuery
+	})
+	mux.HandleFunc("/v1.40/exec/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Running":false,"ExitCode":0}`)
+	})
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return engine
+}
+
+func TestDockerExec(t *testing.T) {
+	engine := newFakeEngine(t)
+	factory, err := NewFactory("web", nil, &Options{Socket: engine.socket, User: "nobody"})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFactory(): %s", err)
+	}
+
+	slave, err := factory.New(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	defer slave.Close()
+
+	config := <-engine.created
+	if !config.Tty || !config.AttachStdin || config.User != "nobody" || !reflect.DeepEqual(config.Cmd, DefaultCommand) {
+		t.Fatalf("Unexpected exec config %+v", config)
+	}
+
+	if _, err := slave.Write([]byte("foobar")); err != nil {
+		t.Fatalf("Unexpected error from Write(): %s", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := slave.Read(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error from Read(): %s", err)
+	}
+	if string(buf[:n]) != "foobar" {
+		t.Fatalf("Unexpected output `%s`", buf[:n])
+	}
+
+	if err := slave.ResizeTerminal(120, 40); err != nil {
+		t.Fatalf("Unexpected error from ResizeTerminal(): %s", err)
+	}
+	if query := <-engine.resizes; query != "h=40&w=120" {
+		t.Fatalf("Unexpected resize `%s`", query)
+	}
+}
+
+func TestDockerExecUnknownContainer(t *testing.T) {
+	engine := newFakeEngine(t)
+	factory, _ := NewFactory("db", nil, &Options{Socket: engine.socket})
+
+	_, err := factory.New(nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Fatalf("Expected an API error, got %v", err)
+	}
+}
+
+func TestFactoryAllowedContainers(t *testing.T) {
+	engine := newFakeEngine(t)
+	factory, err := NewFactory("", []string{"bash"}, &Options{Socket: engine.socket, AllowedContainers: "web|api-[0-9]+"})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFactory(): %s", err)
+	}
+
+	for _, container := range []string{"", "db", "web2", "api-1/../db"} {
+		if _, err := factory.New(map[string][]string{"container": {container}}, nil, nil); err == nil {
+			t.Fatalf("Expected container `%s` to be rejected", container)
+		}
+	}
+
+	slave, err := factory.New(map[string][]string{"container": {"web"}}, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	slave.Close()
+}
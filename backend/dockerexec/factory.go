@@ -0,0 +1,80 @@
+package dockerexec
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	"gotty/server"
+)
+
+type Options struct {
+	Socket            string `hcl:"docker_socket" flagName:"docker-socket" flagSName:"" flagDescribe:"Unix socket of the Docker Engine API" default:"/var/run/docker.sock"`
+	User              string `hcl:"docker_user" flagName:"user" flagSName:"u" flagDescribe:"User to run the command as inside the container" default:""`
+	AllowedContainers string `hcl:"docker_allowed_containers" flagName:"allowed-containers" flagSName:"" flagDescribe:"Regular expression of container names or IDs clients may choose with the container parameter, requires --permit-arguments" default:""`
+}
+
+var DefaultCommand = []string{"/bin/sh"}
+
+type Factory struct {
+	container string
+	command   []string
+	// allowed matches the whole container name, nil when clients can't choose
+	allowed *regexp.Regexp
+	client  *Client
+	opts    []Option
+}
+
+// NewFactory creates a factory for commands in container.
+// container can be empty when clients choose the container.
+func NewFactory(container string, command []string, options *Options) (*Factory, error) {
+	if len(command) == 0 {
+		command = DefaultCommand
+	}
+	factory := &Factory{
+		container: container,
+		command:   command,
+		client:    NewClient(options.Socket),
+	}
+
+	if options.AllowedContainers != "" {
+		allowed, err := regexp.Compile("^(?:" + options.AllowedContainers + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid allowed containers pattern")
+		}
+		factory.allowed = allowed
+	}
+	if container == "" && factory.allowed == nil {
+		return nil, errors.New("no container given and no containers allowed")
+	}
+	if options.User != "" {
+		factory.opts = append(factory.opts, WithUser(options.User))
+	}
+
+	return factory, nil
+}
+
+func (factory *Factory) Name() string {
+	return "docker exec"
+}
+
+func (factory *Factory) New(params map[string][]string, headers map[string][]string, env map[string]string) (server.Slave, error) {
+	return factory.NewCommand(factory.command, params, headers, env)
+}
+
+// NewCommand starts command instead of the command of the factory.
+func (factory *Factory) NewCommand(command []string, params map[string][]string, headers map[string][]string, env map[string]string) (server.Slave, error) {
+	container := factory.container
+	if len(params["container"]) > 0 && params["container"][0] != "" {
+		if factory.allowed == nil || !factory.allowed.MatchString(params["container"][0]) {
+			return nil, errors.Errorf("container `%s` is not allowed", params["container"][0])
+		}
+		container = params["container"][0]
+	}
+	if container == "" {
+		return nil, errors.New("no container given")
+	}
+
+	opts := append([]Option{WithEnv(env)}, factory.opts...)
+	return New(factory.client, container, command, opts...)
+}
@@ -0,0 +1,17 @@
+package dockerexec
+
+type Option func(*DockerExec)
+
+// WithUser runs the command as user inside the container.
+func WithUser(user string) Option {
+	return func(dexec *DockerExec) {
+		dexec.user = user
+	}
+}
+
+// WithEnv adds environment variables to the command.
+func WithEnv(env map[string]string) Option {
+	return func(dexec *DockerExec) {
+		dexec.env = env
+	}
+}
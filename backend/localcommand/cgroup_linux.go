@@ -0,0 +1,106 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// create makes the cgroup of a new session and applies the limits.
+func (cgroup *Cgroup) create() (string, error) {
+	controllers := []string{}
+	if cgroup.MemoryMax != "" {
+		controllers = append(controllers, "+memory")
+	}
+	if cgroup.CPUMax != "" {
+		controllers = append(controllers, "+cpu")
+	}
+	if cgroup.PidsMax > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	if len(controllers) > 0 {
+		err := writeCgroupFile(cgroup.Parent, "cgroup.subtree_control", strings.Join(controllers, " "))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to enable cgroup controllers in `%s`", cgroup.Parent)
+		}
+	}
+
+	path, err := os.MkdirTemp(cgroup.Parent, "gotty-")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create cgroup in `%s`", cgroup.Parent)
+	}
+
+	// pairs of file name and value
+	files := [][2]string{}
+	if cgroup.MemoryMax != "" {
+		// kill the whole session rather than a random process of it
+		files = append(files, [2]string{"memory.max", cgroup.MemoryMax}, [2]string{"memory.oom.group", "1"})
+	}
+	if cgroup.CPUMax != "" {
+		files = append(files, [2]string{"cpu.max", cgroup.CPUMax})
+	}
+	if cgroup.PidsMax > 0 {
+		files = append(files, [2]string{"pids.max", strconv.Itoa(cgroup.PidsMax)})
+	}
+	for _, file := range files {
+		if err := writeCgroupFile(path, file[0], file[1]); err != nil {
+			os.Remove(path)
+			return "", errors.Wrapf(err, "failed to set %s", file[0])
+		}
+	}
+	return path, nil
+}
+
+// removeCgroup kills the processes in the cgroup and removes it.
+func removeCgroup(path string) error {
+	if err := writeCgroupFile(path, "cgroup.kill", "1"); err != nil {
+		// cgroup.kill needs Linux 5.14, kill the processes one by one
+		data, _ := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+		for _, line := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(line); err == nil {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	}
+
+	// the cgroup can only be removed once the killed processes are gone
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+// cgroupEvents returns the counter of event in an events file of the cgroup.
+func cgroupEvents(path string, file string, event string) int {
+	f, err := os.Open(filepath.Join(path, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == event {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+	return 0
+}
+
+func writeCgroupFile(path string, name string, value string) error {
+	return os.WriteFile(filepath.Join(path, name), []byte(value), 0)
+}
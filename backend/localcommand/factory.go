@@ -10,6 +10,21 @@ import (
 type Options struct {
 	CloseSignal  int `hcl:"close_signal" flagName:"close-signal" flagSName:"" flagDescribe:"Signal sent to the command process when gotty close it (default: SIGHUP)" default:"1"`
 	CloseTimeout int `hcl:"close_timeout" flagName:"close-timeout" flagSName:"" flagDescribe:"Time in seconds to force kill process after client is disconnected (default: -1)" default:"-1"`
+
+	Sandbox         bool   `hcl:"sandbox" flagName:"sandbox" flagSName:"" flagDescribe:"Run the command in new user, PID, mount, network and UTS namespaces (Linux only)" default:"false"`
+	SandboxRoot     string `hcl:"sandbox_root" flagName:"sandbox-root" flagSName:"" flagDescribe:"Directory mounted read-only as the root of the sandbox" default:"/"`
+	SandboxUID      int    `hcl:"sandbox_uid" flagName:"sandbox-uid" flagSName:"" flagDescribe:"Host user ID the sandboxed command runs as, requires root when it isn't the current user (default: current user, nobody for root)" default:"-1"`
+	SandboxGID      int    `hcl:"sandbox_gid" flagName:"sandbox-gid" flagSName:"" flagDescribe:"Host group ID the sandboxed command runs as, requires root when it isn't the current group (default: current group, nogroup for root)" default:"-1"`
+	SandboxHostname string `hcl:"sandbox_hostname" flagName:"sandbox-hostname" flagSName:"" flagDescribe:"Host name inside the sandbox" default:"sandbox"`
+
+	LimitCPU        int    `hcl:"limit_cpu" flagName:"limit-cpu" flagSName:"" flagDescribe:"CPU time in seconds each process of the command can use (Linux only, 0 for unlimited)" default:"0"`
+	LimitAS         int    `hcl:"limit_as" flagName:"limit-as" flagSName:"" flagDescribe:"Address space in MiB of each process of the command (Linux only, 0 for unlimited)" default:"0"`
+	LimitNOFILE     int    `hcl:"limit_nofile" flagName:"limit-nofile" flagSName:"" flagDescribe:"Number of files each process of the command can open (Linux only, 0 for unlimited)" default:"0"`
+	LimitNPROC      int    `hcl:"limit_nproc" flagName:"limit-nproc" flagSName:"" flagDescribe:"Number of processes of the user running the command (Linux only, 0 for unlimited)" default:"0"`
+	Cgroup          string `hcl:"cgroup" flagName:"cgroup" flagSName:"" flagDescribe:"Cgroup v2 delegated to gotty, each session gets its own cgroup below it (Linux only)" default:""`
+	CgroupMemoryMax string `hcl:"cgroup_memory_max" flagName:"cgroup-memory-max" flagSName:"" flagDescribe:"memory.max of each session cgroup (e.g. 512M)" default:""`
+	CgroupCPUMax    string `hcl:"cgroup_cpu_max" flagName:"cgroup-cpu-max" flagSName:"" flagDescribe:"cpu.max of each session cgroup (e.g. \"50000 100000\" for half a CPU)" default:""`
+	CgroupPidsMax   int    `hcl:"cgroup_pids_max" flagName:"cgroup-pids-max" flagSName:"" flagDescribe:"pids.max of each session cgroup (0 for unlimited)" default:"0"`
 }
 
 type Factory struct {
@@ -24,6 +39,30 @@ func NewFactory(command string, argv []string, options *Options) (*Factory, erro
 	if options.CloseTimeout >= 0 {
 		opts = append(opts, WithCloseTimeout(time.Duration(options.CloseTimeout)*time.Second))
 	}
+	if options.Sandbox {
+		opts = append(opts, WithSandbox(&Sandbox{
+			Root:     options.SandboxRoot,
+			UID:      options.SandboxUID,
+			GID:      options.SandboxGID,
+			Hostname: options.SandboxHostname,
+		}))
+	}
+	if options.LimitCPU > 0 || options.LimitAS > 0 || options.LimitNOFILE > 0 || options.LimitNPROC > 0 {
+		opts = append(opts, WithLimits(&Limits{
+			CPU:          uint64(options.LimitCPU),
+			AddressSpace: uint64(options.LimitAS) << 20,
+			OpenFiles:    uint64(options.LimitNOFILE),
+			Processes:    uint64(options.LimitNPROC),
+		}))
+	}
+	if options.Cgroup != "" {
+		opts = append(opts, WithCgroup(&Cgroup{
+			Parent:    options.Cgroup,
+			MemoryMax: options.CgroupMemoryMax,
+			CPUMax:    options.CgroupCPUMax,
+			PidsMax:   options.CgroupPidsMax,
+		}))
+	}
 
 	return &Factory{
 		command: command,
@@ -37,12 +76,19 @@ func (factory *Factory) Name() string {
 	return "local command"
 }
 
-func (factory *Factory) New(params map[string][]string, headers map[string][]string) (server.Slave, error) {
+func (factory *Factory) New(params map[string][]string, headers map[string][]string, env map[string]string) (server.Slave, error) {
 	argv := make([]string, len(factory.argv))
 	copy(argv, factory.argv)
 	if len(params["arg"]) > 0 {
 		argv = append(argv, params["arg"]...)
 	}
 
-	return New(factory.command, argv, headers, factory.opts...)
+	opts := append([]Option{WithEnv(env)}, factory.opts...)
+	return New(factory.command, argv, headers, opts...)
+}
+
+// NewCommand starts command instead of the command of the factory.
+func (factory *Factory) NewCommand(command []string, params map[string][]string, headers map[string][]string, env map[string]string) (server.Slave, error) {
+	opts := append([]Option{WithEnv(env)}, factory.opts...)
+	return New(command[0], command[1:], headers, opts...)
 }
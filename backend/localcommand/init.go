@@ -0,0 +1,13 @@
+package localcommand
+
+// initArg is argv[0] of gotty re-executed by New to prepare the command
+// in a sandbox or with resource limits before executing it.
+const initArg = "gotty-init"
+
+// initEnv passes the initConfig to the re-executed gotty.
+const initEnv = "GOTTY_INIT"
+
+type initConfig struct {
+	Sandbox *Sandbox `json:",omitempty"`
+	Limits  *Limits  `json:",omitempty"`
+}
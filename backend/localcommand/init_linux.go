@@ -0,0 +1,158 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Init prepares and executes the command when gotty was re-executed by New
+// for a sandbox or resource limits, otherwise it returns immediately.
+// It must be called at the very beginning of main.
+func Init() {
+	if len(os.Args) < 2 || os.Args[0] != initArg {
+		return
+	}
+
+	var config initConfig
+	err := json.Unmarshal([]byte(os.Getenv(initEnv)), &config)
+	if err == nil {
+		os.Unsetenv(initEnv)
+		err = config.run(os.Args[1], os.Args[2:])
+	}
+	// the error is shown in the terminal of the client
+	fmt.Fprintf(os.Stderr, "gotty: failed to start command: %s\r\n", err)
+	os.Exit(126)
+}
+
+func (config *initConfig) run(command string, argv []string) error {
+	if config.Sandbox != nil {
+		if err := config.Sandbox.setup(); err != nil {
+			return err
+		}
+	}
+	if config.Limits != nil {
+		if err := config.Limits.apply(); err != nil {
+			return errors.Wrapf(err, "failed to set resource limits")
+		}
+	}
+
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, append([]string{command}, argv...), os.Environ())
+}
+
+// newCmd returns the command to start, which is gotty itself
+// when the command has to be prepared by Init.
+func (lcmd *LocalCommand) newCmd() (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if lcmd.sandbox == nil && lcmd.limits == nil {
+		cmd = exec.Command(lcmd.command, lcmd.argv...)
+		cmd.Env = os.Environ()
+	} else {
+		config, err := json.Marshal(&initConfig{Sandbox: lcmd.sandbox, Limits: lcmd.limits})
+		if err != nil {
+			return nil, err
+		}
+		cmd = &exec.Cmd{
+			Path: "/proc/self/exe",
+			Args: append([]string{initArg, lcmd.command}, lcmd.argv...),
+			Env:  os.Environ(),
+		}
+		if lcmd.sandbox != nil {
+			cmd.Env, cmd.SysProcAttr, err = lcmd.sandbox.prepare()
+			if err != nil {
+				return nil, err
+			}
+		}
+		cmd.Env = append(cmd.Env, initEnv+"="+string(config))
+	}
+
+	if lcmd.cgroup != nil {
+		path, err := lcmd.cgroup.create()
+		if err != nil {
+			return nil, err
+		}
+		lcmd.cgroupPath = path
+		// the process starts in the cgroup, before it can fork
+		lcmd.cgroupDir, err = os.Open(path)
+		if err != nil {
+			lcmd.cleanup()
+			return nil, errors.Wrapf(err, "failed to open cgroup")
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(lcmd.cgroupDir.Fd())
+	}
+
+	return cmd, nil
+}
+
+// checkExitReason tells whether a resource limit killed the command after it exited.
+func (lcmd *LocalCommand) checkExitReason() string {
+	state := lcmd.cmd.ProcessState
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() && lcmd.limits != nil && lcmd.limits.CPU > 0 {
+		cpu := state.UserTime() + state.SystemTime()
+		if status.Signal() == syscall.SIGXCPU || cpu >= time.Duration(lcmd.limits.CPU)*time.Second {
+			return "CPU time limit exceeded"
+		}
+	}
+	if lcmd.cgroupPath != "" {
+		if cgroupEvents(lcmd.cgroupPath, "memory.events", "oom_kill") > 0 {
+			return "memory limit exceeded"
+		}
+		if cgroupEvents(lcmd.cgroupPath, "pids.events", "max") > 0 {
+			return "process limit reached"
+		}
+	}
+	return ""
+}
+
+// cleanup kills the processes left in the cgroup of the session and removes it.
+func (lcmd *LocalCommand) cleanup() {
+	if lcmd.cgroupPath == "" {
+		return
+	}
+	if err := removeCgroup(lcmd.cgroupPath); err != nil {
+		log.Printf("Failed to remove cgroup %s: %v", lcmd.cgroupPath, err)
+	}
+}
+
+func (limits *Limits) apply() error {
+	rlimits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_AS, limits.AddressSpace},
+		{unix.RLIMIT_NOFILE, limits.OpenFiles},
+		{unix.RLIMIT_NPROC, limits.Processes},
+	}
+	for _, rlimit := range rlimits {
+		if rlimit.value == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(rlimit.resource, &unix.Rlimit{Cur: rlimit.value, Max: rlimit.value}); err != nil {
+			return err
+		}
+	}
+	if limits.CPU > 0 {
+		// SIGXCPU at the soft limit lets the command know, SIGKILL follows a second later
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: limits.CPU, Max: limits.CPU + 1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package localcommand
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// Init does nothing, commands are only prepared by gotty on Linux.
+func Init() {}
+
+func (lcmd *LocalCommand) newCmd() (*exec.Cmd, error) {
+	if lcmd.sandbox != nil {
+		return nil, errors.New("sandbox is only supported on Linux")
+	}
+	if lcmd.limits != nil || lcmd.cgroup != nil {
+		return nil, errors.New("resource limits are only supported on Linux")
+	}
+
+	cmd := exec.Command(lcmd.command, lcmd.argv...)
+	cmd.Env = os.Environ()
+	return cmd, nil
+}
+
+func (lcmd *LocalCommand) checkExitReason() string {
+	return ""
+}
+
+func (lcmd *LocalCommand) cleanup() {}
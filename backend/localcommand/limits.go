@@ -0,0 +1,25 @@
+package localcommand
+
+// Limits are resource limits applied to the command, 0 keeps the inherited limit.
+// Every process of the session inherits them, but each process is limited on its own.
+type Limits struct {
+	// CPU is the CPU time in seconds
+	CPU uint64
+	// AddressSpace is the size of the virtual memory in bytes
+	AddressSpace uint64
+	OpenFiles    uint64
+	// Processes limits the processes of the user running the command, not only the session
+	Processes uint64
+}
+
+// Cgroup places the process tree of each session in its own cgroup v2.
+// Unlike Limits, the cgroup limits apply to the whole tree.
+type Cgroup struct {
+	// Parent is an existing cgroup delegated to gotty, such as /sys/fs/cgroup/gotty
+	Parent string
+	// MemoryMax, CPUMax and PidsMax are written to memory.max, cpu.max and pids.max
+	// of the session cgroup, empty or 0 keeps them unlimited
+	MemoryMax string
+	CPUMax    string
+	PidsMax   int
+}
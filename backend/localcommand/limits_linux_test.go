@@ -0,0 +1,48 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"strings"
+	"testing"
+)
+
+func readAll(lcmd *LocalCommand) string {
+	output := ""
+	buf := make([]byte, 1024)
+	for {
+		n, err := lcmd.Read(buf)
+		output += string(buf[:n])
+		if err != nil {
+			return output
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	lcmd, err := New("/bin/sh", []string{"-c", "ulimit -n"}, nil, WithLimits(&Limits{OpenFiles: 64}))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	defer lcmd.Close()
+
+	if output := readAll(lcmd); !strings.Contains(output, "64") {
+		t.Fatalf("Unexpected output `%s`", output)
+	}
+	if reason := lcmd.ExitReason(); reason != "" {
+		t.Fatalf("Unexpected exit reason `%s`", reason)
+	}
+}
+
+func TestLimitsCPU(t *testing.T) {
+	lcmd, err := New("/bin/sh", []string{"-c", "while :; do :; done"}, nil, WithLimits(&Limits{CPU: 1}))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	defer lcmd.Close()
+
+	readAll(lcmd)
+	if reason := lcmd.ExitReason(); reason != "CPU time limit exceeded" {
+		t.Fatalf("Unexpected exit reason `%s`", reason)
+	}
+}
@@ -22,16 +22,41 @@ type LocalCommand struct {
 
 	closeSignal  syscall.Signal
 	closeTimeout time.Duration
-
-	cmd       *exec.Cmd
-	pty       *os.File
-	ptyClosed chan struct{}
+	sandbox      *Sandbox
+	limits       *Limits
+	cgroup       *Cgroup
+	env          map[string]string
+
+	cmd        *exec.Cmd
+	pty        *os.File
+	ptyClosed  chan struct{}
+	cgroupPath string
+	// cgroupDir is open while the command starts
+	cgroupDir  *os.File
+	exitReason string
 }
 
 func New(command string, argv []string, headers map[string][]string, options ...Option) (*LocalCommand, error) {
-	cmd := exec.Command(command, argv...)
+	lcmd := &LocalCommand{
+		command: command,
+		argv:    argv,
 
-	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+		closeSignal:  DefaultCloseSignal,
+		closeTimeout: DefaultCloseTimeout,
+
+		ptyClosed: make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(lcmd)
+	}
+
+	cmd, err := lcmd.newCmd()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to prepare command `%s`", command)
+	}
+
+	cmd.Env = append(cmd.Env, "TERM=xterm-256color")
 
 	// Combine headers into key=value pairs to set as env vars
 	// Prefix the headers with "http_" so we don't overwrite any other env vars
@@ -43,29 +68,21 @@ func New(command string, argv []string, headers map[string][]string, options ...
 		// log.Printf("Adding header: %s", h)
 		cmd.Env = append(cmd.Env, h)
 	}
+	for key, value := range lcmd.env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
 
 	pty, err := pty.Start(cmd)
+	if lcmd.cgroupDir != nil {
+		lcmd.cgroupDir.Close()
+	}
 	if err != nil {
 		// todo close cmd?
+		lcmd.cleanup()
 		return nil, errors.Wrapf(err, "failed to start command `%s`", command)
 	}
-	ptyClosed := make(chan struct{})
-
-	lcmd := &LocalCommand{
-		command: command,
-		argv:    argv,
-
-		closeSignal:  DefaultCloseSignal,
-		closeTimeout: DefaultCloseTimeout,
-
-		cmd:       cmd,
-		pty:       pty,
-		ptyClosed: ptyClosed,
-	}
-
-	for _, option := range options {
-		option(lcmd)
-	}
+	lcmd.cmd = cmd
+	lcmd.pty = pty
 
 	// When the process is closed by the user,
 	// close pty so that Read() on the pty breaks with an EOF.
@@ -76,6 +93,8 @@ func New(command string, argv []string, headers map[string][]string, options ...
 		}()
 
 		lcmd.cmd.Wait()
+		lcmd.exitReason = lcmd.checkExitReason()
+		lcmd.cleanup()
 	}()
 
 	return lcmd, nil
@@ -103,6 +122,16 @@ func (lcmd *LocalCommand) Close() error {
 	}
 }
 
+// ExitReason returns why the command ended when a resource limit killed it.
+func (lcmd *LocalCommand) ExitReason() string {
+	select {
+	case <-lcmd.ptyClosed:
+		return lcmd.exitReason
+	case <-time.After(time.Second):
+		return ""
+	}
+}
+
 func (lcmd *LocalCommand) WindowTitleVariables() map[string]interface{} {
 	return map[string]interface{}{
 		"command": lcmd.command,
@@ -18,3 +18,31 @@ func WithCloseTimeout(timeout time.Duration) Option {
 		lcmd.closeTimeout = timeout
 	}
 }
+
+// WithSandbox runs the command isolated from the host, see Sandbox.
+func WithSandbox(sandbox *Sandbox) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.sandbox = sandbox
+	}
+}
+
+// WithLimits applies resource limits to the command.
+func WithLimits(limits *Limits) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.limits = limits
+	}
+}
+
+// WithCgroup runs each command in its own cgroup, see Cgroup.
+func WithCgroup(cgroup *Cgroup) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.cgroup = cgroup
+	}
+}
+
+// WithEnv adds environment variables to the command.
+func WithEnv(env map[string]string) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.env = env
+	}
+}
@@ -0,0 +1,21 @@
+package localcommand
+
+// Sandbox runs commands in new user, PID, mount, network, IPC and UTS namespaces.
+// The init of the sandbox, gotty re-executed by New, bind mounts Root
+// read-only as the new root with a private /tmp, drops every capability and
+// executes the command. The command is PID 1 of its namespace, so every process
+// it leaves behind is killed when it exits.
+type Sandbox struct {
+	// Root is the directory mounted read-only as the root of the sandbox
+	Root string
+	// UID and GID are the host IDs the command runs as, -1 for the current user,
+	// or nobody when gotty runs as root. IDs other than the current ones require root.
+	UID int
+	GID int
+	// Hostname is the host name seen inside the sandbox
+	Hostname string
+}
+
+// sandboxPath is the search path of commands in the sandbox,
+// the environment of gotty is not passed in.
+const sandboxPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
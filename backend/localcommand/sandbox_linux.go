@@ -0,0 +1,236 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sandboxMount is where the init assembles the new root, inside a private tmpfs
+	sandboxMount = "/tmp/.gotty-sandbox"
+	sandboxTmpfs = "mode=1777,size=64m"
+	nobodyID     = 65534
+)
+
+// prepare returns the environment and process attributes
+// starting the init in new namespaces.
+func (sandbox *Sandbox) prepare() ([]string, *syscall.SysProcAttr, error) {
+	uid, gid := sandbox.UID, sandbox.GID
+	if uid < 0 {
+		uid = os.Getuid()
+		if uid == 0 {
+			// never leave root on the host
+			uid = nobodyID
+		}
+	}
+	if gid < 0 {
+		gid = os.Getgid()
+		if os.Getuid() == 0 {
+			gid = nobodyID
+		}
+	}
+	if os.Getuid() != 0 && (uid != os.Getuid() || gid != os.Getgid()) {
+		return nil, nil, errors.New("running the sandbox as another user requires root")
+	}
+
+	env := []string{sandboxPath, "HOME=/tmp"}
+	if lang := os.Getenv("LANG"); lang != "" {
+		env = append(env, "LANG="+lang)
+	}
+	attr := &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC,
+		// the init is root of the namespace to set up mounts,
+		// which is uid on the host
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Credential:                 &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true},
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	return env, attr, nil
+}
+
+// setup runs in the init, it isolates the process from the host
+// and leaves it without privileges.
+func (sandbox *Sandbox) setup() error {
+	if err := unix.Sethostname([]byte(sandbox.Hostname)); err != nil {
+		return errors.Wrapf(err, "failed to set hostname")
+	}
+	if err := setLoopbackUp(); err != nil {
+		return errors.Wrapf(err, "failed to set up loopback interface")
+	}
+	if err := sandbox.mountRoot(); err != nil {
+		return err
+	}
+	if err := dropCapabilities(); err != nil {
+		return errors.Wrapf(err, "failed to drop capabilities")
+	}
+	return nil
+}
+
+// mountRoot switches to a read-only bind of Root with fresh /proc and /tmp
+// and the /dev of the host.
+func (sandbox *Sandbox) mountRoot() error {
+	// keep mounts from propagating back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return errors.Wrapf(err, "failed to make mounts private")
+	}
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", 0, ""); err != nil {
+		return errors.Wrapf(err, "failed to mount staging tmpfs")
+	}
+	if err := os.Mkdir(sandboxMount, 0700); err != nil {
+		return err
+	}
+
+	root := sandbox.Root
+	if root == "" {
+		root = "/"
+	}
+	// mounts under root are locked by the host and can only be bound recursively
+	if err := unix.Mount(root, sandboxMount, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return errors.Wrapf(err, "failed to bind `%s`", root)
+	}
+	mountPoints, err := mountPointsUnder(sandboxMount)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read mounts")
+	}
+	for _, mountPoint := range mountPoints {
+		if err := remountReadOnly(mountPoint); err != nil {
+			return errors.Wrapf(err, "failed to make `%s` read-only", mountPoint)
+		}
+	}
+
+	mounts := []struct {
+		source, target, fstype string
+		flags                  uintptr
+		data                   string
+	}{
+		{"/dev", "dev", "", unix.MS_BIND | unix.MS_REC, ""},
+		{"proc", "proc", "proc", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC, ""},
+		{"tmpfs", "tmp", "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, sandboxTmpfs},
+	}
+	for _, m := range mounts {
+		target := sandboxMount + "/" + m.target
+		if err := unix.Mount(m.source, target, m.fstype, m.flags, m.data); err != nil {
+			return errors.Wrapf(err, "failed to mount `%s`", "/"+m.target)
+		}
+	}
+
+	// pivot onto the new root and detach the old one stacked underneath
+	if err := unix.Chdir(sandboxMount); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return errors.Wrapf(err, "failed to pivot root")
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return errors.Wrapf(err, "failed to detach old root")
+	}
+	return unix.Chdir("/")
+}
+
+// mountPointsUnder lists the mount points at or below dir.
+func mountPointsUnder(dir string) ([]string, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	mountPoints := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountPoint(fields[4])
+		if mountPoint == dir || strings.HasPrefix(mountPoint, dir+"/") {
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+	return mountPoints, nil
+}
+
+// unescapeMountPoint decodes the octal escapes of spaces and such in mountinfo.
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// remountReadOnly makes a bind mount read-only, keeping the flags
+// locked by the host, which a user namespace can't clear.
+func remountReadOnly(target string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(target, &stat); err != nil {
+		return err
+	}
+	flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY)
+	locked := map[int64]uintptr{
+		unix.ST_NOSUID:     unix.MS_NOSUID,
+		unix.ST_NODEV:      unix.MS_NODEV,
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	}
+	for st, ms := range locked {
+		if int64(stat.Flags)&st != 0 {
+			flags |= ms
+		}
+	}
+	return unix.Mount("", target, "", flags, "")
+}
+
+func setLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return err
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// dropCapabilities leaves the command without privileges in the namespace,
+// although it runs as its root.
+func dropCapabilities() error {
+	for capability := 0; capability <= unix.CAP_LAST_CAP; capability++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return err
+	}
+	// version 3 takes two data structs for 64 capabilities, all cleared
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return err
+	}
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
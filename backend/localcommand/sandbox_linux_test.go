@@ -0,0 +1,35 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is re-executed as the init of sandboxes
+	Init()
+	os.Exit(m.Run())
+}
+
+func TestSandbox(t *testing.T) {
+	sandbox := &Sandbox{Root: "/", UID: -1, GID: -1, Hostname: "testbox"}
+	lcmd, err := New("/bin/sh", []string{"-c", "touch /gotty-test && echo writable; hostname; echo pid $$; ls /tmp | wc -l"}, nil, WithSandbox(sandbox))
+	if err != nil {
+		t.Skipf("user namespaces are not available: %s", err)
+	}
+	defer lcmd.Close()
+
+	output := readAll(lcmd)
+
+	for _, expected := range []string{"Read-only file system", "testbox", "pid 1", "\n0"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("Expected `%s` in output `%s`", expected, output)
+		}
+	}
+	if strings.Contains(output, "writable") {
+		t.Fatalf("Root of the sandbox is writable: `%s`", output)
+	}
+}
@@ -0,0 +1,3 @@
+// Package replay provides an implementation of webtty.Slave
+// that plays back asciicast recordings.
+package replay
@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/asciicast"
+	"gotty/server"
+)
+
+type Options struct {
+	Speed         float64 `hcl:"speed" flagName:"speed" flagSName:"" flagDescribe:"Initial playback speed" default:"1"`
+	IdleTimeLimit float64 `hcl:"idle_time_limit" flagName:"idle-time-limit" flagSName:"" flagDescribe:"Limit pauses between events to this many seconds (0 to keep the original timing)" default:"0"`
+}
+
+type Factory struct {
+	path string
+	// dir is true when path is a directory of recordings chosen by the file parameter
+	dir  bool
+	opts []Option
+}
+
+func NewFactory(path string, options *Options) (*Factory, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open recording `%s`", path)
+	}
+
+	opts := []Option{WithSpeed(options.Speed)}
+	if options.IdleTimeLimit > 0 {
+		opts = append(opts, WithIdleTimeLimit(time.Duration(options.IdleTimeLimit*float64(time.Second))))
+	}
+
+	factory := &Factory{
+		path: path,
+		dir:  info.IsDir(),
+		opts: opts,
+	}
+	if !factory.dir {
+		// fail early on a broken recording
+		if _, err := Load(path); err != nil {
+			return nil, err
+		}
+	}
+	return factory, nil
+}
+
+func (factory *Factory) Name() string {
+	return "replay"
+}
+
+func (factory *Factory) New(params map[string][]string, headers map[string][]string, env map[string]string) (server.Slave, error) {
+	if !factory.dir {
+		return Load(factory.path, factory.opts...)
+	}
+
+	if len(params["file"]) == 0 || params["file"][0] == "" {
+		return factory.listing()
+	}
+	name := params["file"][0]
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".cast") {
+		return nil, errors.Errorf("invalid recording name `%s`", name)
+	}
+	return Load(filepath.Join(factory.path, name), factory.opts...)
+}
+
+// listing returns a slave that shows the recordings in the directory.
+func (factory *Factory) listing() (*Replay, error) {
+	entries, err := os.ReadDir(factory.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read recording directory")
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".cast") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var text strings.Builder
+	if len(names) == 0 {
+		text.WriteString("No recordings found.\r\n")
+	} else {
+		text.WriteString("Recordings:\r\n\r\n")
+		for _, name := range names {
+			fmt.Fprintf(&text, "  %s\r\n", name)
+		}
+		text.WriteString("\r\nOpen ?file=<name> to play a recording.\r\n")
+	}
+
+	header := asciicast.Header{Version: asciicast.Version, Width: 80, Height: 24, Title: "recordings"}
+	events := []asciicast.Event{{Time: 0, Type: asciicast.Output, Data: text.String()}}
+	return New("", header, events, factory.opts...), nil
+}
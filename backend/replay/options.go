@@ -0,0 +1,21 @@
+package replay
+
+import (
+	"time"
+)
+
+type Option func(*Replay)
+
+// WithSpeed sets the initial playback speed.
+func WithSpeed(speed float64) Option {
+	return func(replay *Replay) {
+		replay.speed = clampSpeed(speed)
+	}
+}
+
+// WithIdleTimeLimit shortens pauses between events to limit.
+func WithIdleTimeLimit(limit time.Duration) Option {
+	return func(replay *Replay) {
+		replay.idleTimeLimit = limit
+	}
+}
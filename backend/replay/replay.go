@@ -0,0 +1,312 @@
+package replay
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/asciicast"
+)
+
+const (
+	minSpeed = 1.0 / 16
+	maxSpeed = 16.0
+)
+
+// resetSequence clears the terminal before output is replayed from the beginning.
+const resetSequence = "\x1bc"
+
+type event struct {
+	time time.Duration
+	data []byte
+}
+
+// Replay plays the output of a recording with its original timing.
+// Input from the master is ignored, playback is driven by control messages.
+type Replay struct {
+	name   string
+	header asciicast.Header
+	events []event
+
+	speed         float64
+	idleTimeLimit time.Duration
+
+	mutex sync.Mutex
+	// position is the playback position at resumedAt
+	position  time.Duration
+	resumedAt time.Time // zero while paused
+	next      int       // index of the next event to play
+	pending   []byte
+	// wake interrupts Read waiting for the next event
+	wake chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Load reads the recording at path.
+func Load(path string, options ...Option) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open recording `%s`", path)
+	}
+	defer file.Close()
+
+	reader, err := asciicast.NewReader(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read recording `%s`", path)
+	}
+	events := []asciicast.Event{}
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read recording `%s`", path)
+		}
+		events = append(events, ev)
+	}
+
+	return New(filepath.Base(path), reader.Header, events, options...), nil
+}
+
+// New creates a Replay of the output events, playback starts immediately.
+func New(name string, header asciicast.Header, events []asciicast.Event, options ...Option) *Replay {
+	replay := &Replay{
+		name:   name,
+		header: header,
+		speed:  1,
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	for _, option := range options {
+		option(replay)
+	}
+
+	var last, shift time.Duration
+	for _, ev := range events {
+		if ev.Type != asciicast.Output {
+			continue
+		}
+		t := time.Duration(ev.Time*float64(time.Second)) - shift
+		if t < last {
+			t = last
+		}
+		if replay.idleTimeLimit > 0 && t-last > replay.idleTimeLimit {
+			shift += t - last - replay.idleTimeLimit
+			t = last + replay.idleTimeLimit
+		}
+		replay.events = append(replay.events, event{time: t, data: []byte(ev.Data)})
+		last = t
+	}
+	replay.resumedAt = time.Now()
+
+	return replay
+}
+
+// Read blocks until the next event is due and returns its output.
+func (replay *Replay) Read(p []byte) (int, error) {
+	for {
+		replay.mutex.Lock()
+		if len(replay.pending) > 0 {
+			n := copy(p, replay.pending)
+			replay.pending = replay.pending[n:]
+			replay.mutex.Unlock()
+			return n, nil
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if replay.next < len(replay.events) && !replay.resumedAt.IsZero() {
+			ev := replay.events[replay.next]
+			position := replay.positionLocked()
+			if ev.time <= position {
+				replay.pending = ev.data
+				replay.next++
+				replay.mutex.Unlock()
+				continue
+			}
+			timer = time.NewTimer(time.Duration(float64(ev.time-position) / replay.speed))
+			due = timer.C
+		}
+		replay.mutex.Unlock()
+
+		select {
+		case <-due:
+		case <-replay.wake:
+		case <-replay.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, io.EOF
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Write discards input, recordings can't be typed into.
+func (replay *Replay) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (replay *Replay) WindowTitleVariables() map[string]interface{} {
+	return map[string]interface{}{
+		"command": "replay",
+		"argv":    []string{replay.name},
+		"title":   replay.header.Title,
+		"columns": replay.header.Width,
+		"rows":    replay.header.Height,
+	}
+}
+
+// ResizeTerminal does nothing, the output was recorded for a fixed size.
+func (replay *Replay) ResizeTerminal(columns int, rows int) error {
+	return nil
+}
+
+func (replay *Replay) Close() error {
+	replay.closeOnce.Do(func() {
+		close(replay.closed)
+	})
+	return nil
+}
+
+type controlMessage struct {
+	// Action is one of "pause", "resume", "speed" and "seek"
+	Action string `json:"action"`
+	// Value is the new speed, or the offset in seconds to seek by
+	Value float64 `json:"value"`
+}
+
+type controlState struct {
+	Paused   bool    `json:"paused"`
+	Speed    float64 `json:"speed"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
+// Control changes the playback and returns its state.
+func (replay *Replay) Control(message []byte) ([]byte, error) {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+
+	if message != nil {
+		var msg controlMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return nil, errors.Wrapf(err, "invalid control message")
+		}
+
+		switch msg.Action {
+		case "pause":
+			if !replay.resumedAt.IsZero() {
+				replay.position = replay.positionLocked()
+				replay.resumedAt = time.Time{}
+			}
+		case "resume":
+			if replay.endedLocked() {
+				// start over once the end was reached
+				replay.seekLocked(0)
+			}
+			if replay.resumedAt.IsZero() {
+				replay.position = replay.positionLocked()
+				replay.resumedAt = time.Now()
+			}
+		case "speed":
+			replay.position = replay.positionLocked()
+			if !replay.resumedAt.IsZero() {
+				replay.resumedAt = time.Now()
+			}
+			replay.speed = clampSpeed(msg.Value)
+		case "seek":
+			offset := time.Duration(msg.Value * float64(time.Second))
+			replay.seekLocked(replay.positionLocked() + offset)
+		default:
+			return nil, errors.Errorf("unknown control action `%s`", msg.Action)
+		}
+
+		select {
+		case replay.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return json.Marshal(controlState{
+		Paused:   replay.resumedAt.IsZero() || replay.endedLocked(),
+		Speed:    replay.speed,
+		Position: replay.positionLocked().Seconds(),
+		Duration: replay.durationLocked().Seconds(),
+	})
+}
+
+// seekLocked moves the playback to target.
+// Output up to target is queued at once, seeking backwards
+// resets the terminal and replays the recording from the beginning.
+func (replay *Replay) seekLocked(target time.Duration) {
+	if target < 0 {
+		target = 0
+	}
+	if duration := replay.durationLocked(); target > duration {
+		target = duration
+	}
+
+	var pending []byte
+	next := replay.next
+	if target < replay.positionLocked() {
+		pending = []byte(resetSequence)
+		next = 0
+	} else {
+		pending = append(pending, replay.pending...)
+	}
+	for next < len(replay.events) && replay.events[next].time <= target {
+		pending = append(pending, replay.events[next].data...)
+		next++
+	}
+
+	replay.pending = pending
+	replay.next = next
+	replay.position = target
+	if !replay.resumedAt.IsZero() {
+		replay.resumedAt = time.Now()
+	}
+}
+
+func (replay *Replay) positionLocked() time.Duration {
+	position := replay.position
+	if !replay.resumedAt.IsZero() {
+		position += time.Duration(float64(time.Since(replay.resumedAt)) * replay.speed)
+	}
+	if duration := replay.durationLocked(); position > duration {
+		position = duration
+	}
+	return position
+}
+
+// endedLocked returns true when every event has been played.
+func (replay *Replay) endedLocked() bool {
+	return replay.next >= len(replay.events) && len(replay.pending) == 0
+}
+
+func (replay *Replay) durationLocked() time.Duration {
+	if len(replay.events) == 0 {
+		return 0
+	}
+	return replay.events[len(replay.events)-1].time
+}
+
+func clampSpeed(speed float64) float64 {
+	if speed < minSpeed {
+		return minSpeed
+	}
+	if speed > maxSpeed {
+		return maxSpeed
+	}
+	return speed
+}
@@ -0,0 +1,130 @@
+package replay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotty/pkg/asciicast"
+)
+
+var testEvents = []asciicast.Event{
+	{Time: 0, Type: asciicast.Output, Data: "foo"},
+	{Time: 0.01, Type: asciicast.Input, Data: "x"},
+	{Time: 0.02, Type: asciicast.Output, Data: "bar"},
+	{Time: 30, Type: asciicast.Output, Data: "baz"},
+}
+
+func readString(t *testing.T, replay *Replay) string {
+	buf := make([]byte, 1024)
+	n, err := replay.Read(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error from Read(): %s", err)
+	}
+	return string(buf[:n])
+}
+
+func control(t *testing.T, replay *Replay, message string) controlState {
+	data, err := replay.Control([]byte(message))
+	if err != nil {
+		t.Fatalf("Unexpected error from Control(): %s", err)
+	}
+	var state controlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Invalid control state: %s", err)
+	}
+	return state
+}
+
+func TestReplaySeek(t *testing.T) {
+	replay := New("test.cast", asciicast.Header{Version: asciicast.Version}, testEvents)
+	defer replay.Close()
+
+	if out := readString(t, replay); out != "foo" {
+		t.Fatalf("Unexpected output `%q`", out)
+	}
+	if out := readString(t, replay); out != "bar" {
+		t.Fatalf("Unexpected output `%q`", out)
+	}
+
+	state := control(t, replay, `{"action":"seek","value":60}`)
+	if state.Position != 30 || state.Duration != 30 {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+	if out := readString(t, replay); out != "baz" {
+		t.Fatalf("Unexpected output `%q`", out)
+	}
+
+	// seeking backwards redraws the terminal from the beginning
+	control(t, replay, `{"action":"seek","value":-29}`)
+	if out := readString(t, replay); out != resetSequence+"foobar" {
+		t.Fatalf("Unexpected output `%q`", out)
+	}
+}
+
+func TestReplayControl(t *testing.T) {
+	replay := New("test.cast", asciicast.Header{Version: asciicast.Version}, testEvents, WithSpeed(100))
+	defer replay.Close()
+
+	state := control(t, replay, `{"action":"pause"}`)
+	if !state.Paused || state.Speed != maxSpeed {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+	position := state.Position
+	time.Sleep(10 * time.Millisecond)
+	if state := control(t, replay, `{"action":"speed","value":2}`); state.Position != position || state.Speed != 2 {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+	if state := control(t, replay, `{"action":"resume"}`); state.Paused {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+
+	if _, err := replay.Control([]byte(`{"action":"rewind"}`)); err == nil {
+		t.Fatalf("Expected an error for an unknown action")
+	}
+}
+
+func TestReplayIdleTimeLimit(t *testing.T) {
+	replay := New("test.cast", asciicast.Header{Version: asciicast.Version}, testEvents, WithIdleTimeLimit(time.Second))
+	defer replay.Close()
+
+	if duration := replay.durationLocked(); duration != 1020*time.Millisecond {
+		t.Fatalf("Unexpected duration %s", duration)
+	}
+}
+
+func TestFactoryDirectory(t *testing.T) {
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "test.cast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := asciicast.NewWriter(file, asciicast.Header{Width: 80, Height: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteEvent(asciicast.Output, []byte("foo"))
+	file.Close()
+
+	factory, err := NewFactory(dir, &Options{Speed: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFactory(): %s", err)
+	}
+
+	slave, err := factory.New(map[string][]string{"file": {"test.cast"}}, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	if out := readString(t, slave.(*Replay)); out != "foo" {
+		t.Fatalf("Unexpected output `%q`", out)
+	}
+	slave.Close()
+
+	for _, name := range []string{"../test.cast", "test.txt", filepath.Join(dir, "test.cast")} {
+		if _, err := factory.New(map[string][]string{"file": {name}}, nil, nil); err == nil {
+			t.Fatalf("Expected an error for `%s`", name)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package sshcommand provides an implementation of webtty.Slave
+// that opens a PTY session on a remote host over SSH.
+package sshcommand
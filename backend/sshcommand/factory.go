@@ -0,0 +1,221 @@
+package sshcommand
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"gotty/pkg/homedir"
+	"gotty/server"
+)
+
+type Options struct {
+	User                  string `hcl:"ssh_user" flagName:"user" flagSName:"l" flagDescribe:"User to log in as on the remote host (default: current user)" default:""`
+	Port                  int    `hcl:"ssh_port" flagName:"ssh-port" flagSName:"" flagDescribe:"Port of the SSH server" default:"22"`
+	IdentityFile          string `hcl:"ssh_identity_file" flagName:"identity-file" flagSName:"i" flagDescribe:"Private key file, comma separated for several (default: ~/.ssh/id_ed25519, id_ecdsa and id_rsa)" default:""`
+	UseAgent              bool   `hcl:"ssh_agent" flagName:"agent" flagSName:"" flagDescribe:"Authenticate with keys from the agent at SSH_AUTH_SOCK" default:"true"`
+	KnownHostsFile        string `hcl:"ssh_known_hosts_file" flagName:"known-hosts-file" flagSName:"" flagDescribe:"Known hosts file to verify host keys with" default:"~/.ssh/known_hosts"`
+	InsecureIgnoreHostKey bool   `hcl:"ssh_insecure_ignore_host_key" flagName:"insecure-ignore-host-key" flagSName:"" flagDescribe:"Don't verify host keys (insecure)" default:"false"`
+	AllowedHosts          string `hcl:"ssh_allowed_hosts" flagName:"allowed-hosts" flagSName:"" flagDescribe:"Comma separated host patterns clients may choose with the host parameter, requires --permit-arguments" default:""`
+	AllowedUsers          string `hcl:"ssh_allowed_users" flagName:"allowed-users" flagSName:"" flagDescribe:"Comma separated user patterns clients may choose with the user parameter, requires --permit-arguments" default:""`
+	ConnectTimeout        int    `hcl:"ssh_connect_timeout" flagName:"connect-timeout" flagSName:"" flagDescribe:"Timeout in seconds to connect to the remote host" default:"10"`
+	CloseSignal           string `hcl:"ssh_close_signal" flagName:"ssh-close-signal" flagSName:"" flagDescribe:"Signal sent to the remote command when gotty closes it (e.g. HUP, TERM, KILL)" default:"HUP"`
+	CloseTimeout          int    `hcl:"ssh_close_timeout" flagName:"ssh-close-timeout" flagSName:"" flagDescribe:"Time in seconds to close the connection after the close signal when the remote command doesn't exit" default:"10"`
+}
+
+var defaultIdentityFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// closeSignals are the signals defined by RFC 4254.
+var closeSignals = map[ssh.Signal]bool{
+	ssh.SIGABRT: true, ssh.SIGALRM: true, ssh.SIGFPE: true, ssh.SIGHUP: true, ssh.SIGILL: true,
+	ssh.SIGINT: true, ssh.SIGKILL: true, ssh.SIGPIPE: true, ssh.SIGQUIT: true, ssh.SIGSEGV: true,
+	ssh.SIGTERM: true, ssh.SIGUSR1: true, ssh.SIGUSR2: true,
+}
+
+type Factory struct {
+	host    string
+	command string
+	options *Options
+
+	signers         []ssh.Signer
+	hostKeyCallback ssh.HostKeyCallback
+	allowedHosts    []string
+	allowedUsers    []string
+	opts            []Option
+}
+
+// NewFactory creates a factory for sessions on host.
+// host is "[user@]host[:port]", it can be empty when clients choose the host.
+func NewFactory(host string, command string, options *Options) (*Factory, error) {
+	factory := &Factory{
+		host:         host,
+		command:      command,
+		options:      options,
+		allowedHosts: splitList(options.AllowedHosts),
+		allowedUsers: splitList(options.AllowedUsers),
+	}
+	if host == "" && len(factory.allowedHosts) == 0 {
+		return nil, errors.New("no host given and no hosts allowed")
+	}
+
+	closeSignal := ssh.Signal(strings.TrimPrefix(strings.ToUpper(options.CloseSignal), "SIG"))
+	if !closeSignals[closeSignal] {
+		return nil, errors.Errorf("unsupported close signal `%s`", options.CloseSignal)
+	}
+	if options.CloseTimeout < 0 {
+		return nil, errors.Errorf("invalid close timeout `%d`", options.CloseTimeout)
+	}
+	factory.opts = []Option{
+		WithCloseSignal(closeSignal),
+		WithCloseTimeout(time.Duration(options.CloseTimeout) * time.Second),
+	}
+
+	var err error
+	factory.signers, err = loadSigners(options.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(factory.signers) == 0 && !options.UseAgent {
+		return nil, errors.New("no private key found and agent is disabled")
+	}
+
+	if options.InsecureIgnoreHostKey {
+		factory.hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		factory.hostKeyCallback, err = knownhosts.New(homedir.Expand(options.KnownHostsFile))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load known hosts file `%s`", options.KnownHostsFile)
+		}
+	}
+
+	return factory, nil
+}
+
+func (factory *Factory) Name() string {
+	return "ssh"
+}
+
+func (factory *Factory) New(params map[string][]string, headers map[string][]string, env map[string]string) (server.Slave, error) {
+	userName, host := splitUserHost(factory.host)
+	if len(params["host"]) > 0 && params["host"][0] != "" {
+		if !matchAny(factory.allowedHosts, params["host"][0]) {
+			return nil, errors.Errorf("host `%s` is not allowed", params["host"][0])
+		}
+		host = params["host"][0]
+	}
+	if len(params["user"]) > 0 && params["user"][0] != "" {
+		if !matchAny(factory.allowedUsers, params["user"][0]) {
+			return nil, errors.Errorf("user `%s` is not allowed", params["user"][0])
+		}
+		userName = params["user"][0]
+	}
+	if host == "" {
+		return nil, errors.New("no host given")
+	}
+	if userName == "" {
+		userName = factory.options.User
+	}
+	if userName == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get current user")
+		}
+		userName = current.Username
+	}
+
+	address := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		address = net.JoinHostPort(host, strconv.Itoa(factory.options.Port))
+	}
+
+	config := &ssh.ClientConfig{
+		User:            userName,
+		HostKeyCallback: factory.hostKeyCallback,
+		Timeout:         time.Duration(factory.options.ConnectTimeout) * time.Second,
+	}
+
+	signers := factory.signers
+	if factory.options.UseAgent {
+		if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+			conn, err := net.Dial("unix", socket)
+			if err == nil {
+				// the agent is only needed for the handshake
+				defer conn.Close()
+				if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+					signers = append(signers[:len(signers):len(signers)], agentSigners...)
+				}
+			}
+		}
+	}
+	// a single method, the client doesn't try the same method twice
+	config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+
+	opts := append([]Option{WithEnv(env)}, factory.opts...)
+	return New(address, config, factory.command, opts...)
+}
+
+// loadSigners reads the comma separated key files,
+// or the default keys that exist when files is empty.
+func loadSigners(files string) ([]ssh.Signer, error) {
+	paths := splitList(files)
+	optional := len(paths) == 0
+	if optional {
+		paths = defaultIdentityFiles
+	}
+
+	signers := []ssh.Signer{}
+	for _, p := range paths {
+		pemBytes, err := os.ReadFile(homedir.Expand(p))
+		if err != nil {
+			if optional && os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to read private key `%s`", p)
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if err != nil {
+			if _, ok := err.(*ssh.PassphraseMissingError); ok && optional {
+				// encrypted keys are used through the agent
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to parse private key `%s`", p)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func splitUserHost(target string) (string, string) {
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		return target[:i], target[i+1:]
+	}
+	return "", target
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
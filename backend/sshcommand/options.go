@@ -0,0 +1,28 @@
+package sshcommand
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Option func(*SSHCommand)
+
+func WithCloseSignal(signal ssh.Signal) Option {
+	return func(scmd *SSHCommand) {
+		scmd.closeSignal = signal
+	}
+}
+
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(scmd *SSHCommand) {
+		scmd.closeTimeout = timeout
+	}
+}
+
+// WithEnv asks the server to set environment variables of the command.
+func WithEnv(env map[string]string) Option {
+	return func(scmd *SSHCommand) {
+		scmd.env = env
+	}
+}
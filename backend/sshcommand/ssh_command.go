@@ -0,0 +1,165 @@
+package sshcommand
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultCloseSignal  = ssh.SIGHUP
+	DefaultCloseTimeout = 10 * time.Second
+
+	defaultColumns = 80
+	defaultRows    = 24
+)
+
+// SSHCommand is a shell or a command running in a PTY on a remote host.
+type SSHCommand struct {
+	address string
+	user    string
+	command string
+
+	closeSignal  ssh.Signal
+	closeTimeout time.Duration
+	env          map[string]string
+
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+	done    chan struct{}
+}
+
+// New connects to address and starts command, or a login shell when command is empty.
+func New(address string, config *ssh.ClientConfig, command string, options ...Option) (*SSHCommand, error) {
+	client, err := ssh.Dial("tcp", address, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to `%s`", address)
+	}
+
+	scmd := &SSHCommand{
+		address: address,
+		user:    config.User,
+		command: command,
+
+		closeSignal:  DefaultCloseSignal,
+		closeTimeout: DefaultCloseTimeout,
+
+		client: client,
+		done:   make(chan struct{}),
+	}
+	for _, option := range options {
+		option(scmd)
+	}
+
+	if err := scmd.start(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer close(scmd.done)
+		err := scmd.session.Wait()
+		var exitErr *ssh.ExitError
+		switch {
+		case err == nil:
+			log.Printf("Command on %s exited", scmd.address)
+		case errors.As(err, &exitErr):
+			log.Printf("Command on %s exited with status %d", scmd.address, exitErr.ExitStatus())
+		default:
+			log.Printf("Session on %s ended: %v", scmd.address, err)
+		}
+		scmd.client.Close()
+	}()
+
+	return scmd, nil
+}
+
+func (scmd *SSHCommand) start() error {
+	session, err := scmd.client.NewSession()
+	if err != nil {
+		return errors.Wrapf(err, "failed to open session on `%s`", scmd.address)
+	}
+	scmd.session = session
+
+	for key, value := range scmd.env {
+		// servers only accept the variables allowed by their AcceptEnv
+		session.Setenv(key, value)
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 38400,
+		ssh.TTY_OP_OSPEED: 38400,
+	}
+	if err := session.RequestPty("xterm-256color", defaultRows, defaultColumns, modes); err != nil {
+		return errors.Wrapf(err, "failed to request PTY on `%s`", scmd.address)
+	}
+
+	if scmd.stdin, err = session.StdinPipe(); err != nil {
+		return errors.Wrapf(err, "failed to open stdin")
+	}
+	if scmd.stdout, err = session.StdoutPipe(); err != nil {
+		return errors.Wrapf(err, "failed to open stdout")
+	}
+
+	if scmd.command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(scmd.command)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to start command on `%s`", scmd.address)
+	}
+	return nil
+}
+
+func (scmd *SSHCommand) Read(p []byte) (n int, err error) {
+	return scmd.stdout.Read(p)
+}
+
+func (scmd *SSHCommand) Write(p []byte) (n int, err error) {
+	return scmd.stdin.Write(p)
+}
+
+// Close sends the close signal to the remote command and
+// closes the connection when it doesn't exit within the close timeout.
+func (scmd *SSHCommand) Close() error {
+	select {
+	case <-scmd.done:
+		return nil
+	default:
+	}
+
+	// not every server supports signals, closing stdin hangs up the PTY anyway
+	scmd.session.Signal(scmd.closeSignal)
+	scmd.stdin.Close()
+	select {
+	case <-scmd.done:
+	case <-time.After(scmd.closeTimeout):
+		scmd.client.Close()
+		<-scmd.done
+	}
+	return nil
+}
+
+func (scmd *SSHCommand) WindowTitleVariables() map[string]interface{} {
+	host, _, _ := net.SplitHostPort(scmd.address)
+	return map[string]interface{}{
+		"command":  "ssh",
+		"argv":     strings.Fields(scmd.command),
+		"user":     scmd.user,
+		"host":     host,
+		"hostname": host,
+	}
+}
+
+func (scmd *SSHCommand) ResizeTerminal(width int, height int) error {
+	return scmd.session.WindowChange(height, width)
+}
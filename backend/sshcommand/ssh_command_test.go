@@ -0,0 +1,260 @@
+package sshcommand
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an in-process SSH server whose shell echoes input back.
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	resizes  chan [2]uint32
+	signals  chan string
+}
+
+func newTestServer(t *testing.T, clientKey ssh.PublicKey) (*testServer, ssh.PublicKey) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "alice" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{listener: listener, config: config, resizes: make(chan [2]uint32, 10), signals: make(chan string, 10)}
+	go ts.serve()
+	t.Cleanup(func() { listener.Close() })
+	return ts, hostSigner.PublicKey()
+}
+
+func (ts *testServer) serve() {
+	for {
+		conn, err := ts.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(conn, ts.config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go ts.handleSession(channel, requests)
+			}
+		}()
+	}
+}
+
+func (ts *testServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(true, nil)
+		case "signal":
+			var signal struct{ Name string }
+			ssh.Unmarshal(req.Payload, &signal)
+			ts.signals <- signal.Name
+			req.Reply(true, nil)
+		case "window-change":
+			ts.resizes <- [2]uint32{binary.BigEndian.Uint32(req.Payload), binary.BigEndian.Uint32(req.Payload[4:])}
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				buf := make([]byte, 1024)
+				for {
+					n, err := channel.Read(buf)
+					if err != nil {
+						break
+					}
+					channel.Write(buf[:n])
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// writeKeyFile writes a new client key and returns its path and public key.
+func writeKeyFile(t *testing.T) (string, ssh.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, signer.PublicKey()
+}
+
+func writeKnownHosts(t *testing.T, address string, hostKey ssh.PublicKey) string {
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, hostKey)
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return knownHostsFile
+}
+
+func newTestOptions(keyFile string, knownHostsFile string) *Options {
+	return &Options{
+		Port:           22,
+		IdentityFile:   keyFile,
+		KnownHostsFile: knownHostsFile,
+		ConnectTimeout: 5,
+		CloseSignal:    "HUP",
+		CloseTimeout:   10,
+	}
+}
+
+func TestSSHCommand(t *testing.T) {
+	keyFile, clientKey := writeKeyFile(t)
+	ts, hostKey := newTestServer(t, clientKey)
+	address := ts.listener.Addr().String()
+	knownHostsFile := writeKnownHosts(t, address, hostKey)
+
+	options := newTestOptions(keyFile, knownHostsFile)
+	options.CloseSignal = "SIGTERM"
+	factory, err := NewFactory("alice@"+address, "", options)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFactory(): %s", err)
+	}
+	slave, err := factory.New(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	if _, err := slave.Write([]byte("foobar")); err != nil {
+		t.Fatalf("Unexpected error from Write(): %s", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := slave.Read(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error from Read(): %s", err)
+	}
+	if string(buf[:n]) != "foobar" {
+		t.Fatalf("Unexpected output `%s`", buf[:n])
+	}
+
+	if err := slave.ResizeTerminal(120, 40); err != nil {
+		t.Fatalf("Unexpected error from ResizeTerminal(): %s", err)
+	}
+	select {
+	case size := <-ts.resizes:
+		if size != [2]uint32{120, 40} {
+			t.Fatalf("Unexpected window change %v", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No window change received")
+	}
+
+	if err := slave.Close(); err != nil {
+		t.Fatalf("Unexpected error from Close(): %s", err)
+	}
+	select {
+	case signal := <-ts.signals:
+		if signal != "TERM" {
+			t.Fatalf("Unexpected close signal %s", signal)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No close signal received")
+	}
+}
+
+func TestFactoryCloseOptions(t *testing.T) {
+	keyFile, _ := writeKeyFile(t)
+	options := newTestOptions(keyFile, "")
+	options.InsecureIgnoreHostKey = true
+
+	options.CloseSignal = "STOP"
+	if _, err := NewFactory("example.com", "", options); err == nil {
+		t.Fatalf("No error from NewFactory() with an unsupported close signal")
+	}
+	options.CloseSignal = "HUP"
+	options.CloseTimeout = -1
+	if _, err := NewFactory("example.com", "", options); err == nil {
+		t.Fatalf("No error from NewFactory() with a negative close timeout")
+	}
+}
+
+func TestSSHCommandHostKeyMismatch(t *testing.T) {
+	keyFile, clientKey := writeKeyFile(t)
+	ts, _ := newTestServer(t, clientKey)
+	address := ts.listener.Addr().String()
+	// the known hosts file lists another key for the address
+	knownHostsFile := writeKnownHosts(t, address, clientKey)
+
+	factory, err := NewFactory("alice@"+address, "", newTestOptions(keyFile, knownHostsFile))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFactory(): %s", err)
+	}
+	if _, err := factory.New(nil, nil, nil); err == nil || !strings.Contains(err.Error(), "key mismatch") {
+		t.Fatalf("Expected a host key mismatch, got %v", err)
+	}
+}
+
+func TestFactoryAllowedHosts(t *testing.T) {
+	keyFile, _ := writeKeyFile(t)
+	options := newTestOptions(keyFile, "")
+	options.InsecureIgnoreHostKey = true
+	options.AllowedHosts = "*.example.com"
+	options.AllowedUsers = "alice, bob"
+
+	factory, err := NewFactory("", "", options)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFactory(): %s", err)
+	}
+	for _, params := range []map[string][]string{
+		{},
+		{"host": {"evil.test"}},
+		{"host": {"db.example.com"}, "user": {"root"}},
+	} {
+		if _, err := factory.New(params, nil, nil); err == nil || strings.Contains(err.Error(), "connect") {
+			t.Fatalf("Expected %v to be rejected, got %v", params, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	cli "github.com/urfave/cli/v2"
+
+	"gotty/backend/dockerexec"
+	"gotty/server"
+	"gotty/utils"
+)
+
+// dockerCommand serves commands running in containers through the Docker Engine API.
+// Without a container, clients choose one from --allowed-containers with the container parameter.
+func dockerCommand(appOptions *server.Options, cliFlags []cli.Flag, flagMappings map[string]string) *cli.Command {
+	dockerOptions := &dockerexec.Options{}
+	if err := utils.ApplyDefaultValues(dockerOptions); err != nil {
+		exit(err, 1)
+	}
+	dockerFlags, dockerMappings, err := utils.GenerateFlags(dockerOptions)
+	if err != nil {
+		exit(err, 3)
+	}
+
+	return &cli.Command{
+		Name:      "docker",
+		Usage:     "Open terminals in running containers",
+		ArgsUsage: "[container [command...]]",
+		Flags:     dockerFlags,
+		Action: func(c *cli.Context) error {
+			loadConfigFile(c, appOptions, dockerOptions)
+			utils.ApplyFlags(cliFlags, flagMappings, c, appOptions)
+			utils.ApplyFlags(dockerFlags, dockerMappings, c, dockerOptions)
+			prepareOptions(c, appOptions)
+
+			args := c.Args()
+			factory, err := dockerexec.NewFactory(args.First(), args.Tail(), dockerOptions)
+			if err != nil {
+				exit(err, 3)
+			}
+
+			hostname, _ := os.Hostname()
+			appOptions.TitleVariables = map[string]interface{}{
+				"command":  "docker",
+				"argv":     args.Slice(),
+				"hostname": hostname,
+			}
+
+			target := args.First()
+			if target == "" {
+				target = dockerOptions.AllowedContainers
+			}
+			serve(factory, appOptions, fmt.Sprintf("container: %s", target))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/term"
+
+	"gotty/pkg/passwd"
+)
+
+// hashPasswordCommand prints the argon2id hash of a password for credential,
+// users or an htpasswd file. The password is read from the terminal without
+// echo, or from the first line of the standard input.
+func hashPasswordCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "hash-password",
+		Usage: "Hash a password for the configuration",
+		Action: func(c *cli.Context) error {
+			password, err := readPassword()
+			if err != nil {
+				exit(err, 1)
+			}
+			if password == "" {
+				exit(fmt.Errorf("Error: The password is empty."), 1)
+			}
+
+			hash, err := passwd.Hash(password)
+			if err != nil {
+				exit(err, 3)
+			}
+			fmt.Println(hash)
+			return nil
+		},
+	}
+}
+
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirmation, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirmation) {
+		return "", fmt.Errorf("Error: The passwords don't match.")
+	}
+	return string(password), nil
+}
@@ -5,6 +5,7 @@ import * as XLSX from 'xlsx';
 import * as mammoth from 'mammoth';
 import * as Papa from 'papaparse';
 import * as pdfjsLib from 'pdfjs-dist';
+import { tusUpload } from './tus';
 
 interface FileInfo {
     name: string;
@@ -13,6 +14,13 @@ interface FileInfo {
     isDir: boolean;
 }
 
+interface RootInfo {
+    name: string;
+    readOnly: boolean;
+    maxUploadSize?: number;
+    quota?: number;
+}
+
 interface FileManagerProps {
     onClose: () => void;
 }
@@ -36,8 +44,8 @@ interface BatchUploadStats {
     remainingTime: number; // seconds
 }
 
-const CHUNK_SIZE = 5 * 1024 * 1024; // 5MB per chunk
-const LARGE_FILE_SIZE = 10 * 1024 * 1024; // Files larger than 10MB use chunked upload
+const DEFAULT_CHUNK_SIZE = 5 * 1024 * 1024; // 5MB per chunk unless the server sets it
+const LARGE_FILE_SIZE = 10 * 1024 * 1024; // Files larger than 10MB use resumable upload
 
 export const FileManager = ({ onClose }: FileManagerProps) => {
     // Load initial state from sessionStorage
@@ -47,6 +55,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
             if (saved) {
                 const state = JSON.parse(saved);
                 return {
+                    root: state.root || '',
                     currentPath: state.currentPath || '.',
                     pathHistory: state.pathHistory || ['.'],
                     selectedFiles: new Set<string>(state.selectedFiles || [])
@@ -56,6 +65,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
             console.error('Failed to load saved state:', err);
         }
         return {
+            root: '',
             currentPath: '.',
             pathHistory: ['.'],
             selectedFiles: new Set<string>()
@@ -64,6 +74,9 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
 
     const initialState = loadSavedState();
 
+    const [roots, setRoots] = useState<RootInfo[]>([]);
+    const [root, setRoot] = useState<string>(initialState.root);
+    const [chunkSize, setChunkSize] = useState<number>(DEFAULT_CHUNK_SIZE);
     const [files, setFiles] = useState<FileInfo[]>([]);
     const [selectedFiles, setSelectedFiles] = useState<Set<string>>(initialState.selectedFiles);
     const [uploading, setUploading] = useState(false);
@@ -77,6 +90,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
     const [confirmDelete, setConfirmDelete] = useState<{ file: FileInfo; show: boolean } | null>(null);
     const [confirmBatchDelete, setConfirmBatchDelete] = useState<{ files: string[]; show: boolean } | null>(null);
     const [confirmDownload, setConfirmDownload] = useState<{ file: FileInfo; show: boolean } | null>(null);
+    const [pathDialog, setPathDialog] = useState<{ action: 'mkdir' | 'rename' | 'copy'; file?: FileInfo; value: string } | null>(null);
     const [previewFile, setPreviewFile] = useState<{ file: FileInfo; content: string | null; type: string } | null>(null);
     const [downloadProgress, setDownloadProgress] = useState<{ filename: string; progress: number } | null>(null);
     const [batchDownloadProgress, setBatchDownloadProgress] = useState<{ 
@@ -116,7 +130,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
         const auth = sessionStorage.getItem('gotty_auth');
         if (auth) {
             return {
-                'Authorization': `Basic ${auth}`
+                'Authorization': `Bearer ${auth}`
             };
         }
         return {};
@@ -145,14 +159,50 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
         return `${hours}小时${mins}分`;
     };
 
+    // Add the selected root to a file API URL
+    const withRoot = (url: string) => {
+        if (!root) return url;
+        return `${url}${url.includes('?') ? '&' : '?'}root=${encodeURIComponent(root)}`;
+    };
+
+    const readOnly = roots.find(r => r.name === root)?.readOnly ?? false;
+
+    useEffect(() => {
+        const loadRoots = async () => {
+            try {
+                const response = await fetch('api/roots', { headers: getAuthHeaders() });
+                if (!response.ok) return;
+                const data = await response.json();
+                const loadedRoots: RootInfo[] = data.roots || [];
+                setRoots(loadedRoots);
+                if (data.chunkSize > 0) setChunkSize(data.chunkSize);
+                // the first root is the default one
+                if (loadedRoots.length > 0 && !loadedRoots.some(r => r.name === root)) {
+                    setRoot(loadedRoots[0].name);
+                }
+            } catch (err) {
+                console.error('Failed to load roots:', err);
+            }
+        };
+        loadRoots();
+    }, []);
+
     useEffect(() => {
         loadFiles(currentPath);
-    }, [currentPath]);
+    }, [currentPath, root]);
+
+    const changeRoot = (name: string) => {
+        setRoot(name);
+        setCurrentPath('.');
+        setPathHistory(['.']);
+        setSelectedFiles(new Set());
+    };
 
     // Save state to sessionStorage whenever it changes
     useEffect(() => {
         try {
             const stateToSave = {
+                root,
                 currentPath,
                 pathHistory,
                 selectedFiles: Array.from(selectedFiles)
@@ -161,13 +211,13 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
         } catch (err) {
             console.error('Failed to save state:', err);
         }
-    }, [currentPath, pathHistory, selectedFiles]);
+    }, [root, currentPath, pathHistory, selectedFiles]);
 
     const loadFiles = async (path: string = '.') => {
         setLoading(true);
         setError(null);
         try {
-            const response = await fetch(`api/files?path=${encodeURIComponent(path)}`, {
+            const response = await fetch(withRoot(`api/files?path=${encodeURIComponent(path)}`), {
                 headers: getAuthHeaders()
             });
             if (!response.ok) {
@@ -225,47 +275,32 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
 
     const uploadChunkedFile = async (file: File, relativePath: string) => {
         const fileId = `${Date.now()}_${Math.random().toString(36).substr(2, 9)}`;
-        const totalChunks = Math.ceil(file.size / CHUNK_SIZE);
-        
+
         setUploadProgress(prev => ({
             ...prev,
             [fileId]: { progress: 0, total: file.size, filename: file.name }
         }));
 
-        for (let chunkIndex = 0; chunkIndex < totalChunks; chunkIndex++) {
-            const start = chunkIndex * CHUNK_SIZE;
-            const end = Math.min(start + CHUNK_SIZE, file.size);
-            const chunk = file.slice(start, end);
-
-            const formData = new FormData();
-            formData.append('chunk', chunk);
-            formData.append('chunkIndex', chunkIndex.toString());
-            formData.append('totalChunks', totalChunks.toString());
-            formData.append('fileId', fileId);
-            formData.append('filename', relativePath);
-            formData.append('path', currentPath);
-
-            const response = await fetch('api/upload-chunk', {
-                method: 'POST',
+        try {
+            // resumable upload, retried and resumed at the offset of the server on failures
+            await tusUpload({
+                endpoint: withRoot('api/tus'),
+                file,
+                metadata: { filename: relativePath, path: currentPath },
+                chunkSize,
                 headers: getAuthHeaders(),
-                body: formData,
+                onProgress: (uploaded) => setUploadProgress(prev => ({
+                    ...prev,
+                    [fileId]: { ...prev[fileId], progress: uploaded }
+                }))
+            });
+        } finally {
+            setUploadProgress(prev => {
+                const newProgress = { ...prev };
+                delete newProgress[fileId];
+                return newProgress;
             });
-
-            if (!response.ok) {
-                throw new Error(`Chunk ${chunkIndex} upload failed`);
-            }
-
-            setUploadProgress(prev => ({
-                ...prev,
-                [fileId]: { ...prev[fileId], progress: end }
-            }));
         }
-
-        setUploadProgress(prev => {
-            const newProgress = { ...prev };
-            delete newProgress[fileId];
-            return newProgress;
-        });
     };
 
     const handleBatchUpload = async (fileList: FileList) => {
@@ -368,19 +403,28 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
                     xhr.addEventListener('load', () => {
                         if (xhr.status >= 200 && xhr.status < 300) {
                             resolve(xhr.response);
-                        } else {
-                            reject(new Error('Batch upload failed'));
+                            return;
                         }
+                        let message = 'Batch upload failed';
+                        try {
+                            const result = JSON.parse(xhr.responseText);
+                            if (result.errors) {
+                                message = result.errors.map((e: { filename: string; error: string }) => `${e.filename}: ${e.error}`).join('\n');
+                            }
+                        } catch (e) {
+                            // not a JSON response
+                        }
+                        reject(new Error(message));
                     });
                     
                     xhr.addEventListener('error', () => reject(new Error('Batch upload failed')));
                     
-                    xhr.open('POST', 'api/upload');
+                    xhr.open('POST', withRoot('api/upload'));
                     
                     // Add auth headers
                     const auth = sessionStorage.getItem('gotty_auth');
                     if (auth) {
-                        xhr.setRequestHeader('Authorization', `Basic ${auth}`);
+                        xhr.setRequestHeader('Authorization', `Bearer ${auth}`);
                     }
                     
                     xhr.send(formData);
@@ -504,7 +548,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
         } else {
             const filePath = getFilePath(file.name);
             await downloadWithProgress(
-                `api/download?file=${encodeURIComponent(filePath)}`,
+                withRoot(`api/download?file=${encodeURIComponent(filePath)}`),
                 file.name
             );
         }
@@ -533,12 +577,12 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
             await new Promise<void>((resolve, reject) => {
                 const xhr = new XMLHttpRequest();
                 
-                xhr.open('POST', 'api/batch-download');
+                xhr.open('POST', withRoot('api/batch-download'));
                 
                 // Add auth headers
                 const auth = sessionStorage.getItem('gotty_auth');
                 if (auth) {
-                    xhr.setRequestHeader('Authorization', `Basic ${auth}`);
+                    xhr.setRequestHeader('Authorization', `Bearer ${auth}`);
                 }
                 xhr.setRequestHeader('Content-Type', 'application/json');
                 
@@ -638,7 +682,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
         const filePath = getFilePath(file.name);
 
         try {
-            const response = await fetch(`api/download?file=${encodeURIComponent(filePath)}&preview=true`, {
+            const response = await fetch(withRoot(`api/download?file=${encodeURIComponent(filePath)}&preview=true`), {
                 headers: getAuthHeaders()
             });
 
@@ -980,6 +1024,38 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
         setConfirmDelete({ file, show: true });
     };
 
+    // fileOperation posts body to a file operation API and throws its JSON error
+    const fileOperation = async (url: string, body: Record<string, unknown>) => {
+        const response = await fetch(withRoot(url), {
+            method: 'POST',
+            headers: { ...getAuthHeaders(), 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        if (!response.ok) {
+            const data = await response.json().catch(() => null);
+            throw new Error(data?.error || 'Operation failed');
+        }
+    };
+
+    const confirmPathDialogAction = async () => {
+        if (!pathDialog || !pathDialog.value.trim()) return;
+
+        const { action, file, value } = pathDialog;
+        setPathDialog(null);
+        setError(null);
+
+        try {
+            if (action === 'mkdir') {
+                await fileOperation('api/mkdir', { path: getFilePath(value.trim()) });
+            } else {
+                await fileOperation(`api/${action}`, { from: getFilePath(file!.name), to: value.trim() });
+            }
+            await loadFiles(currentPath);
+        } catch (err) {
+            setError(err instanceof Error ? err.message : 'Operation failed');
+        }
+    };
+
     const confirmDeleteAction = async () => {
         if (!confirmDelete) return;
 
@@ -989,7 +1065,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
 
         try {
             const filePath = getFilePath(file.name);
-            const response = await fetch(`api/delete?file=${encodeURIComponent(filePath)}`, {
+            const response = await fetch(withRoot(`api/delete?file=${encodeURIComponent(filePath)}`), {
                 method: 'DELETE',
                 headers: getAuthHeaders()
             });
@@ -1025,7 +1101,7 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
             // Delete files one by one
             for (const fileName of filesToDelete) {
                 const filePath = getFilePath(fileName);
-                const response = await fetch(`api/delete?file=${encodeURIComponent(filePath)}`, {
+                const response = await fetch(withRoot(`api/delete?file=${encodeURIComponent(filePath)}`), {
                     method: 'DELETE',
                     headers: getAuthHeaders()
                 });
@@ -1343,6 +1419,19 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
             <div className="file-manager" onClick={(e) => e.stopPropagation()}>
                 <div className="file-manager-header">
                     <h2>文件管理器</h2>
+                    {roots.length > 1 && (
+                        <select
+                            className="root-select"
+                            value={root}
+                            onChange={(e) => changeRoot((e.target as HTMLSelectElement).value)}
+                        >
+                            {roots.map(r => (
+                                <option key={r.name} value={r.name}>
+                                    {r.readOnly ? `${r.name}（只读）` : r.name}
+                                </option>
+                            ))}
+                        </select>
+                    )}
                     <button className="close-btn" onClick={onClose}>×</button>
                 </div>
 
@@ -1385,18 +1474,25 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
                             style={{ display: 'none' }}
                             {...({ webkitdirectory: 'true', directory: 'true' } as any)}
                         />
-                        <label className="upload-btn" onClick={() => fileInputRef.current?.click()}>
-                            <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
-                                <path d="M9 16h6v-6h4l-7-7-7 7h4zm-4 2h14v2H5z" />
-                            </svg>
-                            {uploading ? '上传中...' : '上传文件'}
-                        </label>
-                        <label className="upload-btn" onClick={() => folderInputRef.current?.click()}>
-                            <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
-                                <path d="M20 6h-8l-2-2H4c-1.1 0-1.99.9-1.99 2L2 18c0 1.1.9 2 2 2h16c1.1 0 2-.9 2-2V8c0-1.1-.9-2-2-2zm0 12H4V8h16v10z" />
-                            </svg>
-                            {uploading ? '上传中...' : '上传文件夹'}
-                        </label>
+                        {!readOnly && (
+                            <>
+                                <label className="upload-btn" onClick={() => fileInputRef.current?.click()}>
+                                    <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                        <path d="M9 16h6v-6h4l-7-7-7 7h4zm-4 2h14v2H5z" />
+                                    </svg>
+                                    {uploading ? '上传中...' : '上传文件'}
+                                </label>
+                                <label className="upload-btn" onClick={() => folderInputRef.current?.click()}>
+                                    <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                        <path d="M20 6h-8l-2-2H4c-1.1 0-1.99.9-1.99 2L2 18c0 1.1.9 2 2 2h16c1.1 0 2-.9 2-2V8c0-1.1-.9-2-2-2zm0 12H4V8h16v10z" />
+                                    </svg>
+                                    {uploading ? '上传中...' : '上传文件夹'}
+                                </label>
+                                <button className="upload-btn" onClick={() => setPathDialog({ action: 'mkdir', value: '' })}>
+                                    新建文件夹
+                                </button>
+                            </>
+                        )}
                         {selectedFiles.size > 0 && (
                             <>
                                 <button className="batch-download-btn" onClick={handleBatchDownload}>
@@ -1405,12 +1501,14 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
                                     </svg>
                                     下载选中 ({selectedFiles.size})
                                 </button>
-                                <button className="batch-delete-btn" onClick={handleBatchDelete}>
-                                    <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
-                                        <path d="M6 19c0 1.1.9 2 2 2h8c1.1 0 2-.9 2-2V7H6v12zM19 4h-3.5l-1-1h-5l-1 1H5v2h14V4z" />
-                                    </svg>
-                                    删除选中 ({selectedFiles.size})
-                                </button>
+                                {!readOnly && (
+                                    <button className="batch-delete-btn" onClick={handleBatchDelete}>
+                                        <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                            <path d="M6 19c0 1.1.9 2 2 2h8c1.1 0 2-.9 2-2V7H6v12zM19 4h-3.5l-1-1h-5l-1 1H5v2h14V4z" />
+                                        </svg>
+                                        删除选中 ({selectedFiles.size})
+                                    </button>
+                                )}
                             </>
                         )}
                         <button className="refresh-btn" onClick={() => loadFiles(currentPath)} disabled={loading}>
@@ -1638,15 +1736,39 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
                                                         </svg>
                                                     </button>
                                                 )}
-                                                <button
-                                                    className="action-btn delete-btn"
-                                                    onClick={() => handleDelete(file)}
-                                                    title="删除"
-                                                >
-                                                    <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
-                                                        <path d="M6 19c0 1.1.9 2 2 2h8c1.1 0 2-.9 2-2V7H6v12zM19 4h-3.5l-1-1h-5l-1 1H5v2h14V4z" />
-                                                    </svg>
-                                                </button>
+                                                {!readOnly && (
+                                                    <>
+                                                        <button
+                                                            className="action-btn rename-btn"
+                                                            onClick={() => setPathDialog({ action: 'rename', file, value: getFilePath(file.name) })}
+                                                            title="重命名/移动"
+                                                        >
+                                                            <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                                                <path d="M3 17.25V21h3.75L17.81 9.94l-3.75-3.75L3 17.25zM20.71 7.04c.39-.39.39-1.02 0-1.41l-2.34-2.34c-.39-.39-1.02-.39-1.41 0l-1.83 1.83 3.75 3.75 1.83-1.83z" />
+                                                            </svg>
+                                                        </button>
+                                                        <button
+                                                            className="action-btn copy-btn"
+                                                            onClick={() => setPathDialog({ action: 'copy', file, value: getFilePath(file.name) })}
+                                                            title="复制"
+                                                        >
+                                                            <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                                                <path d="M16 1H4c-1.1 0-2 .9-2 2v14h2V3h12V1zm3 4H8c-1.1 0-2 .9-2 2v14c0 1.1.9 2 2 2h11c1.1 0 2-.9 2-2V7c0-1.1-.9-2-2-2zm0 16H8V7h11v14z" />
+                                                            </svg>
+                                                        </button>
+                                                    </>
+                                                )}
+                                                {!readOnly && (
+                                                    <button
+                                                        className="action-btn delete-btn"
+                                                        onClick={() => handleDelete(file)}
+                                                        title="删除"
+                                                    >
+                                                        <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                                            <path d="M6 19c0 1.1.9 2 2 2h8c1.1 0 2-.9 2-2V7H6v12zM19 4h-3.5l-1-1h-5l-1 1H5v2h14V4z" />
+                                                        </svg>
+                                                    </button>
+                                                )}
                                             </td>
                                         </tr>
                                     ))}
@@ -1687,6 +1809,39 @@ export const FileManager = ({ onClose }: FileManagerProps) => {
                 </div>
             )}
 
+            {pathDialog && (
+                <div className="confirm-dialog-overlay" onClick={() => setPathDialog(null)}>
+                    <div className="confirm-dialog" onClick={(e) => e.stopPropagation()}>
+                        <div className="confirm-dialog-header">
+                            <h3>{pathDialog.action === 'mkdir' ? '新建文件夹' : pathDialog.action === 'rename' ? '重命名/移动' : '复制'}</h3>
+                        </div>
+                        <div className="confirm-dialog-body">
+                            <p>
+                                {pathDialog.action === 'mkdir'
+                                    ? '文件夹名称（相对于当前目录）：'
+                                    : <>将 <strong>{pathDialog.file!.name}</strong> {pathDialog.action === 'rename' ? '移动' : '复制'}到（相对于根目录的路径）：</>}
+                            </p>
+                            <input
+                                className="path-dialog-input"
+                                type="text"
+                                value={pathDialog.value}
+                                autoFocus
+                                onInput={(e) => setPathDialog({ ...pathDialog, value: (e.target as HTMLInputElement).value })}
+                                onKeyDown={(e) => e.key === 'Enter' && confirmPathDialogAction()}
+                            />
+                        </div>
+                        <div className="confirm-dialog-footer">
+                            <button className="confirm-cancel-btn" onClick={() => setPathDialog(null)}>
+                                取消
+                            </button>
+                            <button className="confirm-download-btn" onClick={confirmPathDialogAction}>
+                                确定
+                            </button>
+                        </div>
+                    </div>
+                </div>
+            )}
+
             {confirmBatchDelete?.show && (
                 <div className="confirm-dialog-overlay" onClick={() => setConfirmBatchDelete(null)}>
                     <div className="confirm-dialog" onClick={(e) => e.stopPropagation()}>
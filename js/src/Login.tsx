@@ -7,6 +7,7 @@ interface LoginProps {
 export const Login = ({ onSuccess }: LoginProps) => {
     const [username, setUsername] = useState('');
     const [password, setPassword] = useState('');
+    const [otp, setOtp] = useState('');
     const [error, setError] = useState<string | null>(null);
     const [loading, setLoading] = useState(false);
 
@@ -16,24 +17,27 @@ export const Login = ({ onSuccess }: LoginProps) => {
         setLoading(true);
 
         try {
-            const credentials = btoa(`${username}:${password}`);
-            const response = await fetch('api/auth/verify', {
+            const response = await fetch('api/auth/login', {
                 method: 'POST',
                 headers: {
-                    'Content-Type': 'application/json',
-                    'Authorization': `Basic ${credentials}`
-                }
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ username, password, otp })
             });
 
+            if (response.status === 429) {
+                const retryAfter = response.headers.get('Retry-After') || '60';
+                throw new Error(`登录失败次数过多，请在 ${retryAfter} 秒后重试`);
+            }
             if (!response.ok) {
-                throw new Error('认证失败，请检查用户名和密码');
+                throw new Error('认证失败，请检查用户名、密码和验证码');
             }
 
             const data = await response.json();
-            if (data.success) {
-                // Store credentials in sessionStorage
-                sessionStorage.setItem('gotty_auth', credentials);
-                onSuccess(credentials);
+            if (data.success && data.token) {
+                // Store the session token, the password is never stored
+                sessionStorage.setItem('gotty_auth', data.token);
+                onSuccess(data.token);
             } else {
                 throw new Error('认证失败');
             }
@@ -99,6 +103,27 @@ export const Login = ({ onSuccess }: LoginProps) => {
                         />
                     </div>
 
+                    <div className="login-field">
+                        <label htmlFor="otp">
+                            <svg viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg">
+                                <path d="M12 1L3 5v6c0 5.55 3.84 10.74 9 12 5.16-1.26 9-6.45 9-12V5l-9-4zm0 10.99h7c-.53 4.12-3.28 7.79-7 8.94V12H5V6.3l7-3.11v8.8z" />
+                            </svg>
+                            <span>验证码</span>
+                        </label>
+                        <input
+                            id="otp"
+                            type="text"
+                            inputMode="numeric"
+                            pattern="[0-9]{6}"
+                            maxLength={6}
+                            value={otp}
+                            onInput={(e) => setOtp((e.target as HTMLInputElement).value)}
+                            placeholder="已启用两步验证时填写 6 位验证码"
+                            disabled={loading}
+                            autoComplete="one-time-code"
+                        />
+                    </div>
+
                     <button type="submit" className="login-btn" disabled={loading}>
                         {loading ? '登录中...' : '登录'}
                     </button>
@@ -18,6 +18,8 @@ const gottyWindow = window as GoTTYWindow;
 // Check if authentication is required
 const authRequired = gottyWindow.gotty_enable_auth === true;
 const storedAuth = sessionStorage.getItem('gotty_auth');
+// Share links grant access without logging in
+const shareToken = new URLSearchParams(window.location.search).get('share');
 
 const initTerminal = (authToken: string = '') => {
     const elem = document.getElementById("terminal");
@@ -34,16 +36,19 @@ const initTerminal = (authToken: string = '') => {
             queryArgs = queryArgs ? queryArgs + "&auth=" + encodeURIComponent(authToken) : "?auth=" + encodeURIComponent(authToken);
             console.log('[GoTTY] Auth enabled, token length:', authToken.length);
         }
+        if (shareToken) {
+            queryArgs = queryArgs ? queryArgs + "&share=" + encodeURIComponent(shareToken) : "?share=" + encodeURIComponent(shareToken);
+        }
 
         const url = (httpsEnabled ? 'wss://' : 'ws://') + window.location.host + window.location.pathname + 'ws' + queryArgs;
-        console.log('[GoTTY] Connecting to:', url.replace(/auth=[^&]+/, 'auth=***'));
+        console.log('[GoTTY] Connecting to:', url.replace(/(auth|share)=[^&]+/g, '$1=***'));
         const args = window.location.search;
         const factory = new ConnectionFactory(url, protocols);
         const wt = new WebTTY(term, factory, args, authToken || gottyWindow.gotty_auth_token || "");
 
         // Set up connection error handler for auth failures
         wt.onConnectionError = () => {
-            if (authRequired) {
+            if (authRequired && !shareToken) {
                 // Clear stored auth and reload to show login
                 sessionStorage.removeItem('gotty_auth');
                 window.location.reload();
@@ -62,7 +67,10 @@ const initTerminal = (authToken: string = '') => {
 };
 
 // Show login if auth is required and not authenticated
-if (authRequired && !storedAuth) {
+if (shareToken) {
+    // the file manager isn't part of a shared terminal
+    initTerminal();
+} else if (authRequired && !storedAuth) {
     const loginContainer = document.createElement("div");
     loginContainer.id = "login-container";
     document.body.appendChild(loginContainer);
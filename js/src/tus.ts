@@ -0,0 +1,115 @@
+// A client of the tus resumable upload protocol 1.0 (https://tus.io/protocols/resumable-upload).
+// The URL of an upload is kept in localStorage until it completes, so an upload
+// interrupted by a flaky connection or a page reload resumes at its offset.
+
+const TUS_VERSION = '1.0.0';
+const RETRY_DELAYS = [1000, 3000, 5000, 10000, 20000, 30000];
+
+export interface TusUploadOptions {
+    // endpoint is the URL uploads are created at, like api/tus?root=name
+    endpoint: string;
+    file: File;
+    metadata: Record<string, string>;
+    chunkSize: number;
+    headers: Record<string, string>;
+    onProgress: (uploaded: number, total: number) => void;
+}
+
+const encodeMetadata = (metadata: Record<string, string>) =>
+    Object.entries(metadata)
+        .map(([key, value]) => `${key} ${btoa(unescape(encodeURIComponent(value)))}`)
+        .join(',');
+
+const sleep = (ms: number) => new Promise(resolve => setTimeout(resolve, ms));
+
+// checksum returns the Upload-Checksum header of data, empty when the browser
+// can't compute it outside of secure contexts
+const checksum = async (data: Blob): Promise<string> => {
+    if (!window.crypto?.subtle) return '';
+    const digest = await crypto.subtle.digest('SHA-256', await data.arrayBuffer());
+    let binary = '';
+    new Uint8Array(digest).forEach(byte => { binary += String.fromCharCode(byte); });
+    return `sha256 ${btoa(binary)}`;
+};
+
+class TusError extends Error {
+    status: number;
+
+    constructor(message: string, status: number) {
+        super(message);
+        this.status = status;
+    }
+}
+
+// retryable tells whether a failed request may succeed when retried,
+// other client errors like a full quota are final
+const retryable = (err: unknown) =>
+    !(err instanceof TusError) || err.status === 409 || err.status === 423 || err.status === 460 || err.status >= 500;
+
+export const tusUpload = async (options: TusUploadOptions): Promise<void> => {
+    const { endpoint, file, chunkSize, onProgress } = options;
+    const headers = { ...options.headers, 'Tus-Resumable': TUS_VERSION };
+    const storageKey = `tus::${endpoint}::${options.metadata.path}::${options.metadata.filename}::${file.size}::${file.lastModified}`;
+
+    const request = async (method: string, url: string, extra: Record<string, string> = {}, body?: Blob) => {
+        const response = await fetch(url, { method, headers: { ...headers, ...extra }, body });
+        if (!response.ok) {
+            throw new TusError(`${method} ${response.status}: ${(await response.text()).trim()}`, response.status);
+        }
+        return response;
+    };
+
+    // resume returns the offset of the stored upload, or creates a new one
+    let uploadUrl = localStorage.getItem(storageKey);
+    const resume = async (): Promise<number> => {
+        if (uploadUrl) {
+            try {
+                const response = await request('HEAD', uploadUrl);
+                return parseInt(response.headers.get('Upload-Offset') || '0', 10);
+            } catch (err) {
+                if (retryable(err)) throw err;
+                localStorage.removeItem(storageKey);
+            }
+        }
+        const response = await request('POST', endpoint, {
+            'Upload-Length': file.size.toString(),
+            'Upload-Metadata': encodeMetadata(options.metadata),
+        });
+        uploadUrl = new URL(response.headers.get('Location')!, new URL(endpoint, location.href)).toString();
+        localStorage.setItem(storageKey, uploadUrl);
+        return parseInt(response.headers.get('Upload-Offset') || '0', 10);
+    };
+
+    let retries = 0;
+    let offset = -1;
+    while (offset < file.size) {
+        try {
+            if (offset < 0) {
+                offset = await resume();
+                onProgress(offset, file.size);
+                if (offset >= file.size) break;
+            }
+            const chunk = file.slice(offset, offset + chunkSize);
+            const extra: Record<string, string> = {
+                'Upload-Offset': offset.toString(),
+                'Content-Type': 'application/offset+octet-stream',
+            };
+            const sum = await checksum(chunk);
+            if (sum) extra['Upload-Checksum'] = sum;
+
+            const response = await request('PATCH', uploadUrl!, extra, chunk);
+            offset = parseInt(response.headers.get('Upload-Offset') || '0', 10);
+            retries = 0;
+            onProgress(offset, file.size);
+        } catch (err) {
+            if (!retryable(err) || retries >= RETRY_DELAYS.length) {
+                if (!retryable(err)) localStorage.removeItem(storageKey);
+                throw err;
+            }
+            await sleep(RETRY_DELAYS[retries++]);
+            // ask the server where to resume
+            offset = -1;
+        }
+    }
+    localStorage.removeItem(storageKey);
+};
@@ -17,6 +17,7 @@ export class Connection {
 
     constructor(url: string, protocols: string[]) {
         this.bare = new WebSocket(url, protocols);
+        this.bare.binaryType = "arraybuffer";
     }
 
     open() {
@@ -27,10 +28,14 @@ export class Connection {
         this.bare.close();
     };
 
-    send(data: string) {
+    send(data: string | Uint8Array) {
         this.bare.send(data);
     };
 
+    protocol(): string {
+        return this.bare.protocol;
+    };
+
     isOpen(): boolean {
         if (this.bare.readyState == WebSocket.CONNECTING ||
             this.bare.readyState == WebSocket.OPEN) {
@@ -45,15 +50,15 @@ export class Connection {
         }
     };
 
-    onReceive(callback: (data: string) => void) {
+    onReceive(callback: (data: string | ArrayBuffer) => void) {
         this.bare.onmessage = (event) => {
             callback(event.data);
         }
     };
 
-    onClose(callback: () => void) {
+    onClose(callback: (reason: string) => void) {
         this.bare.onclose = (event) => {
-            callback();
+            callback(event.reason);
         };
     };
 }
@@ -1,10 +1,14 @@
-export const protocols = ["webtty"];
+export const protocolBinary = "webtty.v2";
+export const protocolText = "webtty";
+export const protocols = [protocolBinary, protocolText];
 
 export const msgInputUnknown = '0';
 export const msgInput = '1';
 export const msgPing = '2';
 export const msgResizeTerminal = '3';
 export const msgSetEncoding = '4';
+export const msgRequestCompression = '5';
+export const msgControl = '6';
 
 export const msgUnknownOutput = '0';
 export const msgOutput = '1';
@@ -13,6 +17,23 @@ export const msgSetWindowTitle = '3';
 export const msgSetPreferences = '4';
 export const msgSetReconnect = '5';
 export const msgSetBufferSize = '6';
+export const msgSetCompression = '7';
+export const msgSetSessionID = '8';
+export const msgSetControlState = '9';
+
+const sessionIDKey = 'gotty_session_id';
+
+/*
+ * Playback state of a recording, reported by the server in replay mode.
+ */
+interface ControlState {
+    paused: boolean;
+    speed: number;
+    position: number;
+    duration: number;
+}
+
+const seekStep = 5;
 
 
 export interface Terminal {
@@ -72,14 +93,19 @@ export interface Connection {
     close(): void;
 
     /*
-     * This takes fucking strings??
+     * Strings are sent as text frames, Uint8Arrays as binary frames.
+     */
+    send(s: string | Uint8Array): void;
+
+    /*
+     * The subprotocol selected by the server
      */
-    send(s: string): void;
+    protocol(): string;
 
     isOpen(): boolean;
     onOpen(callback: () => void): void;
-    onReceive(callback: (data: string) => void): void;
-    onClose(callback: () => void): void;
+    onReceive(callback: (data: string | ArrayBuffer) => void): void;
+    onClose(callback: (reason: string) => void): void;
 }
 
 export interface ConnectionFactory {
@@ -136,6 +162,34 @@ export class WebTTY {
      */
     connectionOpenTime?: number;
 
+    /*
+     * True when the server accepted the binary protocol. Terminal input
+     * and output then travel as raw bytes instead of base64 text.
+     */
+    binary: boolean;
+
+    /*
+     * The ID of the session on the server. Sent back when reconnecting so
+     * that the server reattaches us to the same process. Kept in
+     * sessionStorage to survive page reloads.
+     */
+    sessionID: string;
+
+    textEncoder: TextEncoder;
+    textDecoder: TextDecoder;
+
+    /*
+     * Writer side of the inflate stream once the server compresses output.
+     * The server shares one deflate stream for the whole connection.
+     */
+    inflater?: WritableStreamDefaultWriter<BufferSource>;
+
+    /*
+     * Set when the server plays a recording. Keys then control
+     * the playback instead of being sent as input.
+     */
+    controlState?: ControlState;
+
     constructor(term: Terminal, connectionFactory: ConnectionFactory, args: string, authToken: string) {
         this.term = term;
         this.connectionFactory = connectionFactory;
@@ -143,6 +197,10 @@ export class WebTTY {
         this.authToken = authToken;
         this.reconnect = -1;
         this.bufSize = 1024;
+        this.binary = false;
+        this.sessionID = sessionStorage.getItem(sessionIDKey) || "";
+        this.textEncoder = new TextEncoder();
+        this.textDecoder = new TextDecoder();
     };
 
     open() {
@@ -154,6 +212,7 @@ export class WebTTY {
         const setup = () => {
             connection.onOpen(() => {
                 this.connectionOpenTime = Date.now();
+                this.binary = connection.protocol() == protocolBinary;
                 const termInfo = this.term.info();
 
                 this.initializeConnection(this.args, this.authToken);
@@ -164,7 +223,13 @@ export class WebTTY {
 
                 this.sendResizeTerminal(termInfo.columns, termInfo.rows);
 
-                this.sendSetEncoding("base64");
+                if (!this.binary) {
+                    this.sendSetEncoding("base64");
+                }
+
+                if (typeof DecompressionStream !== "undefined") {
+                    this.sendRequestCompression("deflate");
+                }
 
                 this.term.onInput(
                     (input: string | Uint8Array) => {
@@ -178,50 +243,39 @@ export class WebTTY {
             });
 
             connection.onReceive((data) => {
-                const payload = data.slice(1);
-                switch (data[0]) {
-                    case msgOutput:
-                        this.term.output(Uint8Array.from(atob(payload), c => c.charCodeAt(0)));
-                        break;
-                    case msgPong:
-                        break;
-                    case msgSetWindowTitle:
-                        this.term.setWindowTitle(payload);
-                        break;
-                    case msgSetPreferences:
-                        const preferences = JSON.parse(payload);
-                        this.term.setPreferences(preferences);
-                        break;
-                    case msgSetReconnect:
-                        const autoReconnect = JSON.parse(payload);
-                        console.log("Enabling reconnect: " + autoReconnect + " seconds")
-                        this.reconnect = autoReconnect;
-                        break;
-                    case msgSetBufferSize:
-                        const bufSize = JSON.parse(payload);
-                        this.bufSize = bufSize;
-                        break;
+                if (typeof data === "string") {
+                    this.handleMessage(data[0], data.slice(1));
+                } else {
+                    const frame = new Uint8Array(data);
+                    this.handleMessage(String.fromCharCode(frame[0]), frame.subarray(1));
                 }
             });
 
-            connection.onClose(() => {
+            connection.onClose((reason: string) => {
                 clearInterval(pingTimer);
+                this.stopInflater();
                 this.term.deactivate();
 
-                // Check if this was an authentication error (WebSocket closed immediately)
-                // If connection closes within 1 second, likely an auth error
-                const now = Date.now();
-                if (this.connectionOpenTime && (now - this.connectionOpenTime) < 1000) {
-                    if (this.onConnectionError) {
-                        this.onConnectionError();
-                        return;
+                if (reason) {
+                    // the server explains why the command ended, e.g. a resource limit
+                    this.term.showMessage("Connection Closed: " + reason, 0);
+                } else {
+                    // Check if this was an authentication error (WebSocket closed immediately)
+                    // If connection closes within 1 second, likely an auth error
+                    const now = Date.now();
+                    if (this.connectionOpenTime && (now - this.connectionOpenTime) < 1000) {
+                        if (this.onConnectionError) {
+                            this.onConnectionError();
+                            return;
+                        }
                     }
-                }
 
-                this.term.showMessage("Connection Closed", 0);
+                    this.term.showMessage("Connection Closed", 0);
+                }
                 if (this.reconnect > 0) {
                     reconnectTimeout = setTimeout(() => {
                         connection = this.connectionFactory.create();
+                        this.connection = connection;
                         this.term.reset();
                         setup();
                     }, this.reconnect * 1000);
@@ -238,11 +292,97 @@ export class WebTTY {
         }
     };
 
+    /*
+     * handleMessage dispatches a message from the server. payload is a string
+     * for the text protocol and raw bytes for the binary protocol.
+     */
+    private handleMessage(type: string, payload: string | Uint8Array) {
+        if (type == msgOutput) {
+            const data = typeof payload === "string" ? Uint8Array.from(atob(payload), c => c.charCodeAt(0)) : payload;
+            if (this.inflater) {
+                this.inflater.write(data as BufferSource);
+            } else {
+                this.term.output(data);
+            }
+            return;
+        }
+
+        const text = typeof payload === "string" ? payload : this.textDecoder.decode(payload);
+        switch (type) {
+            case msgPong:
+                break;
+            case msgSetWindowTitle:
+                this.term.setWindowTitle(text);
+                break;
+            case msgSetPreferences:
+                const preferences = JSON.parse(text);
+                this.term.setPreferences(preferences);
+                break;
+            case msgSetReconnect:
+                const autoReconnect = JSON.parse(text);
+                console.log("Enabling reconnect: " + autoReconnect + " seconds")
+                this.reconnect = autoReconnect;
+                break;
+            case msgSetBufferSize:
+                const bufSize = JSON.parse(text);
+                this.bufSize = bufSize;
+                break;
+            case msgSetSessionID:
+                this.sessionID = text;
+                sessionStorage.setItem(sessionIDKey, text);
+                break;
+            case msgSetCompression:
+                if (text == "deflate") {
+                    this.startInflater();
+                }
+                break;
+            case msgSetControlState:
+                const state: ControlState = JSON.parse(text);
+                if (this.controlState) {
+                    this.term.showMessage(formatControlState(state), 2000);
+                } else {
+                    this.term.showMessage("Space: pause/resume, \u2190/\u2192: seek, +/-: speed", 3000);
+                }
+                this.controlState = state;
+                break;
+        }
+    }
+
+    /*
+     * startInflater routes following output through a deflate-raw stream.
+     * The stream yields chunks in order, so the terminal sees them in order too.
+     */
+    private startInflater() {
+        const stream = new DecompressionStream("deflate-raw");
+        const reader = stream.readable.getReader();
+        const pump = () => {
+            reader.read().then(({ done, value }) => {
+                if (done) {
+                    return;
+                }
+                this.term.output(value);
+                pump();
+            }).catch((err) => {
+                console.error("Failed to inflate output", err);
+            });
+        };
+        pump();
+        this.inflater = stream.writable.getWriter();
+    }
+
+    private stopInflater() {
+        if (this.inflater) {
+            this.inflater.close().catch(() => { });
+            this.inflater = undefined;
+        }
+    }
+
     private initializeConnection(args, authToken) {
         this.connection.send(JSON.stringify(
             {
                 Arguments: args,
                 AuthToken: authToken,
+                SessionID: this.sessionID,
             }
         ));
     }
@@ -252,7 +392,25 @@ export class WebTTY {
      * strings will be encoded as UTF-8. Uint8Arrays are passed along as-is.
      */
     private sendInput(input: string | Uint8Array) {
+        if (this.controlState) {
+            this.handleControlKey(typeof input === "string" ? input : this.textDecoder.decode(input));
+            return;
+        }
+
         let effectiveBufferSize = this.bufSize - 1;
+
+        if (this.binary) {
+            const data = typeof input === "string" ? this.textEncoder.encode(input) : input;
+            for (let i = 0; i < data.length; i += effectiveBufferSize) {
+                const chunk = data.subarray(i, Math.min(i + effectiveBufferSize, data.length));
+                const frame = new Uint8Array(chunk.length + 1);
+                frame[0] = msgInput.charCodeAt(0);
+                frame.set(chunk, 1);
+                this.connection.send(frame);
+            }
+            return;
+        }
+
         let dataString: string;
 
         if (typeof input === "string") {
@@ -270,6 +428,38 @@ export class WebTTY {
         }
     }
 
+    /*
+     * handleControlKey maps keys to playback controls of a recording.
+     */
+    private handleControlKey(key: string) {
+        const state = this.controlState!;
+        switch (key) {
+            case " ":
+                this.sendControl({ action: state.paused ? "resume" : "pause" });
+                break;
+            case "+":
+            case "=":
+                this.sendControl({ action: "speed", value: state.speed * 2 });
+                break;
+            case "-":
+                this.sendControl({ action: "speed", value: state.speed / 2 });
+                break;
+            case "\x1b[C":
+                this.sendControl({ action: "seek", value: seekStep });
+                break;
+            case "\x1b[D":
+                this.sendControl({ action: "seek", value: -seekStep });
+                break;
+            case "0":
+                this.sendControl({ action: "seek", value: -state.position });
+                break;
+        }
+    }
+
+    private sendControl(control: { action: string, value?: number }) {
+        this.connection.send(msgControl + JSON.stringify(control));
+    }
+
     private sendPing(): void {
         this.connection.send(msgPing);
     }
@@ -289,4 +479,16 @@ export class WebTTY {
         this.connection.send(msgSetEncoding + encoding)
     }
 
+    private sendRequestCompression(algorithm: "deflate") {
+        this.connection.send(msgRequestCompression + algorithm)
+    }
+
 };
+
+function formatControlState(state: ControlState): string {
+    const format = (seconds: number) => {
+        const s = Math.floor(seconds);
+        return Math.floor(s / 60) + ":" + String(s % 60).padStart(2, "0");
+    };
+    return (state.paused ? "\u23f8" : "\u25b6") + " " + format(state.position) + " / " + format(state.duration) + " " + state.speed + "x";
+}
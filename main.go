@@ -22,6 +22,9 @@ import (
 )
 
 func main() {
+	// commands in a sandbox or with limits re-execute gotty to prepare them
+	localcommand.Init()
+
 	app := cli.NewApp()
 	app.Name = "gotty"
 	app.Version = fmt.Sprintf("%s (%s)", version.Get().Version, version.Get().BuildTime)
@@ -53,39 +56,25 @@ func main() {
 		},
 	)
 
+	app.Commands = []*cli.Command{
+		replayCommand(appOptions, cliFlags, flagMappings),
+		sshCommand(appOptions, cliFlags, flagMappings),
+		dockerCommand(appOptions, cliFlags, flagMappings),
+		shareCommand(appOptions, cliFlags, flagMappings),
+		hashPasswordCommand(),
+		totpEnrollCommand(),
+	}
+
 	app.Action = func(c *cli.Context) error {
 		if c.NArg() == 0 {
 			msg := "Error: No command given."
 			cli.ShowAppHelp(c)
-			exit(fmt.Errorf(msg), 1)
-		}
-
-		configFile := c.String("config")
-		_, err := os.Stat(homedir.Expand(configFile))
-		if configFile != "~/.gotty" || !os.IsNotExist(err) {
-			if err := utils.ApplyConfigFile(configFile, appOptions, backendOptions); err != nil {
-				exit(err, 2)
-			}
+			exit(fmt.Errorf("%s", msg), 1)
 		}
 
+		loadConfigFile(c, appOptions, backendOptions)
 		utils.ApplyFlags(cliFlags, flagMappings, c, appOptions, backendOptions)
-
-		if appOptions.Quiet {
-			log.SetFlags(0)
-			log.SetOutput(io.Discard)
-		}
-
-		if c.IsSet("credential") {
-			appOptions.EnableBasicAuth = true
-		}
-		if c.IsSet("tls-ca-crt") {
-			appOptions.EnableTLSClientAuth = true
-		}
-
-		err = appOptions.Validate()
-		if err != nil {
-			exit(err, 6)
-		}
+		prepareOptions(c, appOptions)
 
 		args := c.Args()
 		factory, err := localcommand.NewFactory(args.First(), args.Tail(), backendOptions)
@@ -100,30 +89,68 @@ func main() {
 			"hostname": hostname,
 		}
 
-		srv, err := server.New(factory, appOptions)
-		if err != nil {
-			exit(err, 3)
+		serve(factory, appOptions, fmt.Sprintf("command: %s", strings.Join(args.Slice(), " ")))
+		return nil
+	}
+	app.Run(os.Args)
+}
+
+// loadConfigFile applies the config file to options,
+// a missing file is only an error when it's not the default one.
+func loadConfigFile(c *cli.Context, options ...interface{}) {
+	configFile := c.String("config")
+	_, err := os.Stat(homedir.Expand(configFile))
+	if configFile != "~/.gotty" || !os.IsNotExist(err) {
+		if err := utils.ApplyConfigFile(configFile, options...); err != nil {
+			exit(err, 2)
 		}
+	}
+}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		gCtx, gCancel := context.WithCancel(context.Background())
+// prepareOptions completes and validates server options after flags are applied.
+func prepareOptions(c *cli.Context, appOptions *server.Options) {
+	if appOptions.Quiet {
+		log.SetFlags(0)
+		log.SetOutput(io.Discard)
+	}
 
-		log.Printf("GoTTY is starting with command: %s", strings.Join(args.Slice(), " "))
+	if c.IsSet("credential") {
+		appOptions.EnableBasicAuth = true
+	}
+	if c.IsSet("tls-ca-crt") {
+		appOptions.EnableTLSClientAuth = true
+	}
+	if appOptions.TLSSelfSigned || appOptions.ACMEDomains != "" {
+		appOptions.EnableTLS = true
+	}
 
-		errs := make(chan error, 1)
-		go func() {
-			errs <- srv.Run(ctx, server.WithGracefullContext(gCtx))
-		}()
-		err = waitSignals(errs, cancel, gCancel)
+	if err := appOptions.Validate(); err != nil {
+		exit(err, 6)
+	}
+}
 
-		if err != nil && err != context.Canceled {
-			fmt.Printf("Error: %s\n", err)
-			exit(err, 8)
-		}
+// serve runs the server until it's stopped by a signal.
+func serve(factory server.Factory, appOptions *server.Options, description string) {
+	srv, err := server.New(factory, appOptions)
+	if err != nil {
+		exit(err, 3)
+	}
 
-		return nil
+	ctx, cancel := context.WithCancel(context.Background())
+	gCtx, gCancel := context.WithCancel(context.Background())
+
+	log.Printf("GoTTY is starting with %s", description)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Run(ctx, server.WithGracefullContext(gCtx))
+	}()
+	err = waitSignals(errs, cancel, gCancel, srv.ReloadTLS)
+
+	if err != nil && err != context.Canceled {
+		fmt.Printf("Error: %s\n", err)
+		exit(err, 8)
 	}
-	app.Run(os.Args)
 }
 
 func exit(err error, code int) {
@@ -133,34 +160,44 @@ func exit(err error, code int) {
 	os.Exit(code)
 }
 
-func waitSignals(errs chan error, cancel context.CancelFunc, gracefullCancel context.CancelFunc) error {
+// waitSignals stops the server on SIGINT or SIGTERM and reloads its TLS files on SIGHUP.
+func waitSignals(errs chan error, cancel context.CancelFunc, gracefullCancel context.CancelFunc, reload func() error) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(
 		sigChan,
 		syscall.SIGINT,
 		syscall.SIGTERM,
+		syscall.SIGHUP,
 	)
 
-	select {
-	case err := <-errs:
-		return err
-
-	case s := <-sigChan:
-		switch s {
-		case syscall.SIGINT:
-			gracefullCancel()
-			fmt.Println("C-C to force close")
-			select {
-			case err := <-errs:
-				return err
-			case <-sigChan:
-				fmt.Println("Force closing...")
+	for {
+		select {
+		case err := <-errs:
+			return err
+
+		case s := <-sigChan:
+			switch s {
+			case syscall.SIGHUP:
+				if err := reload(); err != nil {
+					log.Printf("Failed to reload TLS files, keeping the current ones: %v", err)
+				} else {
+					log.Printf("Reloaded TLS files")
+				}
+			case syscall.SIGINT:
+				gracefullCancel()
+				fmt.Println("C-C to force close")
+				select {
+				case err := <-errs:
+					return err
+				case <-sigChan:
+					fmt.Println("Force closing...")
+					cancel()
+					return <-errs
+				}
+			default:
 				cancel()
 				return <-errs
 			}
-		default:
-			cancel()
-			return <-errs
 		}
 	}
 }
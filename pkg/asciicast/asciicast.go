@@ -0,0 +1,65 @@
+// Package asciicast reads and writes asciicast v2 recordings,
+// the format used by asciinema.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+package asciicast
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const Version = 2
+
+// Event types
+const (
+	// Output written to the terminal
+	Output = "o"
+	// Input typed by the user
+	Input = "i"
+	// Terminal resize, data is formatted as "{columns}x{rows}"
+	Resize = "r"
+)
+
+// Header is the first line of a recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is a single line following the header.
+type Event struct {
+	// Time in seconds since the beginning of the recording
+	Time float64
+	Type string
+	Data string
+}
+
+func (event Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{event.Time, event.Type, event.Data})
+}
+
+func (event *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return errors.Errorf("event has %d fields, expected 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &event.Time); err != nil {
+		return errors.Wrapf(err, "invalid event time")
+	}
+	if err := json.Unmarshal(fields[1], &event.Type); err != nil {
+		return errors.Wrapf(err, "invalid event type")
+	}
+	if err := json.Unmarshal(fields[2], &event.Data); err != nil {
+		return errors.Wrapf(err, "invalid event data")
+	}
+	return nil
+}
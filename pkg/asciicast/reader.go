@@ -0,0 +1,59 @@
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// maxLineSize is the longest event line accepted by Reader.
+const maxLineSize = 16 * 1024 * 1024
+
+// Reader reads a recording.
+type Reader struct {
+	Header Header
+
+	scanner *bufio.Scanner
+}
+
+// NewReader reads the header from r and returns a Reader for the events.
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("missing asciicast header")
+	}
+	reader := &Reader{scanner: scanner}
+	if err := json.Unmarshal(scanner.Bytes(), &reader.Header); err != nil {
+		return nil, errors.Wrapf(err, "invalid asciicast header")
+	}
+	if reader.Header.Version != Version {
+		return nil, errors.Errorf("unsupported asciicast version %d", reader.Header.Version)
+	}
+	return reader, nil
+}
+
+// Next returns the next event, or io.EOF at the end of the recording.
+func (reader *Reader) Next() (Event, error) {
+	for reader.scanner.Scan() {
+		line := reader.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return Event{}, errors.Wrapf(err, "invalid asciicast event")
+		}
+		return event, nil
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
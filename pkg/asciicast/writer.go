@@ -0,0 +1,76 @@
+package asciicast
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Writer writes a recording. It is safe for concurrent use.
+type Writer struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	start   time.Time
+	// incomplete UTF-8 sequences at the end of the last data, per event type
+	partial map[string][]byte
+}
+
+// NewWriter writes the header to w and returns a Writer for the events.
+// The timestamp of the header is set to now if empty.
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	start := time.Now()
+	header.Version = Version
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		encoder: encoder,
+		start:   start,
+		partial: map[string][]byte{},
+	}, nil
+}
+
+// WriteEvent writes an event of the given type timed at now.
+// Multi-byte characters split between calls are joined
+// so that the recording stays valid UTF-8.
+func (w *Writer) WriteEvent(eventType string, data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	data = append(w.partial[eventType], data...)
+	complete := len(data) - incompleteSuffix(data)
+	w.partial[eventType] = append([]byte(nil), data[complete:]...)
+	if complete == 0 {
+		return nil
+	}
+
+	return w.encoder.Encode(Event{
+		Time: time.Since(w.start).Seconds(),
+		Type: eventType,
+		Data: string(data[:complete]),
+	})
+}
+
+// incompleteSuffix returns the length of a truncated UTF-8 sequence at the end of data.
+func incompleteSuffix(data []byte) int {
+	// a sequence is at most utf8.UTFMax bytes, look for its first byte
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package asciicast
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer, Header{Width: 100, Height: 30, Command: "bash", Env: map[string]string{"TERM": "xterm-256color"}})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewWriter(): %s", err)
+	}
+	for _, event := range []struct {
+		eventType string
+		data      string
+	}{
+		{Output, "$ "},
+		{Input, "ls\r"},
+		// a character split between two reads
+		{Output, "caf\xc3"},
+		{Output, "\xa9\r\n"},
+		{Resize, "120x40"},
+	} {
+		if err := writer.WriteEvent(event.eventType, []byte(event.data)); err != nil {
+			t.Fatalf("Unexpected error from WriteEvent(): %s", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("Invalid header %s: %s", lines[0], err)
+	}
+	if header["version"] != 2.0 || header["width"] != 100.0 || header["height"] != 30.0 || header["command"] != "bash" {
+		t.Fatalf("Unexpected header: %s", lines[0])
+	}
+	if timestamp, ok := header["timestamp"].(float64); !ok || timestamp <= 0 {
+		t.Fatalf("Header without timestamp: %s", lines[0])
+	}
+	if env, ok := header["env"].(map[string]interface{}); !ok || env["TERM"] != "xterm-256color" {
+		t.Fatalf("Unexpected env in header: %s", lines[0])
+	}
+
+	expected := [][2]string{{"o", "$ "}, {"i", "ls\r"}, {"o", "caf"}, {"o", "é\r\n"}, {"r", "120x40"}}
+	if len(lines) != len(expected)+1 {
+		t.Fatalf("Unexpected number of lines: %q", lines)
+	}
+	last := 0.0
+	for i, line := range lines[1:] {
+		// an event is an array of its time in seconds, its type and its data
+		var fields []interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil || len(fields) != 3 {
+			t.Fatalf("Invalid event %s: %v", line, err)
+		}
+		at, ok := fields[0].(float64)
+		if !ok || at < last {
+			t.Fatalf("Unexpected time of event %s", line)
+		}
+		last = at
+		if fields[1] != expected[i][0] || fields[2] != expected[i][1] {
+			t.Fatalf("Unexpected event %s instead of %q", line, expected[i])
+		}
+	}
+
+	// the recording is read back
+	reader, err := NewReader(&buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewReader(): %s", err)
+	}
+	if reader.Header.Width != 100 || reader.Header.Height != 30 {
+		t.Fatalf("Unexpected header read: %+v", reader.Header)
+	}
+	for i := range expected {
+		event, err := reader.Next()
+		if err != nil || event.Type != expected[i][0] || event.Data != expected[i][1] {
+			t.Fatalf("Unexpected event read: %+v %v", event, err)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Unexpected error at the end of the recording: %v", err)
+	}
+}
+
+// failingWriter fails after accepting limit bytes.
+type failingWriter struct {
+	limit int
+}
+
+var errFull = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, errFull
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriterError(t *testing.T) {
+	if _, err := NewWriter(&failingWriter{}, Header{Width: 80, Height: 24}); err != errFull {
+		t.Fatalf("Unexpected error from NewWriter(): %v", err)
+	}
+
+	writer, err := NewWriter(&failingWriter{limit: 1024}, Header{Width: 80, Height: 24})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewWriter(): %s", err)
+	}
+	if err := writer.WriteEvent(Output, bytes.Repeat([]byte("x"), 1024)); err != errFull {
+		t.Fatalf("Unexpected error from WriteEvent(): %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package passwd hashes passwords with argon2id and verifies
+// argon2id and bcrypt hashes.
+package passwd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// parameters of new hashes, the ones of existing hashes are read from them
+const (
+	memory      = 64 * 1024
+	iterations  = 3
+	parallelism = 4
+	saltLength  = 16
+	keyLength   = 32
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// Hash returns the argon2id hash of password in the PHC string format,
+// such as $argon2id$v=19$m=65536,t=3,p=4$salt$key.
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, keyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, memory, iterations, parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// IsHash tells whether hash is an argon2id or bcrypt hash.
+func IsHash(hash string) bool {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// Verify tells whether password matches an argon2id or bcrypt hash.
+func Verify(hash string, password string) (bool, error) {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	var version int
+	var m, t uint32
+	var p uint8
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2id hash")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+		return false, errors.Wrapf(err, "invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid argon2id key")
+	}
+
+	derived := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, derived) == 1, nil
+}
@@ -0,0 +1,139 @@
+// Package sharelink signs and verifies expiring links granting access
+// to a terminal or a file without credentials.
+package sharelink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/randomstring"
+)
+
+// Kinds of resources a link grants access to
+const (
+	Terminal = "terminal"
+	File     = "file"
+)
+
+// Permissions of terminal links
+const (
+	View  = "view"
+	Write = "write"
+)
+
+const idLength = 16
+
+var (
+	ErrInvalid = errors.New("invalid share link")
+	ErrExpired = errors.New("share link has expired")
+)
+
+// Link is the content of a signed token.
+type Link struct {
+	// ID identifies the link to revoke it
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Permission is the access to a terminal, View or Write
+	Permission string `json:"perm,omitempty"`
+	// File is the path of a shared file in the file manager root Root,
+	// an empty Root is the default root
+	File    string `json:"file,omitempty"`
+	Root    string `json:"root,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+// New creates a link with a new ID valid for ttl.
+func New(kind string, permission string, file string, ttl time.Duration) (*Link, error) {
+	link := &Link{
+		ID:         randomstring.Generate(idLength),
+		Kind:       kind,
+		Permission: permission,
+		File:       file,
+		Expires:    time.Now().Add(ttl).Unix(),
+	}
+	switch {
+	case kind == Terminal && (permission == View || permission == Write):
+	case kind == File && file != "":
+		link.Permission = ""
+	case kind == Terminal:
+		return nil, errors.Errorf("unknown permission `%s`", permission)
+	case kind == File:
+		return nil, errors.New("no file to share")
+	default:
+		return nil, errors.Errorf("unknown kind of share link `%s`", kind)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("lifetime of a share link must be positive")
+	}
+	return link, nil
+}
+
+// ExpiresAt returns when the link expires.
+func (link *Link) ExpiresAt() time.Time {
+	return time.Unix(link.Expires, 0)
+}
+
+// Sign returns the token of link signed with secret.
+func Sign(secret []byte, link *Link) (string, error) {
+	payload, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signature(secret, encoded)), nil
+}
+
+// Verify returns the link of a token signed with secret,
+// ErrInvalid or ErrExpired when it grants no access.
+func Verify(secret []byte, token string) (*Link, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signature(secret, parts[0])) {
+		return nil, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var link Link
+	if err := json.Unmarshal(payload, &link); err != nil {
+		return nil, ErrInvalid
+	}
+	if time.Now().After(link.ExpiresAt()) {
+		return nil, ErrExpired
+	}
+	return &link, nil
+}
+
+func signature(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// URL returns the address of a link on the gotty server at base,
+// such as https://gotty.example.com/.
+func URL(base string, link *Link, token string) string {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	if link.Kind == File {
+		query := "file=" + url.QueryEscape(link.File)
+		if link.Root != "" {
+			query += "&root=" + url.QueryEscape(link.Root)
+		}
+		return base + "api/download?" + query + "&share=" + token
+	}
+	return base + "?share=" + token
+}
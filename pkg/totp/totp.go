@@ -0,0 +1,96 @@
+// Package totp implements time-based one-time passwords of RFC 6238
+// with the parameters authenticator apps support: HMAC-SHA1, 6 digits and 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Period is the number of seconds a code is valid
+	Period = 30
+	digits = 6
+	// secretLength is the length of new secrets in bytes, as recommended by RFC 4226
+	secretLength = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret encoded in base32.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// ParseSecret decodes a base32 secret, spaces and case are ignored.
+func ParseSecret(secret string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(normalized, "="))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid TOTP secret")
+	}
+	if len(key) == 0 {
+		return nil, errors.New("empty TOTP secret")
+	}
+	return key, nil
+}
+
+// Counter returns the time step of t.
+func Counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code of key for the time step counter.
+func Code(key []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
+
+// Validate returns the time step of code when it's valid at t
+// or skew steps before or after, so that clocks may differ a little.
+func Validate(key []byte, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return 0, false
+	}
+	now := Counter(t)
+	for counter := now - int64(skew); counter <= now+int64(skew); counter++ {
+		if subtle.ConstantTimeCompare([]byte(Code(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth URI of a secret for authenticator apps, usually shown as a QR code.
+func URI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	cli "github.com/urfave/cli/v2"
+
+	"gotty/backend/replay"
+	"gotty/server"
+	"gotty/utils"
+)
+
+// replayCommand serves asciicast recordings, such as the ones written with --record-dir.
+// A directory lets clients choose a recording with the file parameter.
+func replayCommand(appOptions *server.Options, cliFlags []cli.Flag, flagMappings map[string]string) *cli.Command {
+	replayOptions := &replay.Options{}
+	if err := utils.ApplyDefaultValues(replayOptions); err != nil {
+		exit(err, 1)
+	}
+	replayFlags, replayMappings, err := utils.GenerateFlags(replayOptions)
+	if err != nil {
+		exit(err, 3)
+	}
+
+	return &cli.Command{
+		Name:      "replay",
+		Usage:     "Play back recorded sessions in the browser",
+		ArgsUsage: "<file.cast|directory>",
+		Flags:     replayFlags,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				cli.ShowCommandHelp(c, "replay")
+				exit(fmt.Errorf("Error: A recording or directory is required."), 1)
+			}
+
+			loadConfigFile(c, appOptions, replayOptions)
+			utils.ApplyFlags(cliFlags, flagMappings, c, appOptions)
+			utils.ApplyFlags(replayFlags, replayMappings, c, replayOptions)
+			// recordings are read only, the file parameter picks one from a directory
+			appOptions.PermitWrite = false
+			appOptions.PermitArguments = true
+			// every client controls its own playback
+			appOptions.EnableSharedSession = false
+			appOptions.SessionGracePeriod = 0
+			appOptions.RecordDir = ""
+			prepareOptions(c, appOptions)
+
+			path := c.Args().First()
+			factory, err := replay.NewFactory(path, replayOptions)
+			if err != nil {
+				exit(err, 3)
+			}
+
+			hostname, _ := os.Hostname()
+			appOptions.TitleVariables = map[string]interface{}{
+				"command":  "replay",
+				"argv":     []string{path},
+				"hostname": hostname,
+			}
+
+			serve(factory, appOptions, fmt.Sprintf("recordings: %s", path))
+			return nil
+		},
+	}
+}
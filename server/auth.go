@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/passwd"
+	"gotty/pkg/sharelink"
+)
+
+// ErrUnauthenticated is returned by an Authenticator that can't identify the user.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the user of an authenticated request.
+type Identity struct {
+	Name   string
+	Email  string
+	Groups []string
+	// Provider is the name of the Authenticator that identified the user
+	Provider string
+	// Share is the link of a client without an account, nil for users
+	Share *sharelink.Link `json:"-"`
+	// Certificate is the client certificate identifying the user, nil for other providers
+	Certificate *ClientCertificate `json:"-"`
+}
+
+// env returns the environment variables describing the user to the slave.
+func (identity *Identity) env() map[string]string {
+	env := map[string]string{}
+	if identity == nil {
+		return env
+	}
+	env["GOTTY_USER"] = identity.Name
+	if identity.Email != "" {
+		env["GOTTY_EMAIL"] = identity.Email
+	}
+	if len(identity.Groups) > 0 {
+		env["GOTTY_GROUPS"] = strings.Join(identity.Groups, ",")
+	}
+	if cert := identity.Certificate; cert != nil {
+		env["GOTTY_CLIENT_CN"] = cert.CommonName
+		env["GOTTY_CLIENT_SERIAL"] = cert.Serial
+		env["GOTTY_CLIENT_ISSUER"] = cert.Issuer
+		env["GOTTY_CLIENT_FINGERPRINT"] = cert.Fingerprint
+	}
+	return env
+}
+
+// Credentials are the user name and password sent by a client.
+type Credentials struct {
+	User     string
+	Password string
+	// OTP is the one-time password of users with a TOTP secret
+	OTP string
+}
+
+// Authenticator identifies the user of requests.
+type Authenticator interface {
+	// Authenticate returns the identity of the user sending r, or ErrUnauthenticated.
+	// creds is nil when the client sent no credentials.
+	Authenticate(r *http.Request, creds *Credentials) (*Identity, error)
+}
+
+// newAuthenticator builds the authenticators enabled by options,
+// it returns nil when authentication is disabled.
+// login is the OpenID Connect authenticator, nil if disabled,
+// tokens are the sessions of users logged in with their password.
+func newAuthenticator(options *Options, login *oidcAuthenticator, tokens *tokenStore) (Authenticator, error) {
+	chain := authenticatorChain{}
+
+	if options.TLSClientIdentity != "" {
+		certs, err := newCertAuthenticator(options.TLSClientIdentity)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certs)
+	}
+
+	if login != nil {
+		chain = append(chain, login)
+	}
+
+	if options.AuthProxyHeader != "" {
+		proxy, err := newProxyAuthenticator(options.AuthProxyHeader, options.AuthTrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, proxy)
+	}
+
+	users := map[string]string{}
+	for name, password := range options.Users {
+		users[name] = password
+	}
+	if options.EnableBasicAuth && options.Credential != "" {
+		parts := strings.SplitN(options.Credential, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("credential must be in the form of user:pass")
+		}
+		users[parts[0]] = parts[1]
+	}
+	for name, password := range users {
+		if !passwd.IsHash(password) {
+			log.Printf("Password of user %s is not hashed, hash it with `gotty hash-password`", name)
+		}
+	}
+	passwords := []Authenticator{}
+	if len(users) > 0 {
+		passwords = append(passwords, staticAuthenticator(users))
+	}
+	if options.HtpasswdFile != "" {
+		htpasswd, err := newHtpasswdAuthenticator(options.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, htpasswd)
+	}
+
+	if len(options.TOTPSecrets) > 0 {
+		verifier, err := newTOTPVerifier(options.TOTPSecrets)
+		if err != nil {
+			return nil, err
+		}
+		for i, password := range passwords {
+			passwords[i] = &totpAuthenticator{authenticator: password, verifier: verifier}
+		}
+	}
+	chain = append(chain, passwords...)
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return append(authenticatorChain{tokens}, chain...), nil
+}
+
+// authenticate identifies the user of r with the authenticator of the server.
+// Credentials are taken from the Basic Authorization header,
+// browsers use session tokens from the login endpoint instead.
+func (server *Server) authenticate(r *http.Request) (*Identity, error) {
+	var creds *Credentials
+	if user, password, ok := r.BasicAuth(); ok {
+		creds = &Credentials{User: user, Password: password}
+	}
+	return server.authenticateWith(r, creds)
+}
+
+// authenticateWith identifies the user of r with creds, nil when the client sent none.
+// Clients and users with too many failed logins get a lockoutError.
+func (server *Server) authenticateWith(r *http.Request, creds *Credentials) (*Identity, error) {
+	var keys []string
+	if creds != nil && server.limiter != nil {
+		keys = []string{"IP " + server.clientIP(r), "user " + creds.User}
+		if wait := server.limiter.retryAfter(keys...); wait > 0 {
+			return nil, &lockoutError{retryAfter: wait}
+		}
+	}
+
+	identity, err := server.authenticator.Authenticate(r, creds)
+	if err != nil && err != ErrUnauthenticated {
+		log.Printf("Failed to authenticate %s: %v", r.RemoteAddr, err)
+		err = ErrUnauthenticated
+	}
+	if keys != nil {
+		if err != nil {
+			server.limiter.fail(keys...)
+		} else {
+			// the IP address isn't forgiven, a valid account must not reset a password spray
+			server.limiter.succeed(keys[1])
+		}
+	}
+	return identity, err
+}
+
+type identityKey struct{}
+
+// withIdentity returns a shallow copy of r carrying identity.
+func withIdentity(r *http.Request, identity *Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// identityFromRequest returns the identity of an authenticated request, nil if none.
+func identityFromRequest(r *http.Request) *Identity {
+	identity, _ := r.Context().Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// authenticatorChain tries each authenticator in order.
+type authenticatorChain []Authenticator
+
+func (chain authenticatorChain) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	for _, authenticator := range chain {
+		identity, err := authenticator.Authenticate(r, creds)
+		if err == nil {
+			return identity, nil
+		}
+		if err != ErrUnauthenticated {
+			return nil, err
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// staticAuthenticator checks credentials against a table of user names and
+// password hashes. Plain passwords are still accepted for compatibility.
+type staticAuthenticator map[string]string
+
+func (users staticAuthenticator) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	if creds == nil {
+		return nil, ErrUnauthenticated
+	}
+	password, ok := users[creds.User]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if passwd.IsHash(password) {
+		match, err := passwd.Verify(password, creds.Password)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to verify password of user %s", creds.User)
+		}
+		if !match {
+			return nil, ErrUnauthenticated
+		}
+	} else if subtle.ConstantTimeCompare([]byte(password), []byte(creds.Password)) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: creds.User, Provider: "static"}, nil
+}
+
+// proxyAuthenticator trusts the user name set in a header by a reverse proxy
+// that already authenticated the user.
+type proxyAuthenticator struct {
+	header  string
+	trusted []*net.IPNet
+}
+
+func newProxyAuthenticator(header string, trustedProxies string) (*proxyAuthenticator, error) {
+	trusted, err := parseNetworks(trustedProxies)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse trusted proxies")
+	}
+	if len(trusted) == 0 {
+		return nil, errors.New("trusted proxies are required to authenticate by a proxy header")
+	}
+	return &proxyAuthenticator{header: header, trusted: trusted}, nil
+}
+
+func (proxy *proxyAuthenticator) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	name := r.Header.Get(proxy.header)
+	if name == "" || !containsIP(proxy.trusted, r.RemoteAddr) {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: name, Provider: "proxy"}, nil
+}
+
+// parseNetworks parses a comma separated list of IP addresses and CIDR networks.
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP address `%s`", entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP tells whether the IP of a host:port address is in one of networks.
+func containsIP(networks []*net.IPNet, address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,9 @@
 package server
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"log"
 	"net/http"
-	"strings"
 )
 
 // handleAuthVerify handles authentication verification
@@ -15,28 +13,72 @@ func (server *Server) handleAuthVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	user := ""
+	if server.authenticator != nil {
+		identity, err := server.authenticate(r)
+		if respondLockout(w, err) {
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "Authentication failed",
+			})
+			return
+		}
+		log.Printf("Authentication succeeded: %s (%s)", r.RemoteAddr, identity.Name)
+		user = identity.Name
+	}
 
-	if len(token) != 2 || strings.ToLower(token[0]) != "basic" {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid authorization header",
-		})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Authentication successful",
+		"user":    user,
+	})
+}
+
+// loginRequest is the body of a request to the login endpoint.
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+}
+
+// handleAuthLogin checks the credentials of a user and returns a session token,
+// so that the browser doesn't keep the password.
+func (server *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if server.authenticator == nil {
+		http.Error(w, "Authentication is disabled", http.StatusBadRequest)
 		return
 	}
 
-	payload, err := base64.StdEncoding.DecodeString(token[1])
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid credentials",
-		})
+	var creds *Credentials
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err == nil && req.Username != "" {
+		creds = &Credentials{User: req.Username, Password: req.Password, OTP: req.OTP}
+	} else if user, password, ok := r.BasicAuth(); ok {
+		creds = &Credentials{User: user, Password: password}
+	}
+	if creds == nil {
+		http.Error(w, "Credentials are required", http.StatusBadRequest)
 		return
 	}
 
-	if server.options.Credential != string(payload) {
+	identity, err := server.authenticateWith(r, creds)
+	if respondLockout(w, err) {
+		log.Printf("Login rejected: %s (%s), %v", server.clientIP(r), creds.User, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("Login failed: %s (%s)", server.clientIP(r), creds.User)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
@@ -45,12 +87,29 @@ func (server *Server) handleAuthVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Authentication succeeded: %s", r.RemoteAddr)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	token, expires, err := server.tokens.issue(identity)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Login succeeded: %s (%s)", r.RemoteAddr, identity.Name)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
-		"message": "Authentication successful",
+		"token":   token,
+		"expires": expires,
+		"user":    identity.Name,
 	})
 }
+
+// handleAuthLogout revokes the session token of the request.
+func (server *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := tokenFromRequest(r); token != "" {
+		server.tokens.revoke(token)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
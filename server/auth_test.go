@@ -0,0 +1,176 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"gotty/pkg/passwd"
+)
+
+// testHashes returns a bcrypt and an argon2id hash of password.
+func testHashes(t *testing.T, password string) (string, string) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Unexpected error from GenerateFromPassword(): %s", err)
+	}
+	argon2Hash, err := passwd.Hash(password)
+	if err != nil {
+		t.Fatalf("Unexpected error from Hash(): %s", err)
+	}
+	return string(bcryptHash), argon2Hash
+}
+
+func TestStaticAuthenticator(t *testing.T) {
+	bcryptHash, argon2Hash := testHashes(t, "secret")
+	users := staticAuthenticator{
+		"alice": "secret",
+		"bob":   bcryptHash,
+		"carol": argon2Hash,
+		"dave":  "$argon2id$v=19$m=65536,t=3,p=4$broken",
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		identity, err := users.Authenticate(r, &Credentials{User: user, Password: "secret"})
+		if err != nil || identity.Name != user || identity.Provider != "static" {
+			t.Fatalf("Unexpected result of Authenticate() for %s: %+v %v", user, identity, err)
+		}
+		for _, password := range []string{"wrong", "", "secret ", "Secret"} {
+			if _, err := users.Authenticate(r, &Credentials{User: user, Password: password}); err != ErrUnauthenticated {
+				t.Fatalf("Unexpected error from Authenticate() for %s with password %q: %v", user, password, err)
+			}
+		}
+	}
+	// a hash isn't a password
+	if _, err := users.Authenticate(r, &Credentials{User: "bob", Password: bcryptHash}); err != ErrUnauthenticated {
+		t.Fatalf("Login with the hash of the password: %v", err)
+	}
+	if _, err := users.Authenticate(r, &Credentials{User: "eve", Password: "secret"}); err != ErrUnauthenticated {
+		t.Fatalf("Unexpected error from Authenticate() for an unknown user: %v", err)
+	}
+	if _, err := users.Authenticate(r, nil); err != ErrUnauthenticated {
+		t.Fatalf("Unexpected error from Authenticate() without credentials: %v", err)
+	}
+	if _, err := users.Authenticate(r, &Credentials{User: "dave", Password: "secret"}); err == nil || err == ErrUnauthenticated {
+		t.Fatalf("Expected an error for an invalid hash: %v", err)
+	}
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	bcryptHash, argon2Hash := testHashes(t, "secret")
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Unexpected error writing the htpasswd file: %s", err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	now := time.Now()
+	write("# users\n\nbob:"+bcryptHash+"\ncarol:"+argon2Hash+"\n"+
+		// htpasswd -B writes $2y$ hashes
+		"yves:$2y$"+bcryptHash[4:]+"\n"+
+		// plain, MD5 and SHA1 entries are ignored
+		"alice:secret\nmallory:$apr1$salt$8nXUwBYvHkwNb6ODj1cJo/\ntrent:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", now.Add(-time.Hour))
+
+	htpasswd, err := newHtpasswdAuthenticator(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from newHtpasswdAuthenticator(): %s", err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, user := range []string{"bob", "carol", "yves"} {
+		identity, err := htpasswd.Authenticate(r, &Credentials{User: user, Password: "secret"})
+		if err != nil || identity.Name != user || identity.Provider != "htpasswd" {
+			t.Fatalf("Unexpected result of Authenticate() for %s: %+v %v", user, identity, err)
+		}
+		if _, err := htpasswd.Authenticate(r, &Credentials{User: user, Password: "wrong"}); err != ErrUnauthenticated {
+			t.Fatalf("Unexpected error from Authenticate() for %s with a bad password: %v", user, err)
+		}
+	}
+	for _, user := range []string{"alice", "mallory", "trent", "eve", ""} {
+		if _, err := htpasswd.Authenticate(r, &Credentials{User: user, Password: "secret"}); err != ErrUnauthenticated {
+			t.Fatalf("Unexpected error from Authenticate() for %q: %v", user, err)
+		}
+	}
+	if _, err := htpasswd.Authenticate(r, nil); err != ErrUnauthenticated {
+		t.Fatalf("Unexpected error from Authenticate() without credentials: %v", err)
+	}
+
+	// users are added and revoked without restarting
+	write("carol:"+argon2Hash+"\ndave:"+bcryptHash+"\n", now)
+	if _, err := htpasswd.Authenticate(r, &Credentials{User: "dave", Password: "secret"}); err != nil {
+		t.Fatalf("Added user not authenticated: %v", err)
+	}
+	if _, err := htpasswd.Authenticate(r, &Credentials{User: "bob", Password: "secret"}); err != ErrUnauthenticated {
+		t.Fatalf("Removed user authenticated: %v", err)
+	}
+	// an invalid file keeps the users loaded before
+	write("invalid entry\n", now.Add(time.Hour))
+	if _, err := htpasswd.Authenticate(r, &Credentials{User: "dave", Password: "secret"}); err != nil {
+		t.Fatalf("Users lost with an invalid file: %v", err)
+	}
+
+	if _, err := newHtpasswdAuthenticator(path); err == nil {
+		t.Fatalf("Expected an error for an invalid file")
+	}
+	if _, err := newHtpasswdAuthenticator(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("Expected an error for a missing file")
+	}
+}
+
+func TestProxyAuthenticator(t *testing.T) {
+	proxy, err := newProxyAuthenticator("X-Forwarded-User", "10.0.0.0/8, 192.168.1.1, ::1")
+	if err != nil {
+		t.Fatalf("Unexpected error from newProxyAuthenticator(): %s", err)
+	}
+	request := func(remoteAddr string, user string) (*Identity, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.Header.Set("X-Forwarded-User", user)
+		}
+		return proxy.Authenticate(r, nil)
+	}
+
+	for _, remoteAddr := range []string{"10.1.2.3:4567", "192.168.1.1:4567", "[::1]:4567", "[::ffff:192.168.1.1]:4567"} {
+		identity, err := request(remoteAddr, "alice")
+		if err != nil || identity.Name != "alice" || identity.Provider != "proxy" {
+			t.Fatalf("Unexpected result of Authenticate() from %s: %+v %v", remoteAddr, identity, err)
+		}
+	}
+	// the header of other clients is ignored
+	for _, remoteAddr := range []string{"192.168.1.2:4567", "11.0.0.1:4567", "[::2]:4567", "[fe80::1]:4567", "invalid"} {
+		if identity, err := request(remoteAddr, "alice"); err != ErrUnauthenticated {
+			t.Fatalf("Header trusted from %s: %+v %v", remoteAddr, identity, err)
+		}
+	}
+	if _, err := request("10.1.2.3:4567", ""); err != ErrUnauthenticated {
+		t.Fatalf("Unexpected error from Authenticate() without the header: %v", err)
+	}
+
+	// untrusted clients still log in with their password
+	options := &Options{AuthProxyHeader: "X-Forwarded-User", AuthTrustedProxies: "10.0.0.0/8", Users: map[string]string{"bob": "secret"}}
+	authenticator, err := newAuthenticator(options, nil, newTokenStore(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error from newAuthenticator(): %s", err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.1:4567"
+	r.Header.Set("X-Forwarded-User", "alice")
+	if _, err := authenticator.Authenticate(r, nil); err != ErrUnauthenticated {
+		t.Fatalf("Header trusted from an untrusted client: %v", err)
+	}
+	if identity, err := authenticator.Authenticate(r, &Credentials{User: "bob", Password: "secret"}); err != nil || identity.Name != "bob" {
+		t.Fatalf("Unexpected result of Authenticate() with a password: %+v %v", identity, err)
+	}
+
+	for _, trusted := range []string{"", " , ", "10.0.0.0/33", "proxy.example.com"} {
+		if _, err := newProxyAuthenticator("X-Forwarded-User", trusted); err == nil {
+			t.Fatalf("Expected an error for trusted proxies %q", trusted)
+		}
+	}
+}
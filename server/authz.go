@@ -0,0 +1,185 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/sharelink"
+)
+
+// Capability is an action a role permits.
+type Capability string
+
+const (
+	// CapView permits watching the terminal.
+	CapView Capability = "view"
+	// CapWrite permits typing into the terminal, permit_write must be enabled as well.
+	CapWrite Capability = "write"
+	// CapUpload permits listing and uploading files.
+	CapUpload Capability = "upload"
+	// CapDownload permits listing and downloading files.
+	CapDownload Capability = "download"
+	// CapDelete permits listing and deleting files.
+	CapDelete Capability = "delete"
+	// CapAdmin permits managing what other users created, such as their share links.
+	CapAdmin Capability = "admin"
+)
+
+var capabilities = map[Capability]bool{
+	CapView:     true,
+	CapWrite:    true,
+	CapUpload:   true,
+	CapDownload: true,
+	CapDelete:   true,
+	CapAdmin:    true,
+}
+
+// Role is a set of capabilities granted to users.
+type Role struct {
+	Capabilities []string `hcl:"capabilities"`
+	// Profiles are the names of the command profiles users of the role can start
+	Profiles []string `hcl:"profiles"`
+}
+
+// Profile is a command users can start instead of the command of gotty,
+// by opening the page with ?profile=name.
+type Profile struct {
+	Command []string `hcl:"command"`
+}
+
+// permissions are the capabilities and profiles granted to a user.
+type permissions struct {
+	capabilities map[Capability]bool
+	// profiles is nil when every profile is permitted
+	profiles map[string]bool
+}
+
+func (perms *permissions) can(capability Capability) bool {
+	return perms.capabilities[capability]
+}
+
+func (perms *permissions) canStart(profile string) bool {
+	return perms.profiles == nil || perms.profiles[profile]
+}
+
+// validateRoles checks that roles refer to known capabilities, profiles and roles.
+func validateRoles(options *Options) error {
+	for name, role := range options.Roles {
+		for _, capability := range role.Capabilities {
+			if !capabilities[Capability(capability)] {
+				return errors.Errorf("unknown capability `%s` in role `%s`", capability, name)
+			}
+		}
+		for _, profile := range role.Profiles {
+			if _, ok := options.Profiles[profile]; !ok {
+				return errors.Errorf("unknown profile `%s` in role `%s`", profile, name)
+			}
+		}
+	}
+	for name, profile := range options.Profiles {
+		if len(profile.Command) == 0 {
+			return errors.Errorf("no command in profile `%s`", name)
+		}
+	}
+
+	bindings := []string{options.DefaultRole}
+	for _, role := range options.UserRoles {
+		bindings = append(bindings, role)
+	}
+	for _, role := range options.GroupRoles {
+		bindings = append(bindings, role)
+	}
+	for _, role := range bindings {
+		if _, ok := options.Roles[role]; role != "" && !ok {
+			return errors.Errorf("unknown role `%s`", role)
+		}
+	}
+	return nil
+}
+
+// permissions returns what identity may do, identity is nil for anonymous users.
+// Without roles in the configuration everyone may do everything
+// but writing, which is permitted by permit_write.
+// Share links grant access to their terminal or file only.
+func (server *Server) permissions(identity *Identity) *permissions {
+	perms := &permissions{capabilities: map[Capability]bool{}}
+	if identity != nil && identity.Share != nil {
+		perms.profiles = map[string]bool{}
+		switch identity.Share.Kind {
+		case sharelink.Terminal:
+			perms.capabilities[CapView] = true
+			perms.capabilities[CapWrite] = identity.Share.Permission == sharelink.Write
+		case sharelink.File:
+			perms.capabilities[CapDownload] = true
+		}
+	} else if len(server.options.Roles) == 0 {
+		for capability := range capabilities {
+			perms.capabilities[capability] = true
+		}
+	} else {
+		perms.profiles = map[string]bool{}
+		for _, name := range server.rolesOf(identity) {
+			role := server.options.Roles[name]
+			for _, capability := range role.Capabilities {
+				perms.capabilities[Capability(capability)] = true
+			}
+			for _, profile := range role.Profiles {
+				perms.profiles[profile] = true
+			}
+		}
+	}
+	if !server.options.PermitWrite {
+		perms.capabilities[CapWrite] = false
+	}
+	return perms
+}
+
+// rolesOf returns the roles bound to the user and the groups of identity,
+// or the default role when there are none.
+func (server *Server) rolesOf(identity *Identity) []string {
+	roles := []string{}
+	if identity != nil {
+		if role, ok := server.options.UserRoles[identity.Name]; ok {
+			roles = append(roles, role)
+		}
+		for _, group := range identity.Groups {
+			if role, ok := server.options.GroupRoles[group]; ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if len(roles) == 0 && server.options.DefaultRole != "" {
+		roles = append(roles, server.options.DefaultRole)
+	}
+	return roles
+}
+
+// authorize tells whether the user of r has one of the capabilities,
+// and responds with 403 Forbidden if not.
+func (server *Server) authorize(w http.ResponseWriter, r *http.Request, required ...Capability) bool {
+	if server.allowed(r, required...) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// allowed tells whether the user of r has one of the capabilities, and logs the denial if not.
+func (server *Server) allowed(r *http.Request, required ...Capability) bool {
+	identity := identityFromRequest(r)
+	perms := server.permissions(identity)
+	for _, capability := range required {
+		if perms.can(capability) {
+			return true
+		}
+	}
+
+	name := "anonymous"
+	if identity != nil {
+		name = identity.Name
+	}
+	log.Printf("Denied %s %s to %s (%s)", r.Method, r.URL.Path, r.RemoteAddr, name)
+	return false
+}
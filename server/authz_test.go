@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	options := &Options{
+		PermitWrite: true,
+		Roles: map[string]*Role{
+			"support": {Capabilities: []string{"view", "download"}},
+			"dev":     {Capabilities: []string{"view", "write", "upload", "download", "delete"}, Profiles: []string{"top"}},
+		},
+		Profiles:    map[string]*Profile{"top": {Command: []string{"top"}}},
+		UserRoles:   map[string]string{"alice": "dev"},
+		GroupRoles:  map[string]string{"helpdesk": "support"},
+		DefaultRole: "",
+	}
+	if err := options.Validate(); err != nil {
+		t.Fatalf("Unexpected error from Validate(): %s", err)
+	}
+	server := &Server{options: options}
+
+	alice := server.permissions(&Identity{Name: "alice"})
+	if !alice.can(CapWrite) || !alice.can(CapDelete) || !alice.canStart("top") {
+		t.Fatalf("Unexpected permissions of alice: %+v", alice)
+	}
+	bob := server.permissions(&Identity{Name: "bob", Groups: []string{"helpdesk"}})
+	if !bob.can(CapView) || !bob.can(CapDownload) || bob.can(CapWrite) || bob.can(CapDelete) || bob.canStart("top") {
+		t.Fatalf("Unexpected permissions of bob: %+v", bob)
+	}
+	if anonymous := server.permissions(nil); anonymous.can(CapView) {
+		t.Fatalf("Unexpected permissions of anonymous: %+v", anonymous)
+	}
+
+	// permit_write still disables writing for everyone
+	options.PermitWrite = false
+	if server.permissions(&Identity{Name: "alice"}).can(CapWrite) {
+		t.Fatalf("Write permitted without permit_write")
+	}
+
+	r := withIdentity(httptest.NewRequest("DELETE", "/api/delete?file=foo", nil), &Identity{Name: "bob", Groups: []string{"helpdesk"}})
+	w := httptest.NewRecorder()
+	server.handleFileDelete(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Unexpected status of delete by bob: %d", w.Code)
+	}
+
+	options.UserRoles["carol"] = "admin"
+	if err := options.Validate(); err == nil {
+		t.Fatalf("Expected an error for an unknown role")
+	}
+}
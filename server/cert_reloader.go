@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/homedir"
+)
+
+// certReloader serves the TLS certificate, the CA and the CRL of client
+// certificates from files, and swaps them when the files change or on Server.ReloadTLS(),
+// so that certificates can be rotated without dropping connections.
+type certReloader struct {
+	// crtFile and keyFile are empty when certificates come from ACME
+	crtFile string
+	keyFile string
+	// caFile is empty without client certificate authentication
+	caFile string
+	// crlFile is empty when revocations are not checked
+	crlFile string
+
+	// mutex serializes reloads, handshakes read state without locking
+	mutex sync.Mutex
+	state atomic.Pointer[certState]
+}
+
+type certState struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	// crl lists the revoked client certificates, nil without crlFile
+	crl *x509.RevocationList
+	// modTimes of the files when they were loaded
+	modTimes []time.Time
+}
+
+func newCertReloader(options *Options) *certReloader {
+	reloader := &certReloader{}
+	if options.ACMEDomains == "" {
+		reloader.crtFile = homedir.Expand(options.TLSCrtFile)
+		reloader.keyFile = homedir.Expand(options.TLSKeyFile)
+	}
+	if options.EnableTLSClientAuth {
+		reloader.caFile = homedir.Expand(options.TLSCACrtFile)
+	}
+	if options.TLSCRLFile != "" {
+		reloader.crlFile = homedir.Expand(options.TLSCRLFile)
+	}
+	return reloader
+}
+
+func (reloader *certReloader) files() []string {
+	files := []string{}
+	for _, file := range []string{reloader.crtFile, reloader.keyFile, reloader.caFile, reloader.crlFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// reload loads the files if they changed since the last load, or always with force.
+// The files in use are kept when the new ones can't be loaded.
+func (reloader *certReloader) reload(force bool) error {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	files := reloader.files()
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open `%s`", file)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	current := reloader.state.Load()
+	if !force && current != nil && equalTimes(current.modTimes, modTimes) {
+		return nil
+	}
+
+	state := &certState{modTimes: modTimes}
+	if reloader.crtFile != "" {
+		certificate, err := tls.LoadX509KeyPair(reloader.crtFile, reloader.keyFile)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load TLS certificate `%s`", reloader.crtFile)
+		}
+		state.certificate = &certificate
+	}
+	if reloader.caFile != "" {
+		caCert, err := os.ReadFile(reloader.caFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not open CA crt file `%s`", reloader.caFile)
+		}
+		state.clientCAs = x509.NewCertPool()
+		if !state.clientCAs.AppendCertsFromPEM(caCert) {
+			return errors.Errorf("could not parse CA crt file data in `%s`", reloader.caFile)
+		}
+		if reloader.crlFile != "" {
+			crl, err := loadCRL(reloader.crlFile, caCert)
+			if err != nil {
+				return err
+			}
+			state.crl = crl
+		}
+	}
+	reloader.state.Store(state)
+
+	if current != nil {
+		if state.certificate != nil {
+			log.Printf("Reloaded TLS certificate %s, valid until %s", reloader.crtFile, state.certificate.Leaf.NotAfter.Format(time.RFC3339))
+		}
+		if state.clientCAs != nil {
+			log.Printf("Reloaded CA crt file %s", reloader.caFile)
+		}
+		if state.crl != nil {
+			log.Printf("Reloaded CRL %s, %d revoked certificates", reloader.crlFile, len(state.crl.RevokedCertificateEntries))
+		}
+	}
+	if state.crl != nil && !state.crl.NextUpdate.IsZero() && time.Now().After(state.crl.NextUpdate) {
+		log.Printf("CRL %s is outdated since %s", reloader.crlFile, state.crl.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadCRL parses the PEM or DER encoded CRL in file, which must be signed by
+// one of the PEM encoded certificates in caCert.
+func loadCRL(file string, caCert []byte) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open CRL file `%s`", file)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse CRL file `%s`", file)
+	}
+	for block, rest := pem.Decode(caCert); block != nil; block, rest = pem.Decode(rest) {
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err == nil && bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			return crl, nil
+		}
+	}
+	return nil, errors.Errorf("CRL file `%s` is not signed by a CA of client certificates", file)
+}
+
+// watch reloads the files when they change until ctx is done.
+func (reloader *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := reloader.reload(false); err != nil {
+				log.Printf("Failed to reload TLS files, keeping the current ones: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (reloader *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.state.Load().certificate, nil
+}
+
+// configForClient returns a GetConfigForClient function verifying client
+// certificates with the current CA, base is the configuration to complete.
+func (reloader *certReloader) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.ClientCAs = reloader.state.Load().clientCAs
+		return config, nil
+	}
+}
+
+// verifyConnection rejects client certificates revoked by the CRL.
+func (reloader *certReloader) verifyConnection(cs tls.ConnectionState) error {
+	crl := reloader.state.Load().crl
+	if crl == nil || len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := cs.PeerCertificates[0]
+	if !bytes.Equal(cert.RawIssuer, crl.RawIssuer) {
+		return nil
+	}
+	for _, revoked := range crl.RevokedCertificateEntries {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return errors.Errorf("client certificate %s (%s) is revoked", cert.SerialNumber.Text(16), cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+func equalTimes(a []time.Time, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	options := &Options{
+		TLSCrtFile:          filepath.Join(dir, "gotty.crt"),
+		TLSKeyFile:          filepath.Join(dir, "gotty.key"),
+		TLSCACrtFile:        filepath.Join(dir, "ca.crt"),
+		EnableTLSClientAuth: true,
+	}
+	write := func(host string, modTime time.Time) []byte {
+		crt, key, err := generateSelfSigned([]string{host})
+		if err != nil {
+			t.Fatalf("Unexpected error from generateSelfSigned(): %s", err)
+		}
+		for file, data := range map[string][]byte{options.TLSCrtFile: crt, options.TLSKeyFile: key, options.TLSCACrtFile: crt} {
+			os.WriteFile(file, data, 0600)
+			os.Chtimes(file, modTime, modTime)
+		}
+		return crt
+	}
+
+	write("first.example.com", time.Now().Add(-time.Hour))
+	reloader := newCertReloader(options)
+	if err := reloader.reload(true); err != nil {
+		t.Fatalf("Unexpected error from reload(): %s", err)
+	}
+	certificate, _ := reloader.GetCertificate(nil)
+	if certificate.Leaf.DNSNames[0] != "first.example.com" {
+		t.Fatalf("Unexpected certificate: %v", certificate.Leaf.DNSNames)
+	}
+
+	second := write("second.example.com", time.Now())
+	if err := reloader.reload(false); err != nil {
+		t.Fatalf("Unexpected error from reload(): %s", err)
+	}
+	certificate, _ = reloader.GetCertificate(nil)
+	if certificate.Leaf.DNSNames[0] != "second.example.com" {
+		t.Fatalf("Certificate not reloaded: %v", certificate.Leaf.DNSNames)
+	}
+	config, _ := reloader.configForClient(&tls.Config{})(nil)
+	expected := x509.NewCertPool()
+	expected.AppendCertsFromPEM(second)
+	if !expected.Equal(config.ClientCAs) {
+		t.Fatalf("CA not reloaded")
+	}
+
+	// an invalid key keeps the current certificate
+	os.WriteFile(options.TLSKeyFile, []byte("invalid"), 0600)
+	if err := reloader.reload(true); err == nil {
+		t.Fatalf("No error from reload() with an invalid key")
+	}
+	if current, _ := reloader.GetCertificate(nil); current != certificate {
+		t.Fatalf("Certificate replaced by an invalid one")
+	}
+}
+
+func TestCertReloaderCRL(t *testing.T) {
+	dir := t.TempDir()
+	options := &Options{
+		TLSCACrtFile:        filepath.Join(dir, "ca.crt"),
+		TLSCRLFile:          filepath.Join(dir, "ca.crl"),
+		EnableTLSClientAuth: true,
+		ACMEDomains:         "example.com",
+	}
+	ca := newTestCA(t)
+	os.WriteFile(options.TLSCACrtFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	writeCRL := func(signer *testCA) {
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(1),
+			ThisUpdate:                time.Now(),
+			NextUpdate:                time.Now().Add(time.Hour),
+			RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(0x2a), RevocationTime: time.Now()}},
+		}, signer.cert, signer.key)
+		if err != nil {
+			t.Fatalf("Unexpected error from CreateRevocationList(): %s", err)
+		}
+		os.WriteFile(options.TLSCRLFile, crl, 0600)
+	}
+
+	writeCRL(ca)
+	reloader := newCertReloader(options)
+	if err := reloader.reload(true); err != nil {
+		t.Fatalf("Unexpected error from reload(): %s", err)
+	}
+	revoked := ca.issue(t, &x509.Certificate{SerialNumber: big.NewInt(0x2a), Subject: pkix.Name{CommonName: "mallory"}})
+	valid := ca.issue(t, &x509.Certificate{SerialNumber: big.NewInt(0x2b), Subject: pkix.Name{CommonName: "alice"}})
+	if err := reloader.verifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{revoked}}); err == nil {
+		t.Fatalf("Revoked certificate accepted")
+	}
+	if err := reloader.verifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{valid}}); err != nil {
+		t.Fatalf("Unexpected error from verifyConnection(): %s", err)
+	}
+
+	// a CRL of another CA is rejected
+	writeCRL(newTestCA(t))
+	if err := reloader.reload(true); err == nil {
+		t.Fatalf("No error from reload() with a CRL of another CA")
+	}
+}
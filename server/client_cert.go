@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ClientCertificate describes the verified TLS client certificate of a user.
+type ClientCertificate struct {
+	CommonName string
+	Serial     string
+	Issuer     string
+	// Fingerprint is the SHA-256 fingerprint of the certificate
+	Fingerprint string
+}
+
+func newClientCertificate(cert *x509.Certificate) *ClientCertificate {
+	return &ClientCertificate{
+		CommonName:  cert.Subject.CommonName,
+		Serial:      cert.SerialNumber.Text(16),
+		Issuer:      cert.Issuer.String(),
+		Fingerprint: fingerprint(cert.Raw),
+	}
+}
+
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// certAuthenticator identifies users by a field of their verified client certificate:
+// the subject CN, the first email, DNS or URI SAN, or a subject attribute or
+// SAN otherName with an OID, such as the UPN of smartcard certificates.
+// The organizational units of the subject are the groups of the user.
+type certAuthenticator struct {
+	field string
+	// oid is set when field is an OID
+	oid asn1.ObjectIdentifier
+}
+
+func newCertAuthenticator(field string) (*certAuthenticator, error) {
+	authenticator := &certAuthenticator{field: field}
+	switch field {
+	case "cn", "email", "dns", "uri":
+		return authenticator, nil
+	}
+	for _, part := range strings.Split(field, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("unknown client certificate identity `%s`, use cn, email, dns, uri or an OID", field)
+		}
+		authenticator.oid = append(authenticator.oid, n)
+	}
+	if len(authenticator.oid) < 2 {
+		return nil, errors.Errorf("unknown client certificate identity `%s`, use cn, email, dns, uri or an OID", field)
+	}
+	return authenticator, nil
+}
+
+func (authenticator *certAuthenticator) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, ErrUnauthenticated
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	name := authenticator.name(cert)
+	if name == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	identity := &Identity{
+		Name:        name,
+		Groups:      append([]string{}, cert.Subject.OrganizationalUnit...),
+		Provider:    "certificate",
+		Certificate: newClientCertificate(cert),
+	}
+	if len(cert.EmailAddresses) > 0 {
+		identity.Email = cert.EmailAddresses[0]
+	}
+	return identity, nil
+}
+
+// name returns the configured field of cert, empty if cert has none.
+func (authenticator *certAuthenticator) name(cert *x509.Certificate) string {
+	switch authenticator.field {
+	case "cn":
+		return cert.Subject.CommonName
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case "uri":
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	default:
+		for _, attribute := range cert.Subject.Names {
+			if value, ok := attribute.Value.(string); ok && attribute.Type.Equal(authenticator.oid) {
+				return value
+			}
+		}
+		return otherName(cert, authenticator.oid)
+	}
+	return ""
+}
+
+// otherNameValue is an otherName of the subject alternative names (RFC 5280 section 4.2.1.6).
+type otherNameValue struct {
+	TypeID asn1.ObjectIdentifier
+	// Value is explicitly tagged [0]
+	Value asn1.RawValue
+}
+
+// otherName returns the string value of the otherName SAN of cert with oid, empty if none.
+func otherName(cert *x509.Certificate, oid asn1.ObjectIdentifier) string {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &names); err != nil {
+			return ""
+		}
+		rest := names.Bytes
+		for len(rest) > 0 {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return ""
+			}
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+			var other otherNameValue
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil || !other.TypeID.Equal(oid) {
+				continue
+			}
+			var value string
+			if _, err := asn1.Unmarshal(other.Value.Bytes, &value); err == nil {
+				return value
+			}
+		}
+	}
+	return ""
+}
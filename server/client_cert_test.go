@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error from CreateCertificate(): %s", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Unexpected error from CreateCertificate(): %s", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// upnExtension returns a subject alternative name extension with an email and a UPN otherName.
+func upnExtension(t *testing.T, email string, upn string) pkix.Extension {
+	value, _ := asn1.Marshal(upn)
+	other, err := asn1.MarshalWithParams(otherNameValue{
+		TypeID: oidUPN,
+		Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+	}, "tag:0")
+	if err != nil {
+		t.Fatalf("Unexpected error from Marshal(): %s", err)
+	}
+	rfc822, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte(email)})
+	names, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(rfc822, other...)})
+	return pkix.Extension{Id: oidSubjectAltName, Value: names}
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(0x2a),
+		Subject: pkix.Name{
+			CommonName:         "Alice Smith",
+			OrganizationalUnit: []string{"ops"},
+			ExtraNames:         []pkix.AttributeTypeAndValue{{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: "asmith"}},
+		},
+		ExtraExtensions: []pkix.Extension{upnExtension(t, "alice@example.com", "alice@corp.example.com")},
+	})
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+
+	for field, expected := range map[string]string{
+		"cn":                        "Alice Smith",
+		"email":                     "alice@example.com",
+		"0.9.2342.19200300.100.1.1": "asmith",
+		"1.3.6.1.4.1.311.20.2.3":    "alice@corp.example.com",
+	} {
+		authenticator, err := newCertAuthenticator(field)
+		if err != nil {
+			t.Fatalf("Unexpected error from newCertAuthenticator(%s): %s", field, err)
+		}
+		identity, err := authenticator.Authenticate(r, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error from Authenticate() with %s: %s", field, err)
+		}
+		if identity.Name != expected {
+			t.Fatalf("Unexpected name with %s: %s", field, identity.Name)
+		}
+	}
+
+	authenticator, _ := newCertAuthenticator("cn")
+	identity, _ := authenticator.Authenticate(r, nil)
+	env := identity.env()
+	if env["GOTTY_CLIENT_CN"] != "Alice Smith" || env["GOTTY_CLIENT_SERIAL"] != "2a" || env["GOTTY_GROUPS"] != "ops" || env["GOTTY_EMAIL"] != "alice@example.com" {
+		t.Fatalf("Unexpected environment: %v", env)
+	}
+
+	// certificates without the field and plain connections are not identified
+	for _, field := range []string{"dns", "uri", "1.2.3"} {
+		authenticator, _ := newCertAuthenticator(field)
+		if _, err := authenticator.Authenticate(r, nil); err != ErrUnauthenticated {
+			t.Fatalf("Identified a user with %s: %v", field, err)
+		}
+	}
+	if _, err := authenticator.Authenticate(httptest.NewRequest("GET", "/ws", nil), nil); err != ErrUnauthenticated {
+		t.Fatalf("Identified a user without TLS: %v", err)
+	}
+
+	for _, field := range []string{"", "subject", "1", "1.x.3", "1.-2"} {
+		if _, err := newCertAuthenticator(field); err == nil {
+			t.Fatalf("No error from newCertAuthenticator(%q)", field)
+		}
+	}
+}
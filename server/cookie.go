@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cookieCodec encrypts values stored in cookies, so that clients
+// can neither read nor forge them.
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+// newCookieCodec derives the key from secret, or uses a random key when secret is empty.
+func newCookieCodec(secret string) (*cookieCodec, error) {
+	key := make([]byte, sha256.Size)
+	if secret == "" {
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	} else {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieCodec{aead: aead}, nil
+}
+
+type cookiePayload struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e"`
+}
+
+// encode encrypts value for the cookie name, the result is invalid after expires.
+func (codec *cookieCodec) encode(name string, value interface{}, expires time.Time) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(&cookiePayload{Value: data, Expires: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, codec.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// the name is authenticated, a value can't be moved to another cookie
+	sealed := codec.aead.Seal(nonce, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decode decrypts the cookie name into value.
+func (codec *cookieCodec) decode(name string, encoded string, value interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < codec.aead.NonceSize() {
+		return errors.New("invalid cookie")
+	}
+	nonce, ciphertext := sealed[:codec.aead.NonceSize()], sealed[codec.aead.NonceSize():]
+	plain, err := codec.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return errors.New("invalid cookie")
+	}
+
+	var payload cookiePayload
+	if err := json.Unmarshal(plain, &payload); err != nil {
+		return err
+	}
+	if time.Now().Unix() > payload.Expires {
+		return errors.New("expired cookie")
+	}
+	return json.Unmarshal(payload.Value, value)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -14,44 +15,47 @@ import (
 	"strings"
 )
 
-const (
-	maxUploadSize  = 100 * 1024 * 1024 // 100 MB
-	chunkSize      = 5 * 1024 * 1024   // 5 MB per chunk
-	uploadPath     = "./uploads"
-	tempUploadPath = "./uploads/.temp"
-)
-
 // handleFileUpload handles file upload requests (supports batch and folder uploads)
 func (server *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !server.authorize(w, r, CapUpload) {
+		return
+	}
+	root := server.requestFileRoot(w, r, true)
+	if root == nil {
+		return
+	}
+	if err := root.checkQuota(r.ContentLength); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 
 	// Parse multipart form
-	err := r.ParseMultipartForm(maxUploadSize)
+	err := r.ParseMultipartForm(multipartMemory)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not parse multipart form: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	// Get upload path from form
-	targetPath := r.FormValue("path")
-	if targetPath == "" {
-		targetPath = "."
-	}
-
-	// Sanitize path
-	targetPath = filepath.Clean(targetPath)
-	if strings.HasPrefix(targetPath, "..") {
+	targetPath, err := root.cleanPath(r.FormValue("path"))
+	if err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
 
-	fullTargetPath := filepath.Join(uploadPath, targetPath)
+	dir, err := root.open()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open root: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer dir.Close()
 
 	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(fullTargetPath, 0755); err != nil {
+	if err := dir.MkdirAll(targetPath, 0755); err != nil {
 		http.Error(w, fmt.Sprintf("Could not create upload directory: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -87,89 +91,120 @@ func (server *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
 		Size     int64  `json:"size"`
 		Path     string `json:"path"`
 	}
+	// UploadError is a file that wasn't saved
+	type UploadError struct {
+		Filename string `json:"filename"`
+		Error    string `json:"error"`
+	}
 
 	results := []UploadResult{}
+	failures := []UploadError{}
+	tooLarge := false
+	fail := func(filename string, err error) {
+		fileErr := fileErrorOf(err)
+		if fileErr.status == http.StatusRequestEntityTooLarge {
+			tooLarge = true
+		}
+		failures = append(failures, UploadError{Filename: filename, Error: fileErr.message})
+	}
+
+	// the quota is enforced while writing, the size of the request is unknown without Content-Length
+	left, err := root.quotaLeft()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	for i, handler := range files {
+		if root.maxUploadSize > 0 && handler.Size > root.maxUploadSize {
+			log.Printf("File %s exceeds the maximum upload size of root %s", handler.Filename, root.name)
+			fail(handler.Filename, errUploadTooLarge)
+			continue
+		}
 		file, err := handler.Open()
 		if err != nil {
 			log.Printf("Error opening file %s: %v", handler.Filename, err)
+			fail(handler.Filename, err)
 			continue
 		}
 
 		// Get relative path from filePaths array (for folder uploads)
 		relativePath := filePaths[i]
-		log.Printf("DEBUG: Processing file %d: handler.Filename=%s, relativePath=%s", i, handler.Filename, relativePath)
 
 		// Clean and validate the path
-		relativePath = filepath.Clean(relativePath)
-		if strings.HasPrefix(relativePath, "..") {
+		relativePath, err = cleanPath(relativePath)
+		if err != nil || relativePath == "." {
 			file.Close()
+			fail(filePaths[i], errInvalidPath)
 			continue
 		}
 
 		// Combine target path with relative path
-		filePath := filepath.Join(fullTargetPath, relativePath)
+		filePath := filepath.Join(targetPath, relativePath)
+		if root.isHidden(filePath) {
+			file.Close()
+			fail(filePaths[i], errInvalidPath)
+			continue
+		}
 
 		// Create parent directories if needed
-		fileDir := filepath.Dir(filePath)
-		if err := os.MkdirAll(fileDir, 0755); err != nil {
+		if err := dir.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 			log.Printf("Could not create directory for file %s: %v", relativePath, err)
 			file.Close()
+			fail(filePaths[i], err)
 			continue
 		}
 
-		// Check if file exists and create a unique name if necessary
-		if _, err := os.Stat(filePath); err == nil {
-			ext := filepath.Ext(relativePath)
-			name := strings.TrimSuffix(filepath.Base(relativePath), ext)
-			dir := filepath.Dir(filePath)
-			for i := 1; ; i++ {
-				filePath = filepath.Join(dir, fmt.Sprintf("%s_%d%s", name, i, ext))
-				if _, err := os.Stat(filePath); os.IsNotExist(err) {
-					break
-				}
-			}
-		}
-
-		// Create the destination file
-		dst, err := os.Create(filePath)
+		// Create the destination file, with a unique name if the file exists
+		dst, filePath, err := createUnique(dir, filePath)
 		if err != nil {
 			log.Printf("Could not create file %s: %v", filePath, err)
 			file.Close()
+			fail(filePaths[i], err)
 			continue
 		}
 
 		// Copy the uploaded file to the destination file
-		size, err := io.Copy(dst, file)
+		size, err := io.Copy(dst, &uploadReader{Reader: file, limit: root.fileLimit(left)})
 		dst.Close()
 		file.Close()
 
 		if err != nil {
 			log.Printf("Could not save file %s: %v", filePath, err)
-			os.Remove(filePath)
+			dir.Remove(filePath)
+			fail(filePaths[i], err)
 			continue
 		}
-
-		// Get relative path from uploadPath
-		relPath, _ := filepath.Rel(uploadPath, filePath)
+		if left >= 0 {
+			left -= size
+		}
 
 		results = append(results, UploadResult{
 			Filename: filepath.Base(filePath),
 			Size:     size,
-			Path:     relPath,
+			Path:     filepath.ToSlash(filePath),
 		})
 
 		log.Printf("File uploaded successfully: %s (size: %d bytes)", filePath, size)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
+	// files too large fail the upload, the saved ones are still listed
+	response := map[string]interface{}{
+		"success": len(failures) == 0,
 		"files":   results,
 		"count":   len(results),
-	})
+	}
+	if len(failures) > 0 {
+		response["errors"] = failures
+		response["error"] = fmt.Sprintf("%d of %d files not saved", len(failures), len(files))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if tooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 // handleChunkUpload handles chunked file upload requests
@@ -178,8 +213,17 @@ func (server *Server) handleChunkUpload(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !server.authorize(w, r, CapUpload) {
+		return
+	}
+	root := server.requestFileRoot(w, r, true)
+	if root == nil {
+		return
+	}
 
-	// Parse multipart form
+	// Parse multipart form, a chunk comes with a few form fields
+	chunkSize := int64(server.options.UploadChunkSize) * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, chunkSize+multipartMemory)
 	err := r.ParseMultipartForm(chunkSize)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not parse multipart form: %v", err), http.StatusBadRequest)
@@ -198,27 +242,46 @@ func (server *Server) handleChunkUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if targetPath == "" {
-		targetPath = "."
-	}
-
 	// Sanitize paths
-	targetPath = filepath.Clean(targetPath)
-	if strings.HasPrefix(targetPath, "..") {
+	targetPath, err = root.cleanPath(targetPath)
+	if err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
 
 	// Clean filename but keep relative path for folder uploads
-	filename = filepath.Clean(filename)
-	if strings.HasPrefix(filename, "..") {
+	filename, err = cleanPath(filename)
+	if err != nil || filename == "." || root.isHidden(filepath.Join(targetPath, filename)) {
 		http.Error(w, "Invalid filename", http.StatusBadRequest)
 		return
 	}
 
+	// Parse chunk numbers
+	currentChunk, err := strconv.Atoi(chunkIndex)
+	if err != nil || currentChunk < 0 {
+		http.Error(w, "Invalid chunk index", http.StatusBadRequest)
+		return
+	}
+	total, err := strconv.Atoi(totalChunks)
+	if err != nil || total <= 0 || currentChunk >= total {
+		http.Error(w, "Invalid chunk count", http.StatusBadRequest)
+		return
+	}
+
 	// Create temp directory
-	tempDir := filepath.Join(tempUploadPath, fileId)
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+	fileId = filepath.Base(filepath.Clean("/" + fileId))
+	if !filepath.IsLocal(fileId) {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+	temp, err := root.openStaging("chunks")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open temp directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer temp.Close()
+	tempDir := fileId
+	if err := temp.MkdirAll(tempDir, 0755); err != nil {
 		http.Error(w, fmt.Sprintf("Could not create temp directory: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -231,77 +294,113 @@ func (server *Server) handleChunkUpload(w http.ResponseWriter, r *http.Request)
 	}
 	defer file.Close()
 
+	// a chunk can't be larger than the whole file
+	left, err := root.quotaLeft()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Save chunk to temp directory
-	chunkPath := filepath.Join(tempDir, chunkIndex)
-	dst, err := os.Create(chunkPath)
+	chunkPath := filepath.Join(tempDir, strconv.Itoa(currentChunk))
+	dst, err := temp.Create(chunkPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not create chunk file: %v", err), http.StatusInternalServerError)
 		return
 	}
 	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
+	if _, err := io.Copy(dst, &uploadReader{Reader: file, limit: root.fileLimit(left)}); err != nil {
+		dst.Close()
+		temp.Remove(chunkPath)
+		if err == errUploadTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Could not save chunk: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Parse chunk numbers
-	currentChunk, _ := strconv.Atoi(chunkIndex)
-	total, _ := strconv.Atoi(totalChunks)
-
 	w.Header().Set("Content-Type", "application/json")
 
 	// Check if all chunks are uploaded
 	if currentChunk == total-1 {
+		// every chunk has to be there before the file is created
+		var uploadSize int64
+		for i := 0; i < total; i++ {
+			info, err := temp.Stat(filepath.Join(tempDir, strconv.Itoa(i)))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Missing chunk %d", i), http.StatusBadRequest)
+				return
+			}
+			uploadSize += info.Size()
+		}
+		if root.maxUploadSize > 0 && uploadSize > root.maxUploadSize {
+			temp.RemoveAll(tempDir)
+			http.Error(w, "File exceeds the maximum upload size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := root.checkQuota(uploadSize); err != nil {
+			temp.RemoveAll(tempDir)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
 		// Merge chunks
-		fullTargetPath := filepath.Join(uploadPath, targetPath)
-		if err := os.MkdirAll(fullTargetPath, 0755); err != nil {
-			http.Error(w, fmt.Sprintf("Could not create target directory: %v", err), http.StatusInternalServerError)
+		dir, err := root.open()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not open root: %v", err), http.StatusInternalServerError)
 			return
 		}
+		defer dir.Close()
 
-		finalPath := filepath.Join(fullTargetPath, filename)
+		finalPath := filepath.Join(targetPath, filename)
 
 		// Create parent directories for the file if needed (for folder uploads)
-		fileDir := filepath.Dir(finalPath)
-		if err := os.MkdirAll(fileDir, 0755); err != nil {
+		if err := dir.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
 			http.Error(w, fmt.Sprintf("Could not create directory for file: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		// Check if file exists and create unique name
-		if _, err := os.Stat(finalPath); err == nil {
-			ext := filepath.Ext(finalPath)
-			baseNameWithoutExt := strings.TrimSuffix(filepath.Base(finalPath), ext)
-			dir := filepath.Dir(finalPath)
-			for i := 1; ; i++ {
-				finalPath = filepath.Join(dir, fmt.Sprintf("%s_%d%s", baseNameWithoutExt, i, ext))
-				if _, err := os.Stat(finalPath); os.IsNotExist(err) {
-					break
-				}
-			}
-		}
-
-		finalFile, err := os.Create(finalPath)
+		// Create the final file, with a unique name if the file exists
+		finalFile, finalPath, err := createUnique(dir, finalPath)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Could not create final file: %v", err), http.StatusInternalServerError)
 			return
 		}
 		defer finalFile.Close()
 
-		// Merge all chunks in order
+		// Merge all chunks in order, other uploads may have used the quota meanwhile
+		left, err := root.quotaLeft()
+		if err != nil {
+			finalFile.Close()
+			dir.Remove(finalPath)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		merged := &uploadReader{limit: root.fileLimit(left)}
 		var totalSize int64
 		for i := 0; i < total; i++ {
 			chunkPath := filepath.Join(tempDir, strconv.Itoa(i))
-			chunkFile, err := os.Open(chunkPath)
+			chunkFile, err := temp.Open(chunkPath)
 			if err != nil {
+				finalFile.Close()
+				dir.Remove(finalPath)
 				http.Error(w, fmt.Sprintf("Could not open chunk %d: %v", i, err), http.StatusInternalServerError)
 				return
 			}
 
-			size, err := io.Copy(finalFile, chunkFile)
+			merged.Reader = chunkFile
+			size, err := io.Copy(finalFile, merged)
 			chunkFile.Close()
 			if err != nil {
+				finalFile.Close()
+				dir.Remove(finalPath)
+				if err == errUploadTooLarge {
+					temp.RemoveAll(tempDir)
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
 				http.Error(w, fmt.Sprintf("Could not merge chunk %d: %v", i, err), http.StatusInternalServerError)
 				return
 			}
@@ -309,7 +408,7 @@ func (server *Server) handleChunkUpload(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Clean up temp directory
-		os.RemoveAll(tempDir)
+		temp.RemoveAll(tempDir)
 
 		log.Printf("File uploaded successfully (chunked): %s (size: %d bytes)", finalPath, totalSize)
 
@@ -337,6 +436,13 @@ func (server *Server) handleFileDownload(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !server.authorize(w, r, CapDownload) {
+		return
+	}
+	root := server.requestFileRoot(w, r, false)
+	if root == nil {
+		return
+	}
 
 	// Get filename from query parameter
 	filename := r.URL.Query().Get("file")
@@ -346,32 +452,47 @@ func (server *Server) handleFileDownload(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Sanitize filename to prevent directory traversal attacks
-	filename = filepath.Clean(filename)
-	if strings.HasPrefix(filename, "..") {
+	filename, err := root.cleanPath(filename)
+	if err != nil {
 		http.Error(w, "Invalid filename", http.StatusBadRequest)
 		return
 	}
+	// a share link grants access to its file only
+	if identity := identityFromRequest(r); identity != nil && identity.Share != nil &&
+		(identity.Share.File != filename || server.fileRootByName(identity.Share.Root) != root) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
-	filePath := filepath.Join(uploadPath, filename)
+	dir, err := root.open()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open root: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer dir.Close()
 
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
+	// Open the file
+	file, err := dir.Open(filename)
 	if os.IsNotExist(err) {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error accessing file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Could not open file: %v", err), http.StatusForbidden)
 		return
 	}
+	defer file.Close()
 
-	// Open the file
-	file, err := os.Open(filePath)
+	// Check that it is a file
+	fileInfo, err := file.Stat()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Could not open file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error accessing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !fileInfo.Mode().IsRegular() {
+		http.Error(w, "Not a file", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
 	// Detect content type
 	contentType := mime.TypeByExtension(filepath.Ext(filename))
@@ -433,6 +554,13 @@ func (server *Server) handleBatchDownload(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !server.authorize(w, r, CapDownload) {
+		return
+	}
+	root := server.requestFileRoot(w, r, false)
+	if root == nil {
+		return
+	}
 
 	var request struct {
 		Files []string `json:"files"`
@@ -451,11 +579,11 @@ func (server *Server) handleBatchDownload(w http.ResponseWriter, r *http.Request
 	// Sanitize file paths
 	var validFiles []string
 	for _, file := range request.Files {
-		cleanPath := filepath.Clean(file)
-		if strings.HasPrefix(cleanPath, "..") {
+		name, err := root.cleanPath(file)
+		if err != nil {
 			continue
 		}
-		validFiles = append(validFiles, cleanPath)
+		validFiles = append(validFiles, filepath.ToSlash(name))
 	}
 
 	if len(validFiles) == 0 {
@@ -463,6 +591,14 @@ func (server *Server) handleBatchDownload(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	dir, err := root.open()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open root: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer dir.Close()
+	fsys := dir.FS()
+
 	// Set headers for zip download
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", "attachment; filename=\"files.zip\"")
@@ -473,10 +609,8 @@ func (server *Server) handleBatchDownload(w http.ResponseWriter, r *http.Request
 
 	// Add files to zip
 	for _, file := range validFiles {
-		fullPath := filepath.Join(uploadPath, file)
-
 		// Check if path exists
-		fileInfo, err := os.Stat(fullPath)
+		fileInfo, err := fs.Stat(fsys, file)
 		if err != nil {
 			log.Printf("Skipping file %s: %v", file, err)
 			continue
@@ -484,91 +618,94 @@ func (server *Server) handleBatchDownload(w http.ResponseWriter, r *http.Request
 
 		if fileInfo.IsDir() {
 			// Add directory recursively
-			filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
+			fs.WalkDir(fsys, file, func(path string, entry fs.DirEntry, err error) error {
 				if err != nil {
 					return err
 				}
-
-				// Get relative path
-				relPath, err := filepath.Rel(uploadPath, path)
-				if err != nil {
-					return err
+				if path == "." {
+					return nil
+				}
+				if root.isHidden(filepath.FromSlash(path)) {
+					return fs.SkipDir
 				}
 
-				if info.IsDir() {
+				if entry.IsDir() {
 					// Create directory entry
-					_, err := zipWriter.Create(relPath + "/")
+					_, err := zipWriter.Create(path + "/")
 					return err
 				}
 
-				// Add file to zip
-				zipFile, err := zipWriter.Create(relPath)
-				if err != nil {
-					return err
+				if err := addZipFile(zipWriter, fsys, path); err != nil {
+					log.Printf("Skipping file %s: %v", path, err)
 				}
-
-				srcFile, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer srcFile.Close()
-
-				_, err = io.Copy(zipFile, srcFile)
-				return err
+				return nil
 			})
-		} else {
-			// Add single file to zip
-			zipFile, err := zipWriter.Create(file)
-			if err != nil {
-				log.Printf("Error creating zip entry for %s: %v", file, err)
-				continue
-			}
-
-			srcFile, err := os.Open(fullPath)
-			if err != nil {
-				log.Printf("Error opening file %s: %v", file, err)
-				continue
-			}
-
-			io.Copy(zipFile, srcFile)
-			srcFile.Close()
+		} else if err := addZipFile(zipWriter, fsys, file); err != nil {
+			log.Printf("Skipping file %s: %v", file, err)
 		}
 	}
 
 	log.Printf("Batch download completed: %d files", len(validFiles))
 }
 
+// addZipFile adds the regular file name of fsys to zipWriter.
+func addZipFile(zipWriter *zip.Writer, fsys fs.FS, name string) error {
+	srcFile, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file")
+	}
+
+	zipFile, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zipFile, srcFile)
+	return err
+}
+
 // handleFileList lists all available files and folders
 func (server *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	// Get path from query parameter (relative to uploadPath)
-	subPath := r.URL.Query().Get("path")
-	if subPath == "" {
-		subPath = "."
+	if !server.authorize(w, r, CapDownload, CapUpload, CapDelete) {
+		return
+	}
+	root := server.requestFileRoot(w, r, false)
+	if root == nil {
+		return
 	}
 
-	// Sanitize path to prevent directory traversal
-	subPath = filepath.Clean(subPath)
-	if strings.HasPrefix(subPath, "..") {
+	// Get path from query parameter (relative to the root),
+	// sanitized to prevent directory traversal
+	subPath, err := root.cleanPath(r.URL.Query().Get("path"))
+	if err != nil {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
 
-	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(uploadPath, 0755); err != nil {
+	// The root directory is created if it doesn't exist
+	dir, err := root.open()
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not access upload directory: %v", err), http.StatusInternalServerError)
 		return
 	}
-
-	// Build full path
-	fullPath := filepath.Join(uploadPath, subPath)
+	defer dir.Close()
+	fsys := dir.FS()
+	subPath = filepath.ToSlash(subPath)
 
 	// Check if path exists and is a directory
-	fileInfo, err := os.Stat(fullPath)
+	fileInfo, err := fs.Stat(fsys, subPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not access path: %v", err), http.StatusInternalServerError)
 		return
@@ -579,7 +716,7 @@ func (server *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Read directory contents
-	entries, err := os.ReadDir(fullPath)
+	entries, err := fs.ReadDir(fsys, subPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not read directory: %v", err), http.StatusInternalServerError)
 		return
@@ -589,7 +726,7 @@ func (server *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
 	var files []map[string]interface{}
 	for _, entry := range entries {
 		info, err := entry.Info()
-		if err != nil {
+		if err != nil || root.isHidden(filepath.Join(filepath.FromSlash(subPath), entry.Name())) {
 			continue
 		}
 
@@ -632,6 +769,13 @@ func (server *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !server.authorize(w, r, CapDelete) {
+		return
+	}
+	root := server.requestFileRoot(w, r, true)
+	if root == nil {
+		return
+	}
 
 	// Get filename from query parameter
 	filename := r.URL.Query().Get("file")
@@ -640,17 +784,24 @@ func (server *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Sanitize filename to prevent directory traversal attacks
-	filename = filepath.Clean(filename)
-	if strings.HasPrefix(filename, "..") {
-		http.Error(w, "Invalid filename", http.StatusBadRequest)
+	// Sanitize filename to prevent directory traversal attacks,
+	// the root itself and the temporary files can't be deleted
+	filename, err := root.cleanEntryPath(filename)
+	if err != nil {
+		fileErr := fileErrorOf(err)
+		http.Error(w, fileErr.message, fileErr.status)
 		return
 	}
 
-	filePath := filepath.Join(uploadPath, filename)
+	dir, err := root.open()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open root: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer dir.Close()
 
-	// Check if file/folder exists
-	fileInfo, err := os.Stat(filePath)
+	// Check if file/folder exists, symbolic links are deleted, not their target
+	fileInfo, err := dir.Lstat(filename)
 	if os.IsNotExist(err) {
 		http.Error(w, "File or folder not found", http.StatusNotFound)
 		return
@@ -662,13 +813,13 @@ func (server *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
 
 	// Delete the file or folder (recursively if it's a directory)
 	if fileInfo.IsDir() {
-		if err := os.RemoveAll(filePath); err != nil {
+		if err := dir.RemoveAll(filename); err != nil {
 			http.Error(w, fmt.Sprintf("Could not delete folder: %v", err), http.StatusInternalServerError)
 			return
 		}
 		log.Printf("Folder deleted: %s", filename)
 	} else {
-		if err := os.Remove(filePath); err != nil {
+		if err := dir.Remove(filename); err != nil {
 			http.Error(w, fmt.Sprintf("Could not delete file: %v", err), http.StatusInternalServerError)
 			return
 		}
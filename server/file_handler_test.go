@@ -0,0 +1,289 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	valid := map[string]string{
+		"":                 ".",
+		".":                ".",
+		"a/b":              filepath.Join("a", "b"),
+		"a/../b":           "b",
+		"./a//b/":          filepath.Join("a", "b"),
+		"..data":           "..data",
+		"a/b/../../c/..":   ".",
+		"dir/..hidden/../": "dir",
+	}
+	for name, expected := range valid {
+		cleaned, err := cleanPath(name)
+		if err != nil || cleaned != expected {
+			t.Fatalf("Unexpected result of cleanPath(%q): %q, %v", name, cleaned, err)
+		}
+	}
+	for _, name := range []string{"..", "../a", "a/../../b", "/etc/passwd", "/", "a/../..", "a\x00b"} {
+		if _, err := cleanPath(name); err == nil {
+			t.Fatalf("No error from cleanPath(%q)", name)
+		}
+	}
+}
+
+// newTraversalServer returns a server with the root dir/data, next to dir/outside
+// holding secret.txt, and symbolic links in the root pointing in and out of it.
+func newTraversalServer(t *testing.T) (*Server, string) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	outside := filepath.Join(dir, "outside")
+	os.MkdirAll(filepath.Join(data, "sub"), 0755)
+	os.MkdirAll(outside, 0755)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	os.WriteFile(filepath.Join(data, "sub", "public.txt"), []byte("public"), 0644)
+
+	links := map[string]string{
+		"escape.txt": filepath.Join("..", "outside", "secret.txt"),
+		"absolute":   filepath.Join(outside, "secret.txt"),
+		"escdir":     filepath.Join("..", "outside"),
+		"inner.txt":  filepath.Join("sub", "public.txt"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(data, name)); err != nil {
+			t.Skipf("Symbolic links not supported: %s", err)
+		}
+	}
+
+	options := &Options{UploadChunkSize: 1, FileRoots: map[string]*FileRoot{"data": {Path: data}}}
+	roots, err := newFileRoots(options)
+	if err != nil {
+		t.Fatalf("Unexpected error from newFileRoots(): %s", err)
+	}
+	return &Server{options: options, fileRoots: roots}, dir
+}
+
+// assertOutsideIntact fails when the directory outside the root was changed.
+func assertOutsideIntact(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, "outside"))
+	if err != nil || len(entries) != 1 || entries[0].Name() != "secret.txt" {
+		t.Fatalf("Directory outside the root changed: %v %v", entries, err)
+	}
+}
+
+func TestFileTraversalDownload(t *testing.T) {
+	server, dir := newTraversalServer(t)
+	download := func(file string) (int, string) {
+		w := httptest.NewRecorder()
+		server.handleFileDownload(w, httptest.NewRequest("GET", "/api/download?file="+file, nil))
+		return w.Code, w.Body.String()
+	}
+
+	for _, file := range []string{
+		"../outside/secret.txt",
+		"sub/../../outside/secret.txt",
+		"%2F" + strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "outside", "secret.txt")), "/"),
+		"escape.txt",
+		"absolute",
+		"escdir/secret.txt",
+	} {
+		if code, body := download(file); code == 200 || body == "secret" {
+			t.Fatalf("Downloaded %s from outside the root: %d %s", file, code, body)
+		}
+	}
+	if code, body := download("inner.txt"); code != 200 || body != "public" {
+		t.Fatalf("Unexpected download of a link inside the root: %d %s", code, body)
+	}
+}
+
+func TestFileTraversalList(t *testing.T) {
+	server, _ := newTraversalServer(t)
+	for _, path := range []string{"..", "../outside", "escdir", "/tmp", "sub/../.."} {
+		w := httptest.NewRecorder()
+		server.handleFileList(w, httptest.NewRequest("GET", "/api/files?path="+path, nil))
+		if w.Code == 200 {
+			t.Fatalf("Listed %s outside the root: %s", path, w.Body)
+		}
+	}
+}
+
+func TestFileTraversalUpload(t *testing.T) {
+	server, dir := newTraversalServer(t)
+	upload := func(path string, filePaths []string) int {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		form.WriteField("path", path)
+		if filePaths != nil {
+			data, _ := json.Marshal(filePaths)
+			form.WriteField("filePaths", string(data))
+		}
+		part, _ := form.CreateFormFile("files", "evil.txt")
+		part.Write([]byte("evil"))
+		form.Close()
+		r := httptest.NewRequest("POST", "/api/upload", body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.handleFileUpload(w, r)
+		return w.Code
+	}
+
+	for _, path := range []string{"..", "../outside", "/tmp"} {
+		if code := upload(path, nil); code != 400 {
+			t.Fatalf("Unexpected status uploading to %s: %d", path, code)
+		}
+	}
+	upload("escdir", nil)
+	upload(".", []string{"../outside/evil.txt"})
+	upload(".", []string{"escdir/evil.txt"})
+	upload("sub", []string{"../../outside/evil.txt"})
+	// writing through a link to a file outside the root doesn't replace the file
+	upload(".", []string{"escape.txt"})
+	assertOutsideIntact(t, dir)
+	if data, _ := os.ReadFile(filepath.Join(dir, "outside", "secret.txt")); string(data) != "secret" {
+		t.Fatalf("File outside the root overwritten: %s", data)
+	}
+}
+
+func TestFileTraversalChunkUpload(t *testing.T) {
+	server, dir := newTraversalServer(t)
+	chunk := func(fields map[string]string) int {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		for key, value := range fields {
+			form.WriteField(key, value)
+		}
+		part, _ := form.CreateFormFile("chunk", "blob")
+		part.Write([]byte("evil"))
+		form.Close()
+		r := httptest.NewRequest("POST", "/api/upload-chunk", body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.handleChunkUpload(w, r)
+		return w.Code
+	}
+
+	for _, fields := range []map[string]string{
+		{"chunkIndex": "0", "totalChunks": "1", "fileId": "a", "filename": "../outside/evil.txt"},
+		{"chunkIndex": "0", "totalChunks": "1", "fileId": "a", "filename": "/tmp/evil.txt"},
+		{"chunkIndex": "0", "totalChunks": "1", "fileId": "a", "filename": "evil.txt", "path": "../outside"},
+		{"chunkIndex": "../../../outside/evil.txt", "totalChunks": "1", "fileId": "a", "filename": "evil.txt"},
+	} {
+		if code := chunk(fields); code != 400 {
+			t.Fatalf("Unexpected status of chunk %v: %d", fields, code)
+		}
+	}
+	chunk(map[string]string{"chunkIndex": "0", "totalChunks": "1", "fileId": "../../outside", "filename": "evil.txt"})
+	chunk(map[string]string{"chunkIndex": "0", "totalChunks": "1", "fileId": "b", "filename": "escdir/evil.txt"})
+	assertOutsideIntact(t, dir)
+
+	// chunks aren't staged through a linked temporary directory
+	os.RemoveAll(filepath.Join(dir, "data", ".temp"))
+	os.Symlink(filepath.Join("..", "outside"), filepath.Join(dir, "data", ".temp"))
+	if code := chunk(map[string]string{"chunkIndex": "0", "totalChunks": "2", "fileId": "c", "filename": "evil.txt"}); code != 500 {
+		t.Fatalf("Unexpected status of a chunk staged through a link: %d", code)
+	}
+	assertOutsideIntact(t, dir)
+}
+
+func TestFileTraversalDelete(t *testing.T) {
+	server, dir := newTraversalServer(t)
+	remove := func(file string) int {
+		w := httptest.NewRecorder()
+		server.handleFileDelete(w, httptest.NewRequest("DELETE", "/api/delete?file="+file, nil))
+		return w.Code
+	}
+
+	for _, file := range []string{".", "..", "../outside", "../outside/secret.txt", "/tmp", "escdir/secret.txt"} {
+		if code := remove(file); code == 200 {
+			t.Fatalf("Deleted %s outside the root", file)
+		}
+	}
+	// links are deleted, not their targets
+	for _, file := range []string{"escdir", "escape.txt", "absolute"} {
+		if code := remove(file); code != 200 {
+			t.Fatalf("Unexpected status deleting the link %s: %d", file, code)
+		}
+	}
+	assertOutsideIntact(t, dir)
+}
+
+func TestFileTraversalBatchDownload(t *testing.T) {
+	server, _ := newTraversalServer(t)
+	body, _ := json.Marshal(map[string][]string{
+		"files": {".", "../outside", "../outside/secret.txt", "/etc/passwd", "escape.txt", "absolute", "escdir", "inner.txt"},
+	})
+	w := httptest.NewRecorder()
+	server.handleBatchDownload(w, httptest.NewRequest("POST", "/api/batch-download", bytes.NewReader(body)))
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Unexpected error reading the archive: %s", err)
+	}
+	names := []string{}
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		if strings.Contains(file.Name, "secret") || strings.Contains(file.Name, "..") {
+			t.Fatalf("Archive contains %s", file.Name)
+		}
+		reader, _ := file.Open()
+		data := new(bytes.Buffer)
+		data.ReadFrom(reader)
+		reader.Close()
+		if data.String() == "secret" {
+			t.Fatalf("Archive contains the file outside the root as %s", file.Name)
+		}
+	}
+	joined := strings.Join(names, ",")
+	if !strings.Contains(joined, "sub/public.txt") || !strings.Contains(joined, "inner.txt") {
+		t.Fatalf("Files inside the root missing from the archive: %v", names)
+	}
+}
+
+func TestChunkUploadCount(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	chunk := func(index, total, content string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		form.WriteField("chunkIndex", index)
+		form.WriteField("totalChunks", total)
+		form.WriteField("fileId", "count")
+		form.WriteField("filename", "count.txt")
+		part, _ := form.CreateFormFile("chunk", "blob")
+		part.Write([]byte(content))
+		form.Close()
+		r := httptest.NewRequest("POST", "/api/upload-chunk", body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.handleChunkUpload(w, r)
+		return w
+	}
+
+	for _, counts := range [][2]string{{"0", "x"}, {"0", "0"}, {"0", "-1"}, {"2", "2"}, {"3", "2"}} {
+		if w := chunk(counts[0], counts[1], "a"); w.Code != 400 {
+			t.Fatalf("Unexpected status of chunk %s of %s: %d", counts[0], counts[1], w.Code)
+		}
+	}
+
+	// the file isn't created before every chunk is there
+	if w := chunk("1", "2", "b"); w.Code != 400 || !strings.Contains(w.Body.String(), "Missing chunk 0") {
+		t.Fatalf("Unexpected response to the last chunk without the first: %d %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(data, "count.txt")); err == nil {
+		t.Fatalf("File created with a missing chunk")
+	}
+	if w := chunk("0", "2", "a"); w.Code != 200 || !strings.Contains(w.Body.String(), `"complete":false`) {
+		t.Fatalf("Unexpected response to the first chunk: %d %s", w.Code, w.Body)
+	}
+	if w := chunk("1", "2", "b"); w.Code != 200 || !strings.Contains(w.Body.String(), `"complete":true`) {
+		t.Fatalf("Unexpected response to the last chunk: %d %s", w.Code, w.Body)
+	}
+	if content, err := os.ReadFile(filepath.Join(data, "count.txt")); err != nil || string(content) != "ab" {
+		t.Fatalf("Unexpected merged file: %q %v", content, err)
+	}
+}
@@ -0,0 +1,442 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fileError is an error of the file operation APIs, sent as JSON with its status.
+type fileError struct {
+	status  int
+	message string
+}
+
+func newFileError(status int, format string, args ...interface{}) *fileError {
+	return &fileError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+func (err *fileError) Error() string {
+	return err.message
+}
+
+// fileErrorOf returns err as a fileError, with the status matching the cause of err.
+func fileErrorOf(err error) *fileError {
+	var fileErr *fileError
+	switch {
+	case errors.As(err, &fileErr):
+		return fileErr
+	case errors.Is(err, errInvalidPath):
+		return newFileError(http.StatusBadRequest, "Invalid path")
+	case errors.Is(err, fs.ErrNotExist):
+		return newFileError(http.StatusNotFound, "File or folder not found")
+	case errors.Is(err, fs.ErrExist):
+		return newFileError(http.StatusConflict, "File or folder exists")
+	case errors.Is(err, fs.ErrPermission):
+		return newFileError(http.StatusForbidden, "Permission denied")
+	case strings.Contains(err.Error(), "path escapes from parent"):
+		// os.Root doesn't export the error of symbolic links leaving the root
+		return newFileError(http.StatusForbidden, "Path leaves the root")
+	}
+	return newFileError(http.StatusInternalServerError, "%s", err)
+}
+
+// writeFileError responds with err as {"success": false, "error": message}.
+func writeFileError(w http.ResponseWriter, err *fileError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   err.message,
+	})
+}
+
+// fileOp is a file operation on dir, the opened root of r, returning its JSON response.
+type fileOp func(r *http.Request, root *fileRoot, dir *os.Root) (interface{}, error)
+
+// handleFileOp returns a handler running op for method requests of users with
+// one of the capabilities. writable operations are refused on read-only roots.
+// Every error, including failed authorization, is responded with writeFileError.
+func (server *Server) handleFileOp(method string, writable bool, op fileOp, required ...Capability) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeFileError(w, newFileError(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		if !server.allowed(r, required...) {
+			writeFileError(w, newFileError(http.StatusForbidden, "Forbidden"))
+			return
+		}
+		root, rootErr := server.fileRootOf(r, writable)
+		if rootErr != nil {
+			writeFileError(w, rootErr)
+			return
+		}
+		dir, err := root.open()
+		if err != nil {
+			writeFileError(w, newFileError(http.StatusInternalServerError, "Could not open root: %v", err))
+			return
+		}
+		defer dir.Close()
+
+		result, err := op(r, root, dir)
+		if err != nil {
+			writeFileError(w, fileErrorOf(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// decodeFileRequest decodes the JSON body of r into request.
+func decodeFileRequest(r *http.Request, request interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(request); err != nil {
+		return newFileError(http.StatusBadRequest, "Invalid request body")
+	}
+	return nil
+}
+
+// cleanEntryPath is root.cleanPath refusing the root itself, for paths of entries
+// in a root, and the folders holding temporary directories.
+func (root *fileRoot) cleanEntryPath(name string) (string, error) {
+	name, err := root.cleanPath(name)
+	if err != nil {
+		return "", err
+	}
+	if name == "." {
+		return "", newFileError(http.StatusBadRequest, "Invalid path")
+	}
+	if root.holdsHidden(name) {
+		return "", newFileError(http.StatusForbidden, "Folder holds temporary files")
+	}
+	return name, nil
+}
+
+// fileMoveRequest is the request of rename and copy.
+type fileMoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Overwrite replaces an existing destination, which is a conflict otherwise
+	Overwrite bool `json:"overwrite"`
+}
+
+// paths returns the cleaned source and destination of request, and whether
+// the destination exists and is overwritten, after creating its parent in dir of root.
+func (request *fileMoveRequest) paths(root *fileRoot, dir *os.Root) (string, string, bool, error) {
+	from, err := root.cleanEntryPath(request.From)
+	if err != nil {
+		return "", "", false, err
+	}
+	to, err := root.cleanEntryPath(request.To)
+	if err != nil {
+		return "", "", false, err
+	}
+	if _, err := dir.Lstat(from); err != nil {
+		return "", "", false, err
+	}
+	if to == from || strings.HasPrefix(to, from+string(filepath.Separator)) {
+		return "", "", false, newFileError(http.StatusBadRequest, "Destination is inside the source")
+	}
+	if strings.HasPrefix(from, to+string(filepath.Separator)) {
+		return "", "", false, newFileError(http.StatusBadRequest, "Source is inside the destination")
+	}
+
+	exists := false
+	if _, err := dir.Lstat(to); err == nil {
+		if !request.Overwrite {
+			return "", "", false, newFileError(http.StatusConflict, "Destination exists")
+		}
+		exists = true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", false, err
+	}
+	if err := dir.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return "", "", false, err
+	}
+	return from, to, exists, nil
+}
+
+// tempName returns an unused name next to name for staging a replacement of it.
+func tempName(name string) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(name), fmt.Sprintf(".%s.%s.tmp", filepath.Base(name), hex.EncodeToString(random))), nil
+}
+
+// replace renames src to dst in dir. An existing dst is moved aside first and
+// only removed once src is in place, it is restored if the rename fails.
+func replace(dir *os.Root, src string, dst string) error {
+	if _, err := dir.Lstat(dst); errors.Is(err, fs.ErrNotExist) {
+		return dir.Rename(src, dst)
+	}
+	backup, err := tempName(dst)
+	if err != nil {
+		return err
+	}
+	if err := dir.Rename(dst, backup); err != nil {
+		return err
+	}
+	if err := dir.Rename(src, dst); err != nil {
+		if restoreErr := dir.Rename(backup, dst); restoreErr != nil {
+			log.Printf("Could not restore %s from %s: %v", dst, backup, restoreErr)
+		}
+		return err
+	}
+	if err := dir.RemoveAll(backup); err != nil {
+		log.Printf("Could not remove the replaced %s: %v", backup, err)
+	}
+	return nil
+}
+
+// fileMkdir creates the folder path with its parents.
+func fileMkdir(r *http.Request, root *fileRoot, dir *os.Root) (interface{}, error) {
+	var request struct {
+		Path string `json:"path"`
+	}
+	if err := decodeFileRequest(r, &request); err != nil {
+		return nil, err
+	}
+	name, err := root.cleanEntryPath(request.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dir.Lstat(name); err == nil {
+		return nil, newFileError(http.StatusConflict, "File or folder exists")
+	}
+	if err := dir.MkdirAll(name, 0755); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Folder created: %s", name)
+	return map[string]interface{}{"success": true, "path": filepath.ToSlash(name)}, nil
+}
+
+// fileRename renames or moves a file or folder, which requires the delete
+// capability besides upload as the source is gone.
+func (server *Server) fileRename(r *http.Request, root *fileRoot, dir *os.Root) (interface{}, error) {
+	if !server.allowed(r, CapDelete) {
+		return nil, newFileError(http.StatusForbidden, "Forbidden")
+	}
+	var request fileMoveRequest
+	if err := decodeFileRequest(r, &request); err != nil {
+		return nil, err
+	}
+	from, to, _, err := request.paths(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := replace(dir, from, to); err != nil {
+		return nil, err
+	}
+
+	log.Printf("File renamed: %s -> %s", from, to)
+	return map[string]interface{}{"success": true, "path": filepath.ToSlash(to)}, nil
+}
+
+// fileCopy copies a file or a folder recursively. Symbolic links and special
+// files in copied folders are skipped. Overwriting the destination deletes it
+// and requires the delete capability, the copy is made under a temporary name
+// and replaces the destination only once it is complete.
+func (server *Server) fileCopy(r *http.Request, root *fileRoot, dir *os.Root) (interface{}, error) {
+	var request fileMoveRequest
+	if err := decodeFileRequest(r, &request); err != nil {
+		return nil, err
+	}
+	from, err := root.cleanEntryPath(request.From)
+	if err != nil {
+		return nil, err
+	}
+	fsys := dir.FS()
+	size, err := treeSize(fsys, filepath.ToSlash(from))
+	if err != nil {
+		return nil, err
+	}
+	if err := root.checkQuota(size); err != nil {
+		return nil, newFileError(http.StatusRequestEntityTooLarge, "%s", err)
+	}
+	from, to, exists, err := request.paths(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	if exists && !server.allowed(r, CapDelete) {
+		return nil, newFileError(http.StatusForbidden, "Forbidden")
+	}
+	tmp, err := tempName(to)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.WalkDir(fsys, filepath.ToSlash(from), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(from, filepath.FromSlash(path))
+		target := filepath.Join(tmp, rel)
+		if entry.IsDir() {
+			return dir.Mkdir(target, info.Mode().Perm()|0700)
+		}
+		return copyFile(dir, path, target, info.Mode().Perm())
+	})
+	if err == nil {
+		err = replace(dir, tmp, to)
+	}
+	if err != nil {
+		dir.RemoveAll(tmp)
+		return nil, err
+	}
+
+	log.Printf("File copied: %s -> %s", from, to)
+	return map[string]interface{}{"success": true, "path": filepath.ToSlash(to)}, nil
+}
+
+// treeSize returns the size of the regular files in the file or folder name of fsys.
+func treeSize(fsys fs.FS, name string) (int64, error) {
+	var size int64
+	err := fs.WalkDir(fsys, name, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// copyFile copies the file src of dir to the new file dst.
+func copyFile(dir *os.Root, src string, dst string, perm os.FileMode) error {
+	srcFile, err := dir.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := dir.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
+}
+
+// fileChmod sets the permission bits of a file or folder, given in octal like "0644".
+func fileChmod(r *http.Request, root *fileRoot, dir *os.Root) (interface{}, error) {
+	var request struct {
+		Path string `json:"path"`
+		Mode string `json:"mode"`
+	}
+	if err := decodeFileRequest(r, &request); err != nil {
+		return nil, err
+	}
+	name, err := root.cleanEntryPath(request.Path)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := strconv.ParseUint(request.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, newFileError(http.StatusBadRequest, "Invalid mode, use octal permission bits like 0644")
+	}
+	if err := dir.Chmod(name, os.FileMode(mode)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("File mode changed: %s (%04o)", name, mode)
+	return map[string]interface{}{"success": true, "mode": fmt.Sprintf("%04o", mode)}, nil
+}
+
+// fileStat describes a file or folder. The MIME type of files is sniffed from
+// their content, or taken from their extension when the content is plain text
+// or unknown.
+func fileStat(r *http.Request, root *fileRoot, dir *os.Root) (interface{}, error) {
+	name, err := root.cleanPath(r.URL.Query().Get("path"))
+	if err != nil {
+		return nil, err
+	}
+	linkInfo, err := dir.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := dir.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, group := fileOwner(info)
+	stat := map[string]interface{}{
+		"name":        info.Name(),
+		"path":        filepath.ToSlash(name),
+		"isDir":       info.IsDir(),
+		"isSymlink":   linkInfo.Mode()&fs.ModeSymlink != 0,
+		"size":        info.Size(),
+		"mode":        fmt.Sprintf("%04o", info.Mode().Perm()),
+		"permissions": info.Mode().String(),
+		"time":        info.ModTime().Unix(),
+		"owner":       owner,
+		"group":       group,
+	}
+	if name == "." {
+		stat["name"] = root.name
+	}
+	if info.IsDir() {
+		stat["mimeType"] = "inode/directory"
+	} else if info.Mode().IsRegular() {
+		mimeType, err := sniffMIMEType(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		stat["mimeType"] = mimeType
+	}
+	return stat, nil
+}
+
+// sniffMIMEType returns the MIME type of the file name of dir.
+func sniffMIMEType(dir *os.Root, name string) (string, error) {
+	file, err := dir.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	mimeType := http.DetectContentType(head[:n])
+	if strings.HasPrefix(mimeType, "text/plain") || mimeType == "application/octet-stream" {
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+			return byExtension, nil
+		}
+	}
+	return mimeType, nil
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func newFileOpsServer(t *testing.T) (*Server, string) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	os.MkdirAll(filepath.Join(data, "docs", "img"), 0755)
+	os.MkdirAll(filepath.Join(dir, "logs"), 0755)
+	os.WriteFile(filepath.Join(data, "docs", "readme.md"), []byte("# Title"), 0644)
+	os.WriteFile(filepath.Join(data, "docs", "config.json"), []byte(`{"a": 1}`), 0644)
+	os.WriteFile(filepath.Join(data, "docs", "img", "dot.png"), []byte("\x89PNG\r\n\x1a\n"), 0644)
+	os.WriteFile(filepath.Join(data, "notes.txt"), []byte("notes"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+
+	options := &Options{
+		UploadChunkSize: 1,
+		FileRoots: map[string]*FileRoot{
+			"data": {Path: data},
+			"logs": {Path: filepath.Join(dir, "logs"), ReadOnly: true},
+		},
+	}
+	roots, err := newFileRoots(options)
+	if err != nil {
+		t.Fatalf("Unexpected error from newFileRoots(): %s", err)
+	}
+	return &Server{options: options, fileRoots: roots}, dir
+}
+
+// fileOpRequest runs handler with body and returns the status and the decoded response.
+func fileOpRequest(handler http.HandlerFunc, method string, url string, body string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+	response := map[string]interface{}{}
+	json.NewDecoder(w.Body).Decode(&response)
+	return w.Code, response
+}
+
+func TestFileMkdirRenameCopy(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	mkdir := server.handleFileOp("POST", true, fileMkdir, CapUpload)
+	rename := server.handleFileOp("POST", true, server.fileRename, CapUpload)
+	copy := server.handleFileOp("POST", true, server.fileCopy, CapUpload)
+
+	if code, _ := fileOpRequest(mkdir, "POST", "/api/mkdir", `{"path": "a/b"}`); code != 200 {
+		t.Fatalf("Unexpected status of mkdir: %d", code)
+	}
+	if info, err := os.Stat(filepath.Join(data, "a", "b")); err != nil || !info.IsDir() {
+		t.Fatalf("Folder not created: %v", err)
+	}
+	for body, status := range map[string]int{
+		`{"path": "a/b"}`:  409,
+		`{"path": "."}`:    400,
+		`{"path": "../x"}`: 400,
+		`{"path": "/tmp"}`: 400,
+		`{"path": `:        400,
+	} {
+		code, response := fileOpRequest(mkdir, "POST", "/api/mkdir", body)
+		if code != status || response["success"] != false || response["error"] == "" {
+			t.Fatalf("Unexpected response of mkdir %s: %d %v", body, code, response)
+		}
+	}
+
+	if code, response := fileOpRequest(rename, "POST", "/api/rename", `{"from": "notes.txt", "to": "a/b/c/todo.txt"}`); code != 200 || response["path"] != "a/b/c/todo.txt" {
+		t.Fatalf("Unexpected response of rename: %d %v", code, response)
+	}
+	if data, err := os.ReadFile(filepath.Join(data, "a", "b", "c", "todo.txt")); err != nil || string(data) != "notes" {
+		t.Fatalf("File not moved: %s %v", data, err)
+	}
+	for body, status := range map[string]int{
+		`{"from": "missing", "to": "x"}`:                        404,
+		`{"from": "docs", "to": "a"}`:                           409,
+		`{"from": "docs", "to": "docs/img/docs"}`:               400,
+		`{"from": "docs/img", "to": "docs", "overwrite": true}`: 400,
+		`{"from": "docs", "to": "../docs"}`:                     400,
+	} {
+		if code, _ := fileOpRequest(rename, "POST", "/api/rename", body); code != status {
+			t.Fatalf("Unexpected status of rename %s: %d", body, code)
+		}
+	}
+
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "docs", "to": "a", "overwrite": true}`); code != 200 {
+		t.Fatalf("Unexpected status of copy: %d", code)
+	}
+	for _, name := range []string{"docs/readme.md", "a/readme.md", "a/img/dot.png"} {
+		if _, err := os.Stat(filepath.Join(data, filepath.FromSlash(name))); err != nil {
+			t.Fatalf("Missing %s after copy: %s", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(data, "a", "b")); err == nil {
+		t.Fatalf("Overwritten destination not replaced")
+	}
+	if entries, _ := os.ReadDir(data); len(entries) != 2 {
+		t.Fatalf("Temporary files left after copy: %v", entries)
+	}
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "docs/readme.md", "to": "docs/readme.md"}`); code != 400 {
+		t.Fatalf("Unexpected status copying a file onto itself: %d", code)
+	}
+
+	// writable operations are refused on read-only roots
+	if code, response := fileOpRequest(mkdir, "POST", "/api/mkdir?root=logs", `{"path": "x"}`); code != 403 || response["error"] != "Root is read-only" {
+		t.Fatalf("Unexpected response of mkdir in a read-only root: %d %v", code, response)
+	}
+}
+
+func TestFileCopyLimits(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	copy := server.handleFileOp("POST", true, server.fileCopy, CapUpload)
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(data, "docs", "secret.txt")); err != nil {
+		t.Skipf("Symbolic links not supported: %s", err)
+	}
+
+	// links in copied folders are skipped, links leaving the root can't be copied
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "docs", "to": "copy"}`); code != 200 {
+		t.Fatalf("Unexpected status of copy: %d", code)
+	}
+	if _, err := os.Lstat(filepath.Join(data, "copy", "secret.txt")); err == nil {
+		t.Fatalf("Link copied")
+	}
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "docs/secret.txt", "to": "secret.txt"}`); code != 403 {
+		t.Fatalf("Unexpected status copying a link leaving the root: %d", code)
+	}
+
+	server.fileRoots[0].quota = 20
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "docs", "to": "again"}`); code != 413 {
+		t.Fatalf("Unexpected status of copy beyond the quota: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(data, "again")); err == nil {
+		t.Fatalf("Copied beyond the quota")
+	}
+}
+
+func TestFileChmodStat(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	chmod := server.handleFileOp("POST", true, fileChmod, CapUpload)
+	stat := server.handleFileOp("GET", false, fileStat, CapDownload, CapUpload, CapDelete)
+
+	for body, status := range map[string]int{
+		`{"path": "notes.txt", "mode": "0600"}`: 200,
+		`{"path": "notes.txt", "mode": "4755"}`: 400,
+		`{"path": "notes.txt", "mode": "rw"}`:   400,
+		`{"path": "missing", "mode": "0600"}`:   404,
+	} {
+		if code, _ := fileOpRequest(chmod, "POST", "/api/chmod", body); code != status {
+			t.Fatalf("Unexpected status of chmod %s: %d", body, code)
+		}
+	}
+
+	code, response := fileOpRequest(stat, "GET", "/api/stat?path=notes.txt", "")
+	if code != 200 || response["size"] != float64(5) || response["isDir"] != false || response["mimeType"] != "text/plain; charset=utf-8" {
+		t.Fatalf("Unexpected stat of notes.txt: %d %v", code, response)
+	}
+	if runtime.GOOS != "windows" && (response["mode"] != "0600" || response["permissions"] != "-rw-------" || response["owner"] == "") {
+		t.Fatalf("Unexpected mode or owner of notes.txt: %v", response)
+	}
+	for path, mimeType := range map[string]string{
+		"docs/img/dot.png": "image/png",
+		"docs/config.json": "application/json",
+		"docs":             "inode/directory",
+	} {
+		if _, response := fileOpRequest(stat, "GET", "/api/stat?path="+path, ""); response["mimeType"] != mimeType {
+			t.Fatalf("Unexpected MIME type of %s: %v", path, response["mimeType"])
+		}
+	}
+	if code, response := fileOpRequest(stat, "GET", "/api/stat", ""); code != 200 || response["name"] != "data" || response["isDir"] != true {
+		t.Fatalf("Unexpected stat of the root: %d %v", code, response)
+	}
+
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(dir, "data", "secret.txt")); err != nil {
+		t.Skipf("Symbolic links not supported: %s", err)
+	}
+	if code, _ := fileOpRequest(stat, "GET", "/api/stat?path=secret.txt", ""); code != 403 {
+		t.Fatalf("Unexpected status of stat of a link leaving the root: %d", code)
+	}
+	if code, _ := fileOpRequest(stat, "GET", "/api/stat?path=../secret.txt", ""); code != 400 {
+		t.Fatalf("Unexpected status of stat outside the root: %d", code)
+	}
+}
+
+func TestFileRenameCapabilities(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	server.options.Roles = map[string]*Role{"uploader": {Capabilities: []string{"upload", "download"}}}
+	server.options.DefaultRole = "uploader"
+	rename := server.handleFileOp("POST", true, server.fileRename, CapUpload)
+	stat := server.handleFileOp("GET", false, fileStat, CapDownload, CapUpload, CapDelete)
+
+	// moving a file deletes its source
+	if code, response := fileOpRequest(rename, "POST", "/api/rename", `{"from": "notes.txt", "to": "todo.txt"}`); code != 403 || response["error"] != "Forbidden" {
+		t.Fatalf("Unexpected response of rename without the delete capability: %d %v", code, response)
+	}
+	// overwriting a copy destination deletes it
+	copy := server.handleFileOp("POST", true, server.fileCopy, CapUpload)
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "notes.txt", "to": "docs/readme.md", "overwrite": true}`); code != 403 {
+		t.Fatalf("Unexpected status of copy overwriting without the delete capability: %d", code)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "data", "docs", "readme.md")); err != nil || string(data) != "# Title" {
+		t.Fatalf("Destination changed by a refused copy: %s %v", data, err)
+	}
+	if code, _ := fileOpRequest(copy, "POST", "/api/copy", `{"from": "notes.txt", "to": "docs/notes.txt"}`); code != 200 {
+		t.Fatalf("Unexpected status of copy without the delete capability: %d", code)
+	}
+	if code, _ := fileOpRequest(stat, "POST", "/api/stat", ""); code != 405 {
+		t.Fatalf("Unexpected status of stat with POST: %d", code)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	_, dir := newFileOpsServer(t)
+	root, err := os.OpenRoot(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("Unexpected error from OpenRoot(): %s", err)
+	}
+	defer root.Close()
+
+	// the destination is restored when the replacement fails
+	if err := replace(root, "missing", "docs"); err == nil {
+		t.Fatalf("Replaced with a missing file")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data", "docs", "readme.md")); err != nil {
+		t.Fatalf("Destination lost after a failed replacement: %s", err)
+	}
+	if err := replace(root, "notes.txt", "docs"); err != nil {
+		t.Fatalf("Unexpected error from replace(): %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "data", "docs")); err != nil || string(data) != "notes" {
+		t.Fatalf("Unexpected destination after replacement: %s %v", data, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "data")); len(entries) != 1 {
+		t.Fatalf("Backup left after replacement: %v", entries)
+	}
+}
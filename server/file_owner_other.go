@@ -0,0 +1,12 @@
+//go:build !unix
+
+package server
+
+import (
+	"io/fs"
+)
+
+// fileOwner returns no owner, files have no user and group ids on this platform.
+func fileOwner(info fs.FileInfo) (string, string) {
+	return "", ""
+}
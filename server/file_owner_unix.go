@@ -0,0 +1,28 @@
+//go:build unix
+
+package server
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the names of the user and group owning info, their ids
+// when they have no name.
+func fileOwner(info fs.FileInfo) (string, string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	owner := strconv.FormatUint(uint64(stat.Uid), 10)
+	group := strconv.FormatUint(uint64(stat.Gid), 10)
+	if u, err := user.LookupId(owner); err == nil {
+		owner = u.Username
+	}
+	if g, err := user.LookupGroupId(group); err == nil {
+		group = g.Name
+	}
+	return owner, group
+}
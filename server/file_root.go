@@ -0,0 +1,401 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/homedir"
+)
+
+// defaultFileRootName is the name of the root set by file_root when no file_roots are configured.
+const defaultFileRootName = "default"
+
+// multipartMemory is the size of uploaded forms kept in memory, larger files are buffered on disk.
+const multipartMemory = 10 * 1024 * 1024
+
+// FileRoot is a directory the file manager serves, set in the config file.
+type FileRoot struct {
+	Path     string `hcl:"path"`
+	ReadOnly bool   `hcl:"read_only"`
+	// MaxUploadSize is the maximum size of an uploaded file in MB, 0 for max_upload_size
+	MaxUploadSize int `hcl:"max_upload_size"`
+	// Quota is the maximum size of all files in the root in MB, 0 for no limit
+	Quota int `hcl:"quota"`
+}
+
+// fileRoot is a FileRoot ready to serve.
+type fileRoot struct {
+	name     string
+	path     string
+	tempPath string
+	// hidden are the temporary directories of uploads in the root, relative to it,
+	// which the file APIs don't serve
+	hidden   []string
+	readOnly bool
+	// maxUploadSize and quota are in bytes, 0 for no limit
+	maxUploadSize int64
+	quota         int64
+}
+
+// newFileRoots returns the roots of the file manager sorted by name,
+// the first one is used by requests without a root.
+func newFileRoots(options *Options) ([]*fileRoot, error) {
+	configs := options.FileRoots
+	if len(configs) == 0 {
+		configs = map[string]*FileRoot{defaultFileRootName: {Path: options.FileRoot}}
+	}
+
+	roots := []*fileRoot{}
+	for name, config := range configs {
+		if config.Path == "" {
+			return nil, errors.Errorf("no path in file root `%s`", name)
+		}
+		path, err := filepath.Abs(homedir.Expand(config.Path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid path of file root `%s`", name)
+		}
+		root := &fileRoot{
+			name:          name,
+			path:          path,
+			tempPath:      filepath.Join(path, ".temp"),
+			readOnly:      config.ReadOnly,
+			maxUploadSize: int64(options.MaxUploadSize) * 1024 * 1024,
+			quota:         int64(config.Quota) * 1024 * 1024,
+		}
+		if options.UploadTempDir != "" {
+			tempDir, err := filepath.Abs(homedir.Expand(options.UploadTempDir))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid upload temp dir")
+			}
+			root.tempPath = filepath.Join(tempDir, name)
+		}
+		if config.MaxUploadSize > 0 {
+			root.maxUploadSize = int64(config.MaxUploadSize) * 1024 * 1024
+		}
+		roots = append(roots, root)
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].name < roots[j].name })
+
+	// temporary directories can be in any root, such as upload_temp_dir in a root at ~
+	temps := []string{}
+	if options.UploadTempDir != "" {
+		temps = append(temps, filepath.Dir(roots[0].tempPath))
+	}
+	for _, root := range roots {
+		temps = append(temps, root.tempPath)
+	}
+	for _, root := range roots {
+		for _, temp := range temps {
+			if rel, err := filepath.Rel(root.path, temp); err == nil && filepath.IsLocal(rel) {
+				root.hidden = append(root.hidden, rel)
+			}
+		}
+	}
+	return roots, nil
+}
+
+// requestFileRoot returns the root named by the root parameter of r, the first root without it.
+// It responds with an error and returns nil when there is no such root,
+// or when the root is read-only and writable is set.
+func (server *Server) requestFileRoot(w http.ResponseWriter, r *http.Request, writable bool) *fileRoot {
+	root, err := server.fileRootOf(r, writable)
+	if err != nil {
+		http.Error(w, err.message, err.status)
+		return nil
+	}
+	return root
+}
+
+// fileRootOf is requestFileRoot returning the error instead of responding with it.
+func (server *Server) fileRootOf(r *http.Request, writable bool) (*fileRoot, *fileError) {
+	root := server.fileRootByName(r.URL.Query().Get("root"))
+	if root == nil {
+		return nil, newFileError(http.StatusNotFound, "Unknown root")
+	}
+	if writable && root.readOnly {
+		return nil, newFileError(http.StatusForbidden, "Root is read-only")
+	}
+	return root, nil
+}
+
+// fileRootByName returns the root called name, the first root if name is empty, or nil.
+func (server *Server) fileRootByName(name string) *fileRoot {
+	if name == "" && len(server.fileRoots) > 0 {
+		return server.fileRoots[0]
+	}
+	for _, root := range server.fileRoots {
+		if root.name == name {
+			return root
+		}
+	}
+	return nil
+}
+
+// errInvalidPath is returned for client paths that are absolute or leave their root.
+var errInvalidPath = errors.New("invalid path")
+
+// The file APIs resolve client paths in two steps: cleanPath rejects paths that
+// are absolute or leave the root with "..", then the file is accessed through
+// the os.Root of fileRoot.open, which also refuses to follow symbolic links
+// out of the root. Nothing outside a root is ever opened, created or removed.
+
+// cleanPath validates name, a slash separated path relative to a root sent by
+// a client, and returns it cleaned, "." for the root itself.
+func cleanPath(name string) (string, error) {
+	if name == "" {
+		return ".", nil
+	}
+	if strings.ContainsRune(name, 0) || path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errInvalidPath
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", errInvalidPath
+	}
+	return name, nil
+}
+
+// errHiddenPath is returned for paths in the temporary directories of a root.
+var errHiddenPath = errors.Wrap(fs.ErrNotExist, "hidden path")
+
+// isHidden tells whether name, a path cleaned by cleanPath, is in a temporary
+// directory of root. Names are compared ignoring case, as file systems may do.
+func (root *fileRoot) isHidden(name string) bool {
+	for _, hidden := range root.hidden {
+		if strings.EqualFold(name, hidden) || hasFoldPrefix(name, hidden+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsHidden tells whether name, a path cleaned by cleanPath, is a temporary
+// directory of root or one of its parents, which can't be changed by clients.
+func (root *fileRoot) holdsHidden(name string) bool {
+	for _, hidden := range root.hidden {
+		if name == "." || strings.EqualFold(name, hidden) || hasFoldPrefix(hidden, name+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return root.isHidden(name)
+}
+
+func hasFoldPrefix(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// cleanPath is cleanPath refusing the temporary directories of root with errHiddenPath.
+func (root *fileRoot) cleanPath(name string) (string, error) {
+	name, err := cleanPath(name)
+	if err != nil {
+		return "", err
+	}
+	if root.isHidden(name) {
+		return "", errHiddenPath
+	}
+	return name, nil
+}
+
+// open opens the directory of root, creating it unless root is read-only.
+// Operations through the returned os.Root can't leave the directory.
+func (root *fileRoot) open() (*os.Root, error) {
+	if !root.readOnly {
+		if err := os.MkdirAll(root.path, 0755); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.OpenRoot(root.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open root `%s`", root.name)
+	}
+	return dir, nil
+}
+
+// openTemp opens the directory keeping the uploads in progress of root, creating it.
+// The default .temp is opened through the root, so a link can't move it out of
+// the root, upload_temp_dir is opened as a root of its own.
+func (root *fileRoot) openTemp() (*os.Root, error) {
+	if rel, err := filepath.Rel(root.path, root.tempPath); err == nil && filepath.IsLocal(rel) {
+		dir, err := root.open()
+		if err != nil {
+			return nil, err
+		}
+		defer dir.Close()
+		if err := dir.MkdirAll(rel, 0755); err != nil {
+			return nil, errors.Wrapf(err, "failed to create the temporary directory of root `%s`", root.name)
+		}
+		temp, err := dir.OpenRoot(rel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open the temporary directory of root `%s`", root.name)
+		}
+		return temp, nil
+	}
+
+	if err := os.MkdirAll(root.tempPath, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create the temporary directory of root `%s`", root.name)
+	}
+	temp, err := os.OpenRoot(root.tempPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open the temporary directory of root `%s`", root.name)
+	}
+	return temp, nil
+}
+
+// openStaging opens the folder name of the temp directory of root, creating it.
+// Chunked and tus uploads have a folder each, so that the ID of a chunked upload
+// chosen by the client can't name the state of tus uploads.
+func (root *fileRoot) openStaging(name string) (*os.Root, error) {
+	temp, err := root.openTemp()
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	if err := temp.Mkdir(name, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, errors.Wrapf(err, "failed to create the temporary directory of root `%s`", root.name)
+	}
+	return temp.OpenRoot(name)
+}
+
+// createUnique creates the file name in dir, or name_1, name_2... if it exists,
+// and returns the file with its name.
+func createUnique(dir *os.Root, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+		}
+		file, err := dir.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			return file, candidate, err
+		}
+	}
+}
+
+// checkQuota returns an error when adding size bytes to root exceeds its quota.
+func (root *fileRoot) checkQuota(size int64) error {
+	left, err := root.quotaLeft()
+	if err != nil {
+		return err
+	}
+	if left >= 0 && size > left {
+		return errors.Errorf("quota of root `%s` exceeded", root.name)
+	}
+	return nil
+}
+
+// quotaLeft returns the bytes that can still be added to root, -1 without a quota.
+func (root *fileRoot) quotaLeft() (int64, error) {
+	if root.quota <= 0 {
+		return -1, nil
+	}
+	var used int64
+	err := filepath.WalkDir(root.path, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root.path {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rel, _ := filepath.Rel(root.path, path); entry.IsDir() && root.isHidden(rel) {
+			return filepath.SkipDir
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			used += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to compute the size of root `%s`", root.name)
+	}
+	return max(root.quota-used, 0), nil
+}
+
+// fileLimit returns the most bytes of a file uploaded to root when left bytes
+// of its quota remain: the maximum upload size or left, -1 for no limit.
+func (root *fileRoot) fileLimit(left int64) int64 {
+	limit := int64(-1)
+	if root.maxUploadSize > 0 {
+		limit = root.maxUploadSize
+	}
+	if left >= 0 && (limit < 0 || left < limit) {
+		limit = left
+	}
+	return limit
+}
+
+// errUploadTooLarge is returned by uploadReader past its limit.
+var errUploadTooLarge = newFileError(http.StatusRequestEntityTooLarge, "File exceeds the maximum upload size or the quota")
+
+// uploadReader reads an upload, failing with errUploadTooLarge once more than
+// limit bytes are read, so that the size of uploads without Content-Length is
+// enforced while they are written. A negative limit is no limit.
+type uploadReader struct {
+	io.Reader
+	limit int64
+}
+
+func (reader *uploadReader) Read(p []byte) (int, error) {
+	if reader.limit < 0 {
+		return reader.Reader.Read(p)
+	}
+	// read one byte more than the limit to tell whether it's exceeded
+	if int64(len(p)) > reader.limit+1 {
+		p = p[:reader.limit+1]
+	}
+	n, err := reader.Reader.Read(p)
+	if int64(n) > reader.limit {
+		reader.limit = 0
+		return 0, errUploadTooLarge
+	}
+	reader.limit -= int64(n)
+	return n, err
+}
+
+// handleFileRoots lists the roots of the file manager, the first one is the default.
+func (server *Server) handleFileRoots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !server.authorize(w, r, CapDownload, CapUpload, CapDelete) {
+		return
+	}
+
+	type rootInfo struct {
+		Name          string `json:"name"`
+		ReadOnly      bool   `json:"readOnly"`
+		MaxUploadSize int64  `json:"maxUploadSize,omitempty"`
+		Quota         int64  `json:"quota,omitempty"`
+	}
+	roots := []rootInfo{}
+	for _, root := range server.fileRoots {
+		roots = append(roots, rootInfo{
+			Name:          root.name,
+			ReadOnly:      root.readOnly,
+			MaxUploadSize: root.maxUploadSize,
+			Quota:         root.quota,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"roots":     roots,
+		"chunkSize": int64(server.options.UploadChunkSize) * 1024 * 1024,
+	})
+}
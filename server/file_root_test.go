@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"home", "logs", "scratch"} {
+		os.MkdirAll(filepath.Join(dir, name), 0755)
+	}
+	os.WriteFile(filepath.Join(dir, "logs", "app.log"), []byte("started"), 0644)
+
+	options := &Options{
+		MaxUploadSize:   1,
+		UploadChunkSize: 1,
+		FileRoots: map[string]*FileRoot{
+			"scratch": {Path: filepath.Join(dir, "scratch"), Quota: 1},
+			"logs":    {Path: filepath.Join(dir, "logs"), ReadOnly: true},
+			"home":    {Path: filepath.Join(dir, "home"), MaxUploadSize: 2},
+		},
+	}
+	roots, err := newFileRoots(options)
+	if err != nil {
+		t.Fatalf("Unexpected error from newFileRoots(): %s", err)
+	}
+	server := &Server{options: options, fileRoots: roots}
+
+	if server.fileRootByName("").name != "home" || server.fileRootByName("logs").name != "logs" || server.fileRootByName("tmp") != nil {
+		t.Fatalf("Unexpected roots: %v %v", roots[0], roots[1])
+	}
+	if roots[0].maxUploadSize != 2*1024*1024 || roots[1].maxUploadSize != 1024*1024 {
+		t.Fatalf("Unexpected maximum upload sizes: %d %d", roots[0].maxUploadSize, roots[1].maxUploadSize)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleFileRoots(w, httptest.NewRequest("GET", "/api/roots", nil))
+	var list struct {
+		Roots []struct {
+			Name     string `json:"name"`
+			ReadOnly bool   `json:"readOnly"`
+		} `json:"roots"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Roots) != 3 || list.Roots[0].Name != "home" || !list.Roots[1].ReadOnly {
+		t.Fatalf("Unexpected roots: %+v", list.Roots)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleFileList(w, httptest.NewRequest("GET", "/api/files?root=logs", nil))
+	if w.Code != 200 || !bytes.Contains(w.Body.Bytes(), []byte("app.log")) {
+		t.Fatalf("Unexpected listing of logs: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	server.handleFileList(w, httptest.NewRequest("GET", "/api/files?root=tmp", nil))
+	if w.Code != 404 {
+		t.Fatalf("Unexpected status listing an unknown root: %d", w.Code)
+	}
+
+	upload := func(root string, sizes ...int) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		for i, size := range sizes {
+			part, _ := form.CreateFormFile("files", fmt.Sprintf("data%d.bin", i))
+			part.Write(make([]byte, size))
+		}
+		form.Close()
+		r := httptest.NewRequest("POST", "/api/upload?root="+root, body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.handleFileUpload(w, r)
+		return w
+	}
+	if code := upload("logs", 10).Code; code != 403 {
+		t.Fatalf("Unexpected status uploading to a read-only root: %d", code)
+	}
+	w = httptest.NewRecorder()
+	server.handleFileDelete(w, httptest.NewRequest("DELETE", "/api/delete?root=logs&file=app.log", nil))
+	if w.Code != 403 {
+		t.Fatalf("Unexpected status deleting from a read-only root: %d", w.Code)
+	}
+
+	if code := upload("home", 10).Code; code != 200 {
+		t.Fatalf("Unexpected status uploading to home: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "home", "data0.bin")); err != nil {
+		t.Fatalf("Uploaded file not found: %s", err)
+	}
+	// files larger than the maximum upload size are rejected, the others saved
+	w = upload("home", 2*1024*1024+1, 10)
+	var result struct {
+		Success bool `json:"success"`
+		Files   []struct {
+			Filename string `json:"filename"`
+		} `json:"files"`
+		Errors []struct {
+			Filename string `json:"filename"`
+		} `json:"errors"`
+	}
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code != 413 || result.Success || len(result.Errors) != 1 || result.Errors[0].Filename != "data0.bin" ||
+		len(result.Files) != 1 || result.Files[0].Filename != "data1.bin" {
+		t.Fatalf("Unexpected response to a file larger than the maximum upload size: %d %+v", w.Code, result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "home", "data0_1.bin")); err == nil {
+		t.Fatalf("File larger than the maximum upload size saved")
+	}
+
+	os.WriteFile(filepath.Join(dir, "scratch", "big.bin"), make([]byte, 1024*1024-10), 0644)
+	if err := roots[2].checkQuota(5); err != nil {
+		t.Fatalf("Unexpected error from checkQuota(): %s", err)
+	}
+	if err := roots[2].checkQuota(20); err == nil {
+		t.Fatalf("No error from checkQuota() beyond the quota")
+	}
+}
+
+func TestOpenTemp(t *testing.T) {
+	dir := t.TempDir()
+	for tempDir, expected := range map[string]string{
+		"":                            filepath.Join(dir, "data", ".temp"),
+		filepath.Join(dir, "staging"): filepath.Join(dir, "staging", "default"),
+	} {
+		roots, err := newFileRoots(&Options{FileRoot: filepath.Join(dir, "data"), UploadTempDir: tempDir})
+		if err != nil {
+			t.Fatalf("Unexpected error from newFileRoots(): %s", err)
+		}
+		temp, err := roots[0].openTemp()
+		if err != nil {
+			t.Fatalf("Unexpected error from openTemp(): %s", err)
+		}
+		temp.WriteFile("chunk", []byte("data"), 0644)
+		temp.Close()
+		if _, err := os.Stat(filepath.Join(expected, "chunk")); err != nil {
+			t.Fatalf("Chunk not staged in %s: %s", expected, err)
+		}
+	}
+}
+
+func TestHiddenTemp(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	os.MkdirAll(filepath.Join(data, ".temp", "tus"), 0755)
+	record := filepath.Join(data, ".temp", "tus", "abc.json")
+	os.WriteFile(record, []byte(`{"user": "alice"}`), 0644)
+	request := func(handler http.HandlerFunc, method string, target string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := request(server.handleFileList, "GET", "/api/files", "")
+	if w.Code != 200 || strings.Contains(w.Body.String(), ".temp") || !strings.Contains(w.Body.String(), "notes.txt") {
+		t.Fatalf("Unexpected listing of the root: %d %s", w.Code, w.Body)
+	}
+	for _, target := range []string{"/api/files?path=.temp", "/api/files?path=.temp/tus", "/api/download?file=.temp/tus/abc.json", "/api/download?file=.TEMP/tus/abc.json", "/api/download?file=docs/../.temp/tus/abc.json"} {
+		handler := server.handleFileList
+		if strings.HasPrefix(target, "/api/download") {
+			handler = server.handleFileDownload
+		}
+		if w := request(handler, "GET", target, ""); w.Code == 200 || strings.Contains(w.Body.String(), "alice") {
+			t.Fatalf("Temporary files served at %s: %d %s", target, w.Code, w.Body)
+		}
+	}
+	w = request(server.handleBatchDownload, "POST", "/api/batch-download", `{"files": ["."]}`)
+	if w.Code != 200 || strings.Contains(w.Body.String(), ".temp") || !strings.Contains(w.Body.String(), "notes.txt") {
+		t.Fatalf("Unexpected archive of the root: %d", w.Code)
+	}
+
+	rename := server.handleFileOp("POST", true, server.fileRename, CapUpload)
+	copy := server.handleFileOp("POST", true, server.fileCopy, CapUpload)
+	stat := server.handleFileOp("GET", false, fileStat, CapDownload)
+	for _, step := range []struct {
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+	}{
+		{server.handleFileDelete, "DELETE", "/api/delete?file=.temp", ""},
+		{server.handleFileDelete, "DELETE", "/api/delete?file=.temp/tus/abc.json", ""},
+		{rename, "POST", "/api/rename", `{"from": ".temp/tus/abc.json", "to": "abc.json"}`},
+		{rename, "POST", "/api/rename", `{"from": "notes.txt", "to": ".temp/tus/abc.json", "overwrite": true}`},
+		{rename, "POST", "/api/rename", `{"from": ".temp", "to": "temp"}`},
+		{copy, "POST", "/api/copy", `{"from": "notes.txt", "to": ".temp/tus/abc.json", "overwrite": true}`},
+		{stat, "GET", "/api/stat?path=.temp/tus/abc.json", ""},
+	} {
+		if w := request(step.handler, step.method, step.target, step.body); w.Code < 400 {
+			t.Fatalf("Temporary files accessed by %s %s: %d %s", step.target, step.body, w.Code, w.Body)
+		}
+	}
+	if content, err := os.ReadFile(record); err != nil || string(content) != `{"user": "alice"}` {
+		t.Fatalf("Temporary file changed: %s %v", content, err)
+	}
+
+	// upload_temp_dir in a root is hidden as well, with the folders holding it
+	roots, err := newFileRoots(&Options{FileRoot: dir, UploadTempDir: filepath.Join(dir, "home", "uploads")})
+	if err != nil {
+		t.Fatalf("Unexpected error from newFileRoots(): %s", err)
+	}
+	root := roots[0]
+	for name, hidden := range map[string]bool{"home/uploads": true, "home/uploads/default/tus": true, "home": false, "home/other": false, "data": false} {
+		if root.isHidden(filepath.FromSlash(name)) != hidden {
+			t.Fatalf("Unexpected isHidden(%s): %v", name, !hidden)
+		}
+	}
+	for _, name := range []string{"home", "home/uploads"} {
+		if _, err := root.cleanEntryPath(name); err == nil {
+			t.Fatalf("Folder %s holding the temporary directory not refused", name)
+		}
+	}
+	if _, err := root.cleanEntryPath("home/other"); err != nil {
+		t.Fatalf("Unexpected error from cleanEntryPath(): %s", err)
+	}
+}
+
+func TestUploadQuotaWithoutLength(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	root := server.fileRoots[0]
+	left, err := root.quotaLeft()
+	if err != nil || left != -1 {
+		t.Fatalf("Unexpected result of quotaLeft() without a quota: %d %v", left, err)
+	}
+	// 28 bytes are used
+	root.quota = 38
+	if left, err := root.quotaLeft(); err != nil || left != 10 {
+		t.Fatalf("Unexpected result of quotaLeft(): %d %v", left, err)
+	}
+
+	// chunked requests have no Content-Length to check first
+	send := func(handler http.HandlerFunc, target string, fields map[string]string, field string, size int) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		for key, value := range fields {
+			form.WriteField(key, value)
+		}
+		part, _ := form.CreateFormFile(field, "big.bin")
+		part.Write(bytes.Repeat([]byte("x"), size))
+		form.Close()
+		r := httptest.NewRequest("POST", target, body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		r.ContentLength = -1
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	if w := send(server.handleFileUpload, "/api/upload", nil, "files", 20); w.Code != 413 || !strings.Contains(w.Body.String(), "big.bin") {
+		t.Fatalf("Unexpected response to an upload beyond the quota: %d %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(data, "big.bin")); err == nil {
+		t.Fatalf("Upload beyond the quota saved")
+	}
+	fields := map[string]string{"chunkIndex": "0", "totalChunks": "2", "fileId": "big", "filename": "big.bin"}
+	if w := send(server.handleChunkUpload, "/api/upload-chunk", fields, "chunk", 20); w.Code != 413 {
+		t.Fatalf("Unexpected status of a chunk beyond the quota: %d", w.Code)
+	}
+
+	handler := server.newWebDAVHandler("/")
+	r := httptest.NewRequest("PUT", "/webdav/data/big.bin", strings.NewReader(strings.Repeat("x", 20)))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 413 {
+		t.Fatalf("Unexpected status of a WebDAV PUT beyond the quota: %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(data, "big.bin")); err == nil {
+		t.Fatalf("WebDAV PUT beyond the quota saved")
+	}
+
+	// uploaded files count towards the quota
+	send(server.handleFileUpload, "/api/upload", nil, "files", 6)
+	send(server.handleFileUpload, "/api/upload", nil, "files", 6)
+	if _, err := os.Stat(filepath.Join(data, "big.bin")); err != nil {
+		t.Fatalf("Upload within the quota not saved: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(data, "big_1.bin")); err == nil {
+		t.Fatalf("Second upload beyond the quota saved")
+	}
+}
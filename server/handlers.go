@@ -3,7 +3,6 @@ package server
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,10 +10,12 @@ import (
 	"net/url"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 
+	"gotty/pkg/sharelink"
 	"gotty/webtty"
 )
 
@@ -67,45 +68,34 @@ func (server *Server) generateHandleWS(ctx context.Context, cancel context.Cance
 			return
 		}
 
-		// Verify authentication if BasicAuth is enabled
-		if server.options.EnableBasicAuth {
-			// Try to get auth from query parameter first (for WebSocket)
-			authToken := r.URL.Query().Get("auth")
-			if authToken != "" {
-				// Decode the base64 auth token
-				payload, err := base64.StdEncoding.DecodeString(authToken)
-				if err != nil {
-					log.Printf("[GoTTY] Failed to decode auth token: %v", err)
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				if server.options.Credential != string(payload) {
-					log.Printf("[GoTTY] Invalid credentials from query: got '%s', expected '%s'", string(payload), server.options.Credential)
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				log.Printf("[GoTTY] WebSocket auth successful via query parameter")
-			} else {
-				// Fall back to Authorization header
-				token := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
-				if len(token) != 2 || strings.ToLower(token[0]) != "basic" {
-					log.Printf("[GoTTY] Invalid Authorization header format")
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				payload, err := base64.StdEncoding.DecodeString(token[1])
-				if err != nil {
-					log.Printf("[GoTTY] Failed to decode Authorization header: %v", err)
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				if server.options.Credential != string(payload) {
-					log.Printf("[GoTTY] Invalid credentials from header: got '%s', expected '%s'", string(payload), server.options.Credential)
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				log.Printf("[GoTTY] WebSocket auth successful via Authorization header")
+		var identity *Identity
+		if token := r.URL.Query().Get("share"); token != "" {
+			var err error
+			identity, err = server.shares.use(token, sharelink.Terminal)
+			if err != nil {
+				closeReason = err.Error()
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Client connected with share link: %s (%s)", r.RemoteAddr, identity.Share.ID)
+		} else if server.authenticator != nil {
+			var err error
+			identity, err = server.authenticate(r)
+			if respondLockout(w, err) {
+				closeReason = err.Error()
+				return
+			}
+			if err != nil {
+				closeReason = "authentication failure"
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
 			}
+			log.Printf("Client authenticated: %s (%s)", r.RemoteAddr, identity.Name)
+		}
+		if !server.permissions(identity).can(CapView) {
+			closeReason = "missing permission to view"
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
 		}
 
 		conn, err := server.upgrader.Upgrade(w, r, nil)
@@ -116,9 +106,9 @@ func (server *Server) generateHandleWS(ctx context.Context, cancel context.Cance
 		defer conn.Close()
 
 		if server.options.PassHeaders {
-			err = server.processWSConn(ctx, conn, r.Header)
+			err = server.processWSConn(ctx, conn, identity, r.Header)
 		} else {
-			err = server.processWSConn(ctx, conn, nil)
+			err = server.processWSConn(ctx, conn, identity, nil)
 		}
 
 		switch err {
@@ -129,12 +119,22 @@ func (server *Server) generateHandleWS(ctx context.Context, cancel context.Cance
 		case webtty.ErrMasterClosed:
 			closeReason = "client"
 		default:
-			closeReason = fmt.Sprintf("an error: %s", err)
+			if exitErr, ok := err.(*slaveExitError); ok {
+				closeReason = fmt.Sprintf("%s (%s)", server.factory.Name(), exitErr.reason)
+				// let the client show why the terminal ended
+				conn.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, exitErr.reason),
+					time.Now().Add(time.Second),
+				)
+			} else {
+				closeReason = fmt.Sprintf("an error: %s", err)
+			}
 		}
 	}
 }
 
-func (server *Server) processWSConn(ctx context.Context, conn *websocket.Conn, headers map[string][]string) error {
+func (server *Server) processWSConn(ctx context.Context, conn *websocket.Conn, identity *Identity, headers map[string][]string) error {
 	typ, initLine, err := conn.ReadMessage()
 	if err != nil {
 		return errors.Wrapf(err, "failed to authenticate websocket connection")
@@ -149,17 +149,6 @@ func (server *Server) processWSConn(ctx context.Context, conn *websocket.Conn, h
 		return errors.Wrapf(err, "failed to authenticate websocket connection")
 	}
 
-	// Only verify auth token if basic auth is enabled
-	if server.options.EnableBasicAuth && server.options.Credential != "" {
-		// Decode base64 auth token and compare with credential
-		decodedAuth, err := base64.StdEncoding.DecodeString(init.AuthToken)
-		if err != nil || string(decodedAuth) != server.options.Credential {
-			log.Printf("[GoTTY] WebSocket init auth failed: decoded='%s', expected='%s', decode_err=%v", string(decodedAuth), server.options.Credential, err)
-			return errors.New("failed to authenticate websocket connection")
-		}
-		log.Printf("[GoTTY] WebSocket initialization authenticated successfully")
-	}
-
 	queryPath := "?"
 	if server.options.PermitArguments && init.Arguments != "" {
 		queryPath = init.Arguments
@@ -170,19 +159,65 @@ func (server *Server) processWSConn(ctx context.Context, conn *websocket.Conn, h
 		return errors.Wrapf(err, "failed to parse arguments")
 	}
 	params := query.Query()
+
+	perms := server.permissions(identity)
+	newSlave, err := server.slaveFactory(init.Arguments, perms)
+	if err != nil {
+		return err
+	}
+	env := identity.env()
+
 	var slave Slave
-	slave, err = server.factory.New(params, headers)
+	var sessionID string
+	owner := true
+	if server.sessions != nil {
+		var viewer *sessionViewer
+		user := ""
+		if identity != nil {
+			user = identity.Name
+		}
+		viewer, owner, err = server.sessions.attach(init.SessionID, user, server.profileOf(init.Arguments), func() (Slave, error) {
+			return newSlave(params, headers, env)
+		})
+		if err == nil {
+			slave, sessionID = viewer, viewer.session.id
+		}
+	} else {
+		slave, err = newSlave(params, headers, env)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to create backend")
 	}
+	if server.options.RecordDir != "" {
+		recorder, err := newRecordingSlave(slave, server.options.RecordDir, conn.RemoteAddr().String(), env)
+		if err != nil {
+			slave.Close()
+			return errors.Wrapf(err, "failed to start recording")
+		}
+		log.Printf("Recording session to %s", recorder.file.Name())
+		slave = recorder
+	}
 	defer slave.Close()
 
+	remoteUser, remoteEmail, remoteGroups := "", "", ""
+	clientCN, clientSerial := "", ""
+	if identity != nil {
+		remoteUser, remoteEmail, remoteGroups = identity.Name, identity.Email, strings.Join(identity.Groups, ",")
+		if identity.Certificate != nil {
+			clientCN, clientSerial = identity.Certificate.CommonName, identity.Certificate.Serial
+		}
+	}
 	titleVars := server.titleVariables(
 		[]string{"server", "master", "slave"},
 		map[string]map[string]any{
 			"server": server.options.TitleVariables,
 			"master": {
-				"remote_addr": conn.RemoteAddr(),
+				"remote_addr":   conn.RemoteAddr(),
+				"remote_user":   remoteUser,
+				"remote_email":  remoteEmail,
+				"remote_groups": remoteGroups,
+				"client_cn":     clientCN,
+				"client_serial": clientSerial,
 			},
 			"slave": slave.WindowTitleVariables(),
 		},
@@ -197,24 +232,39 @@ func (server *Server) processWSConn(ctx context.Context, conn *websocket.Conn, h
 	opts := []webtty.Option{
 		webtty.WithWindowTitle(titleBuf.Bytes()),
 	}
-	if server.options.PermitWrite {
+	if perms.can(CapWrite) && (owner || server.options.SharedPermitWrite) {
 		opts = append(opts, webtty.WithPermitWrite())
 	}
 	if server.options.EnableReconnect {
 		opts = append(opts, webtty.WithReconnect(server.options.ReconnectTime))
 	}
+	if server.options.SessionGracePeriod > 0 {
+		opts = append(opts, webtty.WithSessionID(sessionID))
+	}
+	if server.options.Compression == webtty.CompressionDeflate {
+		opts = append(opts, webtty.WithCompression(server.options.Compression))
+	}
 	if server.options.Width > 0 {
 		opts = append(opts, webtty.WithFixedColumns(server.options.Width))
 	}
 	if server.options.Height > 0 {
 		opts = append(opts, webtty.WithFixedRows(server.options.Height))
 	}
-	tty, err := webtty.New(&wsWrapper{conn}, slave, opts...)
+	master := newWSWrapper(conn)
+	if master.binary() {
+		opts = append(opts, webtty.WithBinaryProtocol())
+	}
+	tty, err := webtty.New(master, slave, opts...)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create webtty")
 	}
 
 	err = tty.Run(ctx)
+	if err == webtty.ErrSlaveClosed {
+		if reason := exitReason(slave); reason != "" {
+			return &slaveExitError{reason: reason}
+		}
+	}
 
 	return err
 }
@@ -278,8 +328,9 @@ func (server *Server) indexVariables(r *http.Request) (map[string]interface{}, e
 
 func (server *Server) handleAuthToken(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/javascript")
-	// @TODO hashing?
-	w.Write([]byte("var gotty_auth_token = '" + server.options.Credential + "';"))
+	// clients authenticate with a session token from the login endpoint or a cookie,
+	// credentials never reach the browser
+	w.Write([]byte("var gotty_auth_token = '';"))
 }
 
 func (server *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
@@ -289,7 +340,13 @@ func (server *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		"var gotty_ws_query_args = '" + server.options.WSQueryArgs + "';",
 	}
 
-	if server.options.EnableBasicAuth {
+	authRequired := false
+	if server.authenticator != nil {
+		// users authenticated by a proxy don't need to log in
+		_, err := server.authenticate(r)
+		authRequired = err != nil
+	}
+	if authRequired {
 		lines = append(lines, "var gotty_enable_auth = true;")
 	} else {
 		lines = append(lines, "var gotty_enable_auth = false;")
@@ -298,6 +355,42 @@ func (server *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(strings.Join(lines, "\n")))
 }
 
+// slaveFactory returns the function creating the slave for a client,
+// which starts the command profile selected in arguments, if any.
+func (server *Server) slaveFactory(arguments string, perms *permissions) (func(params, headers map[string][]string, env map[string]string) (Slave, error), error) {
+	profile := server.profileOf(arguments)
+	if profile == "" {
+		return server.factory.New, nil
+	}
+
+	if _, ok := server.options.Profiles[profile]; !ok {
+		return nil, errors.Errorf("unknown profile `%s`", profile)
+	}
+	if !perms.canStart(profile) {
+		return nil, errors.Errorf("profile `%s` is not permitted", profile)
+	}
+	factory, ok := server.factory.(ProfileFactory)
+	if !ok {
+		return nil, errors.Errorf("%s does not support profiles", server.factory.Name())
+	}
+	command := server.options.Profiles[profile].Command
+	return func(params, headers map[string][]string, env map[string]string) (Slave, error) {
+		return factory.NewCommand(command, params, headers, env)
+	}, nil
+}
+
+// profileOf returns the profile requested by the arguments of a client, empty for the command of gotty.
+func (server *Server) profileOf(arguments string) string {
+	if len(server.options.Profiles) == 0 || arguments == "" {
+		return ""
+	}
+	query, err := url.Parse(arguments)
+	if err != nil {
+		return ""
+	}
+	return query.Query().Get("profile")
+}
+
 // titleVariables merges maps in a specified order.
 // varUnits are name-keyed maps, whose names will be iterated using order.
 func (server *Server) titleVariables(order []string, varUnits map[string]map[string]interface{}) map[string]interface{} {
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/homedir"
+	"gotty/pkg/passwd"
+)
+
+// htpasswdAuthenticator checks credentials against an htpasswd file with bcrypt hashes,
+// as created by `htpasswd -B`, or argon2id hashes created by `gotty hash-password`. The file is reloaded when it changes,
+// so that users can be added or revoked without restarting gotty.
+type htpasswdAuthenticator struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	hashes  map[string]string
+}
+
+func newHtpasswdAuthenticator(path string) (*htpasswdAuthenticator, error) {
+	htpasswd := &htpasswdAuthenticator{path: homedir.Expand(path)}
+	if err := htpasswd.reload(); err != nil {
+		return nil, err
+	}
+	return htpasswd, nil
+}
+
+func (htpasswd *htpasswdAuthenticator) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	if creds == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	htpasswd.mutex.Lock()
+	if err := htpasswd.reloadLocked(); err != nil {
+		// keep the users loaded before
+		log.Printf("Failed to reload htpasswd file %s: %v", htpasswd.path, err)
+	}
+	hash, ok := htpasswd.hashes[creds.User]
+	htpasswd.mutex.Unlock()
+
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if match, err := passwd.Verify(hash, creds.Password); err != nil || !match {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: creds.User, Provider: "htpasswd"}, nil
+}
+
+func (htpasswd *htpasswdAuthenticator) reload() error {
+	htpasswd.mutex.Lock()
+	defer htpasswd.mutex.Unlock()
+	return htpasswd.reloadLocked()
+}
+
+// reloadLocked reads the file if it was modified since the last read.
+func (htpasswd *htpasswdAuthenticator) reloadLocked() error {
+	info, err := os.Stat(htpasswd.path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open htpasswd file `%s`", htpasswd.path)
+	}
+	if htpasswd.hashes != nil && info.ModTime().Equal(htpasswd.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(htpasswd.path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open htpasswd file `%s`", htpasswd.path)
+	}
+	defer file.Close()
+
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("invalid entry at line %d of htpasswd file `%s`", line, htpasswd.path)
+		}
+		if !passwd.IsHash(parts[1]) {
+			log.Printf("Ignoring user %s of htpasswd file %s, only bcrypt and argon2id hashes are supported", parts[0], htpasswd.path)
+			continue
+		}
+		hashes[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read htpasswd file `%s`", htpasswd.path)
+	}
+
+	htpasswd.hashes = hashes
+	htpasswd.modTime = info.ModTime()
+	return nil
+}
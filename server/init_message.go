@@ -3,4 +3,6 @@ package server
 type InitMessage struct {
 	Arguments string `json:"Arguments,omitempty"`
 	AuthToken string `json:"AuthToken,omitempty"`
+	// SessionID of a detached session to reattach to
+	SessionID string `json:"SessionID,omitempty"`
 }
@@ -1,10 +1,12 @@
 package server
 
 import (
-	"encoding/base64"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+
+	"gotty/pkg/sharelink"
 )
 
 func (server *Server) wrapLogger(handler http.Handler) http.Handler {
@@ -23,10 +25,31 @@ func (server *Server) wrapHeaders(handler http.Handler) http.Handler {
 	})
 }
 
-func (server *Server) wrapBasicAuth(handler http.Handler, credential string) http.Handler {
+// wrapAuth rejects requests of unauthenticated users
+// and passes the identity of the user to handler.
+// With OpenID Connect, unauthenticated users opening the index are redirected to the login.
+func (server *Server) wrapAuth(handler http.Handler, pathPrefix string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow these paths without authentication to load login UI
-		if r.URL.Path == "/" ||
+		if server.login != nil {
+			switch r.URL.Path {
+			case pathPrefix + "login", pathPrefix + "callback", pathPrefix + "logout":
+				handler.ServeHTTP(w, r)
+				return
+			case pathPrefix:
+				if r.URL.Query().Get("share") != "" {
+					break
+				}
+				if _, err := server.authenticate(r); err != nil {
+					http.Redirect(w, r, pathPrefix+"login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+					return
+				}
+			}
+		}
+
+		// Allow these paths without authentication to load login UI,
+		// files served over WebDAV can have any name
+		webdav := strings.HasPrefix(r.URL.Path, pathPrefix+"webdav/")
+		if !webdav && (r.URL.Path == "/" ||
 			strings.HasPrefix(r.URL.Path, "/js/") ||
 			strings.HasPrefix(r.URL.Path, "/css/") ||
 			strings.HasPrefix(r.URL.Path, "/icon") ||
@@ -36,32 +59,44 @@ func (server *Server) wrapBasicAuth(handler http.Handler, credential string) htt
 			strings.HasSuffix(r.URL.Path, ".png") ||
 			strings.HasSuffix(r.URL.Path, ".svg") ||
 			strings.HasSuffix(r.URL.Path, ".ico") ||
-			strings.HasSuffix(r.URL.Path, "/api/auth/verify") {
+			strings.HasSuffix(r.URL.Path, "/api/auth/verify") ||
+			strings.HasSuffix(r.URL.Path, "/api/auth/login")) {
 			handler.ServeHTTP(w, r)
 			return
 		}
 
-		token := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
-
-		if len(token) != 2 || strings.ToLower(token[0]) != "basic" {
-			w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
-			http.Error(w, "Bad Request", http.StatusUnauthorized)
-			return
+		// share links open the terminal or download a file
+		if token := r.URL.Query().Get("share"); token != "" {
+			switch r.URL.Path {
+			case pathPrefix:
+				handler.ServeHTTP(w, r)
+				return
+			case pathPrefix + "api/download":
+				identity, err := server.shares.use(token, sharelink.File)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				log.Printf("Share link used: %s (%s)", r.RemoteAddr, identity.Share.ID)
+				handler.ServeHTTP(w, withIdentity(r, identity))
+				return
+			}
 		}
 
-		payload, err := base64.StdEncoding.DecodeString(token[1])
-		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		identity, err := server.authenticate(r)
+		if respondLockout(w, err) {
 			return
 		}
-
-		if credential != string(payload) {
-			w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
+		if err != nil {
+			// WebDAV clients can't follow the OpenID Connect login
+			if server.login == nil || webdav {
+				w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
+			}
 			http.Error(w, "authorization failed", http.StatusUnauthorized)
 			return
 		}
 
-		log.Printf("Basic Authentication Succeeded: %s", r.RemoteAddr)
-		handler.ServeHTTP(w, r)
+		log.Printf("Authentication succeeded: %s (%s)", r.RemoteAddr, identity.Name)
+		handler.ServeHTTP(w, withIdentity(r, identity))
 	})
 }
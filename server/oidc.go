@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookie = "gotty_session"
+	// loginCookie keeps the state of a login until the provider redirects back
+	loginCookie  = "gotty_login"
+	loginTimeout = 10 * time.Minute
+)
+
+// oidcAuthenticator logs users in with the authorization code flow of an
+// OpenID Connect provider and keeps them in an encrypted session cookie.
+type oidcAuthenticator struct {
+	verifier    *oidc.IDTokenVerifier
+	config      oauth2.Config
+	cookies     *cookieCodec
+	maxAge      time.Duration
+	secure      bool
+	redirectURL string
+}
+
+// loginState is stored in loginCookie during a login.
+type loginState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Next     string
+}
+
+func newOIDCAuthenticator(ctx context.Context, options *Options) (*oidcAuthenticator, error) {
+	provider, err := oidc.NewProvider(ctx, options.OIDCIssuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to discover OpenID Connect provider `%s`", options.OIDCIssuer)
+	}
+	cookies, err := newCookieCodec(options.SessionSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range strings.Split(options.OIDCScopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" && scope != oidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return &oidcAuthenticator{
+		verifier: provider.Verifier(&oidc.Config{ClientID: options.OIDCClientID}),
+		config: oauth2.Config{
+			ClientID:     options.OIDCClientID,
+			ClientSecret: options.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		cookies:     cookies,
+		maxAge:      time.Duration(options.SessionMaxAge) * time.Second,
+		secure:      options.EnableTLS,
+		redirectURL: options.OIDCRedirectURL,
+	}, nil
+}
+
+func (login *oidcAuthenticator) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	var identity Identity
+	if err := login.cookies.decode(sessionCookie, cookie.Value, &identity); err != nil {
+		return nil, ErrUnauthenticated
+	}
+	return &identity, nil
+}
+
+// handleLogin redirects the client to the provider.
+func (login *oidcAuthenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSuffix(r.URL.Path, "login")
+	state := &loginState{
+		State:    oauth2.GenerateVerifier(),
+		Nonce:    oauth2.GenerateVerifier(),
+		Verifier: oauth2.GenerateVerifier(),
+		Next:     r.URL.Query().Get("next"),
+	}
+	// only redirect back to gotty itself
+	if !strings.HasPrefix(state.Next, prefix) || strings.HasPrefix(state.Next, "//") || strings.Contains(state.Next, "\\") {
+		state.Next = prefix
+	}
+
+	if err := login.setCookie(w, loginCookie, prefix, state, time.Now().Add(loginTimeout)); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	config := login.configFor(r, prefix)
+	http.Redirect(w, r, config.AuthCodeURL(
+		state.State,
+		oidc.Nonce(state.Nonce),
+		oauth2.S256ChallengeOption(state.Verifier),
+	), http.StatusFound)
+}
+
+// handleCallback completes the login when the provider redirects the client back.
+func (login *oidcAuthenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSuffix(r.URL.Path, "callback")
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		log.Printf("Login of %s failed: %s %s", r.RemoteAddr, query.Get("error"), query.Get("error_description"))
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	var state loginState
+	cookie, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = login.cookies.decode(loginCookie, cookie.Value, &state)
+	}
+	if err != nil || state.State != query.Get("state") {
+		http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+		return
+	}
+	login.clearCookie(w, loginCookie, prefix)
+
+	identity, err := login.exchange(r, prefix, query.Get("code"), &state)
+	if err != nil {
+		log.Printf("Login of %s failed: %v", r.RemoteAddr, err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	if err := login.setCookie(w, sessionCookie, prefix, identity, time.Now().Add(login.maxAge)); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Login succeeded: %s (%s)", r.RemoteAddr, identity.Name)
+	http.Redirect(w, r, state.Next, http.StatusFound)
+}
+
+// exchange redeems the code for an ID token and returns the user it identifies.
+func (login *oidcAuthenticator) exchange(r *http.Request, prefix string, code string, state *loginState) (*Identity, error) {
+	config := login.configFor(r, prefix)
+	token, err := config.Exchange(r.Context(), code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to redeem code")
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no ID token in the token response")
+	}
+	idToken, err := login.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to verify ID token")
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, errors.New("nonce of the ID token doesn't match")
+	}
+
+	var claims struct {
+		Email             string   `json:"email"`
+		PreferredUsername string   `json:"preferred_username"`
+		Groups            []string `json:"groups"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse claims")
+	}
+
+	identity := &Identity{
+		Name:     claims.PreferredUsername,
+		Email:    claims.Email,
+		Groups:   claims.Groups,
+		Provider: "oidc",
+	}
+	if identity.Name == "" {
+		identity.Name = claims.Email
+	}
+	if identity.Name == "" {
+		identity.Name = idToken.Subject
+	}
+	return identity, nil
+}
+
+// handleLogout ends the session of the client.
+func (login *oidcAuthenticator) handleLogout(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSuffix(r.URL.Path, "logout")
+	login.clearCookie(w, sessionCookie, prefix)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html><p>Logged out. <a href="%s">Log in again</a></p>`, prefix+"login")
+}
+
+// configFor returns the OAuth2 configuration with the callback URL of gotty,
+// derived from the request unless it is configured.
+func (login *oidcAuthenticator) configFor(r *http.Request, prefix string) *oauth2.Config {
+	config := login.config
+	config.RedirectURL = login.redirectURL
+	if config.RedirectURL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		config.RedirectURL = (&url.URL{Scheme: scheme, Host: r.Host, Path: prefix + "callback"}).String()
+	}
+	return &config
+}
+
+func (login *oidcAuthenticator) setCookie(w http.ResponseWriter, name string, path string, value interface{}, expires time.Time) error {
+	encoded, err := login.cookies.encode(name, value, expires)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   login.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (login *oidcAuthenticator) clearCookie(w http.ResponseWriter, name string, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   login.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/coreos/go-oidc/v3/oidc/oidctest"
+	"golang.org/x/oauth2"
+)
+
+// mockIssuer is an OpenID Connect provider issuing a token for a single login.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// set by the test from the authorization request
+	nonce     string
+	challenge string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unexpected error from GenerateKey(): %s", err)
+	}
+	issuer := &mockIssuer{key: key}
+	provider := &oidctest.Server{
+		PublicKeys: []oidctest.PublicKey{{PublicKey: key.Public(), KeyID: "test", Algorithm: oidc.RS256}},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", provider)
+	mux.HandleFunc("/token", issuer.handleToken)
+	issuer.Server = httptest.NewServer(mux)
+	provider.SetIssuer(issuer.URL)
+	return issuer
+}
+
+func (issuer *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("code") != "code" || oauth2.S256ChallengeFromVerifier(r.Form.Get("code_verifier")) != issuer.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	claims := fmt.Sprintf(
+		`{"iss":%q,"aud":"gotty","sub":"1234","exp":%d,"nonce":%q,"email":"alice@example.com","preferred_username":"alice","groups":["dev","ops"]}`,
+		issuer.URL, time.Now().Add(time.Hour).Unix(), issuer.nonce,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "token",
+		"token_type":   "Bearer",
+		"id_token":     oidctest.SignIDToken(issuer.key, "test", oidc.RS256, claims),
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	login, err := newOIDCAuthenticator(context.Background(), &Options{
+		OIDCIssuer:    issuer.URL,
+		OIDCClientID:  "gotty",
+		OIDCScopes:    "openid,email,groups",
+		SessionMaxAge: 3600,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from newOIDCAuthenticator(): %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	login.handleLogin(w, httptest.NewRequest("GET", "http://gotty.test/base/login?next=https://evil.test/", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Unexpected status of login: %d", w.Code)
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	query := location.Query()
+	if !strings.HasPrefix(location.String(), issuer.URL+"/auth") ||
+		query.Get("redirect_uri") != "http://gotty.test/base/callback" ||
+		query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Unexpected redirect to the provider: %s", location)
+	}
+	issuer.nonce, issuer.challenge = query.Get("nonce"), query.Get("code_challenge")
+	loginCookies := w.Result().Cookies()
+
+	// a callback with a different state is rejected
+	r := httptest.NewRequest("GET", "http://gotty.test/base/callback?code=code&state=forged", nil)
+	for _, cookie := range loginCookies {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	login.handleCallback(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Unexpected status of forged callback: %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "http://gotty.test/base/callback?code=code&state="+query.Get("state"), nil)
+	for _, cookie := range loginCookies {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	login.handleCallback(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/base/" {
+		t.Fatalf("Unexpected response of callback: %d %s %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+
+	r = httptest.NewRequest("GET", "http://gotty.test/base/ws", nil)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			r.AddCookie(cookie)
+		}
+	}
+	identity, err := login.Authenticate(r, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from Authenticate(): %s", err)
+	}
+	if identity.Name != "alice" || identity.Email != "alice@example.com" || strings.Join(identity.Groups, ",") != "dev,ops" {
+		t.Fatalf("Unexpected identity %+v", identity)
+	}
+	if env := identity.env(); env["GOTTY_USER"] != "alice" || env["GOTTY_GROUPS"] != "dev,ops" {
+		t.Fatalf("Unexpected env %v", env)
+	}
+
+	r = httptest.NewRequest("GET", "http://gotty.test/base/ws", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "forged"})
+	if _, err := login.Authenticate(r, nil); err != ErrUnauthenticated {
+		t.Fatalf("Expected ErrUnauthenticated for a forged session, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"github.com/pkg/errors"
+
+	"gotty/webtty"
 )
 
 type Options struct {
@@ -10,19 +12,54 @@ type Options struct {
 	Path                string `hcl:"path" flagName:"path" flagSName:"m" flagDescribe:"Base path" default:"/"`
 	PermitWrite         bool   `hcl:"permit_write" flagName:"permit-write" flagSName:"w" flagDescribe:"Permit clients to write to the TTY (BE CAREFUL)" default:"false"`
 	EnableBasicAuth     bool   `hcl:"enable_basic_auth" default:"false"`
-	Credential          string `hcl:"credential" flagName:"credential" flagSName:"c" flagDescribe:"Credential for Basic Authentication (ex: user:pass, default disabled)" default:""`
+	Credential          string `hcl:"credential" flagName:"credential" flagSName:"c" flagDescribe:"Credential for Basic Authentication, the password should be a hash from the hash-password command (ex: user:hash, default disabled)" default:""`
+	HtpasswdFile        string `hcl:"htpasswd_file" flagName:"htpasswd" flagDescribe:"Authenticate users with an htpasswd file of bcrypt or argon2id hashes (default disabled)" default:""`
+	AuthProxyHeader     string `hcl:"auth_proxy_header" flagName:"auth-proxy-header" flagDescribe:"Trust the user name in this header set by a reverse proxy (ex: X-Forwarded-User, default disabled)" default:""`
+	AuthTrustedProxies  string `hcl:"auth_trusted_proxies" flagName:"auth-trusted-proxies" flagDescribe:"Comma separated IP addresses or CIDR networks of reverse proxies trusted to set the user header and X-Forwarded-For" default:""`
+	LoginMaxFailures    int    `hcl:"login_max_failures" flagName:"login-max-failures" flagDescribe:"Failed logins of an IP address or user before it is locked out, 0 to disable" default:"5"`
+	LoginLockout        int    `hcl:"login_lockout" flagName:"login-lockout" flagDescribe:"Seconds of the first lockout, doubled by each further failed login" default:"60"`
+	LoginMaxLockout     int    `hcl:"login_max_lockout" flagName:"login-max-lockout" flagDescribe:"Maximum seconds of a lockout" default:"3600"`
+	OIDCIssuer          string `hcl:"oidc_issuer" flagName:"oidc-issuer" flagDescribe:"Log users in with this OpenID Connect provider (ex: https://accounts.example.com, default disabled)" default:""`
+	OIDCClientID        string `hcl:"oidc_client_id" flagName:"oidc-client-id" flagDescribe:"OpenID Connect client ID" default:""`
+	OIDCClientSecret    string `hcl:"oidc_client_secret" flagName:"oidc-client-secret" flagDescribe:"OpenID Connect client secret, empty for public clients" default:""`
+	OIDCRedirectURL     string `hcl:"oidc_redirect_url" flagName:"oidc-redirect-url" flagDescribe:"URL of the callback registered at the provider (default derived from the request, ex: https://gotty.example.com/callback)" default:""`
+	OIDCScopes          string `hcl:"oidc_scopes" flagName:"oidc-scopes" flagDescribe:"Comma separated scopes to request" default:"openid,email,profile"`
+	SessionSecret       string `hcl:"session_secret" flagName:"session-secret" flagDescribe:"Secret to encrypt login session cookies (default random, logins end when gotty restarts)" default:""`
+	SessionMaxAge       int    `hcl:"session_max_age" flagName:"session-max-age" flagDescribe:"Seconds a login session lasts" default:"43200"`
+	ShareSecret         string `hcl:"share_secret" flagName:"share-secret" flagDescribe:"Secret to sign share links, required by the share command (default random, links end when gotty restarts)" default:""`
+	ShareStateFile      string `hcl:"share_state_file" flagName:"share-state-file" flagDescribe:"File keeping created, used and revoked share links across restarts, used with share_secret (empty to keep them in memory)" default:"~/.gotty.shares"`
 	EnableRandomUrl     bool   `hcl:"enable_random_url" flagName:"random-url" flagSName:"r" flagDescribe:"Add a random string to the URL" default:"false"`
 	RandomUrlLength     int    `hcl:"random_url_length" flagName:"random-url-length" flagDescribe:"Random URL length" default:"8"`
 	EnableTLS           bool   `hcl:"enable_tls" flagName:"tls" flagSName:"t" flagDescribe:"Enable TLS/SSL" default:"false"`
 	TLSCrtFile          string `hcl:"tls_crt_file" flagName:"tls-crt" flagDescribe:"TLS/SSL certificate file path" default:"~/.gotty.crt"`
 	TLSKeyFile          string `hcl:"tls_key_file" flagName:"tls-key" flagDescribe:"TLS/SSL key file path" default:"~/.gotty.key"`
+	TLSSelfSigned       bool   `hcl:"tls_self_signed" flagName:"tls-self-signed" flagDescribe:"Enable TLS/SSL with a self-signed certificate, generated at tls_crt_file and tls_key_file unless they exist" default:"false"`
+	ACMEDomains         string `hcl:"acme_domains" flagName:"acme-domains" flagDescribe:"Enable TLS/SSL with certificates from an ACME CA for these comma separated domains (default disabled)" default:""`
+	ACMEDirectory       string `hcl:"acme_directory" flagName:"acme-directory" flagDescribe:"Directory URL of the ACME CA" default:"https://acme-v02.api.letsencrypt.org/directory"`
+	ACMEEmail           string `hcl:"acme_email" flagName:"acme-email" flagDescribe:"Contact email of the ACME account" default:""`
+	ACMECacheDir        string `hcl:"acme_cache_dir" flagName:"acme-cache-dir" flagDescribe:"Directory keeping the ACME account and certificates" default:"~/.gotty.acme"`
+	ACMEHTTPAddress     string `hcl:"acme_http_address" flagName:"acme-http-address" flagDescribe:"Address to answer ACME HTTP-01 challenges (ex: :80, default TLS-ALPN-01 challenges only)" default:""`
 	EnableTLSClientAuth bool   `hcl:"enable_tls_client_auth" default:"false"`
 	TLSCACrtFile        string `hcl:"tls_ca_crt_file" flagName:"tls-ca-crt" flagDescribe:"TLS/SSL CA certificate file for client certifications" default:"~/.gotty.ca.crt"`
+	TLSClientIdentity   string `hcl:"tls_client_identity" flagName:"tls-client-identity" flagDescribe:"Identify users by their client certificate: cn, email, dns, uri or the OID of a subject attribute or SAN otherName (default disabled)" default:""`
+	TLSCRLFile          string `hcl:"tls_crl_file" flagName:"tls-crl" flagDescribe:"CRL file of the client certificate CA to reject revoked certificates (default disabled)" default:""`
+	TLSReloadInterval   int    `hcl:"tls_reload_interval" flagName:"tls-reload-interval" flagDescribe:"Seconds between checks of the TLS certificate, key, CA and CRL files for changes (0 to reload on SIGHUP only)" default:"60"`
 	IndexFile           string `hcl:"index_file" flagName:"index" flagDescribe:"Custom index.html file" default:""`
 	TitleFormat         string `hcl:"title_format" flagName:"title-format" flagSName:"" flagDescribe:"Title format of browser window" default:"{{ .command }}@{{ .hostname }}"`
 	EnableReconnect     bool   `hcl:"enable_reconnect" flagName:"reconnect" flagDescribe:"Enable reconnection" default:"false"`
 	ReconnectTime       int    `hcl:"reconnect_time" flagName:"reconnect-time" flagDescribe:"Time to reconnect" default:"10"`
 	MaxConnection       int    `hcl:"max_connection" flagName:"max-connection" flagDescribe:"Maximum connection to gotty" default:"0"`
+	EnableSharedSession bool   `hcl:"enable_shared_session" flagName:"shared-session" flagDescribe:"Share a single command between all clients instead of spawning one per client" default:"false"`
+	SharedPermitWrite   bool   `hcl:"shared_permit_write" flagName:"shared-permit-write" flagDescribe:"Permit clients joining a shared session to write to the TTY, otherwise only the client that spawned it can" default:"false"`
+	SharedResizePolicy  string `hcl:"shared_resize_policy" flagName:"shared-resize-policy" flagDescribe:"Which clients of a shared session control the terminal size (owner, latest, smallest)" default:"owner"`
+	SessionGracePeriod  int    `hcl:"session_grace_period" flagName:"session-grace-period" flagDescribe:"Seconds to keep the command of a disconnected client alive for it to reconnect (0 to disable)" default:"0"`
+	ScrollbackSize      int    `hcl:"scrollback_size" flagName:"scrollback-size" flagDescribe:"Bytes of output replayed to clients reattaching to a session" default:"65536"`
+	FileRoot            string `hcl:"file_root" flagName:"file-root" flagDescribe:"Directory of the file manager, see file_roots in the config file to serve several ones" default:"./uploads"`
+	UploadTempDir       string `hcl:"upload_temp_dir" flagName:"upload-temp-dir" flagDescribe:"Directory keeping the uploads in progress, in a folder per file root (empty for .temp in each file root)" default:"~/.gotty.uploads"`
+	MaxUploadSize       int    `hcl:"max_upload_size" flagName:"max-upload-size" flagDescribe:"Maximum size in MB of an uploaded file, 0 for no limit" default:"0"`
+	UploadChunkSize     int    `hcl:"upload_chunk_size" flagName:"upload-chunk-size" flagDescribe:"Size in MB of the chunks large files are uploaded in" default:"5"`
+	UploadExpiration    int    `hcl:"upload_expiration" flagName:"upload-expiration" flagDescribe:"Hours unfinished uploads are kept after their last chunk (0 to keep them forever)" default:"24"`
+	RecordDir           string `hcl:"record_dir" flagName:"record-dir" flagDescribe:"Record every session as an asciicast file in this directory (default disabled)" default:""`
 	Once                bool   `hcl:"once" flagName:"once" flagDescribe:"Accept only one client and exit on disconnection" default:"false"`
 	Timeout             int    `hcl:"timeout" flagName:"timeout" flagDescribe:"Timeout seconds for waiting a client(0 to disable)" default:"0"`
 	PermitArguments     bool   `hcl:"permit_arguments" flagName:"permit-arguments" flagDescribe:"Permit clients to send command line arguments in URL (e.g. http://example.com:8080/?arg=AAA&arg=BBB)" default:"false"`
@@ -31,15 +68,68 @@ type Options struct {
 	Height              int    `hcl:"height" flagName:"height" flagDescribe:"Static height of the screen, 0(default) means dynamically resize" default:"0"`
 	WSOrigin            string `hcl:"ws_origin" flagName:"ws-origin" flagDescribe:"A regular expression that matches origin URLs to be accepted by WebSocket. No cross origin requests are acceptable by default" default:""`
 	WSQueryArgs         string `hcl:"ws_query_args" flagName:"ws-query-args" flagDescribe:"Querystring arguments to append to the websocket instantiation" default:""`
+	EnableWSCompression bool   `hcl:"enable_ws_compression" flagName:"ws-compression" flagDescribe:"Enable per-message deflate compression of WebSocket frames" default:"false"`
+	Compression         string `hcl:"compression" flagName:"compression" flagDescribe:"Compress terminal output when the client supports it (none, deflate)" default:"none"`
 	EnableWebGL         bool   `hcl:"enable_webgl" flagName:"enable-webgl" flagDescribe:"Enable WebGL renderer" default:"true"`
 	Quiet               bool   `hcl:"quiet" flagName:"quiet" flagDescribe:"Don't log" default:"false"`
 
+	// Users maps user names to password hashes from the hash-password command,
+	// set in the config file only
+	Users map[string]string `hcl:"users"`
+	// TOTPSecrets maps user names to base32 secrets from the totp-enroll command,
+	// these users need a one-time password to log in
+	TOTPSecrets map[string]string `hcl:"totp_secrets"`
+
+	// Roles, Profiles and the role bindings below are set in the config file only
+	Roles    map[string]*Role    `hcl:"roles"`
+	Profiles map[string]*Profile `hcl:"profiles"`
+	// UserRoles and GroupRoles bind roles to user names and groups
+	UserRoles   map[string]string `hcl:"user_roles"`
+	GroupRoles  map[string]string `hcl:"group_roles"`
+	DefaultRole string            `hcl:"default_role" flagName:"default-role" flagDescribe:"Role of users without a role bound to them, see roles in the config file" default:""`
+
+	// FileRoots are the named directories of the file manager replacing file_root,
+	// set in the config file only
+	FileRoots map[string]*FileRoot `hcl:"file_roots"`
+
 	TitleVariables map[string]interface{}
 }
 
 func (options *Options) Validate() error {
+	if options.OIDCIssuer != "" && options.OIDCClientID == "" {
+		return errors.New("OpenID Connect is enabled, but no client ID is set")
+	}
+	if options.AuthProxyHeader != "" && options.AuthTrustedProxies == "" {
+		return errors.New("authentication by a proxy header is enabled, but no trusted proxies are set")
+	}
+	if options.LoginMaxFailures > 0 && (options.LoginLockout <= 0 || options.LoginMaxLockout < options.LoginLockout) {
+		return errors.New("login lockouts must be positive and the maximum lockout must not be shorter than the first one")
+	}
+	if options.UploadChunkSize < 0 || options.MaxUploadSize < 0 {
+		return errors.New("upload sizes must not be negative")
+	}
+	if options.UploadExpiration < 0 {
+		return errors.New("upload expiration must not be negative")
+	}
+	if err := validateRoles(options); err != nil {
+		return err
+	}
+	if options.TLSSelfSigned && options.ACMEDomains != "" {
+		return errors.New("self-signed and ACME certificates can't be enabled at the same time")
+	}
 	if options.EnableTLSClientAuth && !options.EnableTLS {
 		return errors.New("TLS client authentication is enabled, but TLS is not enabled")
 	}
+	if (options.TLSClientIdentity != "" || options.TLSCRLFile != "") && !options.EnableTLSClientAuth {
+		return errors.New("client certificate identities and CRLs require TLS client authentication")
+	}
+	if _, ok := resizePolicies[options.SharedResizePolicy]; (options.EnableSharedSession || options.SessionGracePeriod > 0) && !ok {
+		return errors.Errorf("unknown shared resize policy `%s`", options.SharedResizePolicy)
+	}
+	switch options.Compression {
+	case "", "none", webtty.CompressionDeflate:
+	default:
+		return errors.Errorf("unsupported compression `%s`", options.Compression)
+	}
 	return nil
 }
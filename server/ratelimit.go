@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginLimiter locks out IP addresses and user names after failed logins.
+// Each failure after the first lockout doubles its duration up to maxLockout,
+// failures are forgotten after maxLockout without another one.
+type loginLimiter struct {
+	maxFailures int
+	lockout     time.Duration
+	maxLockout  time.Duration
+
+	mutex    sync.Mutex
+	attempts map[string]*loginAttempts
+	pruned   time.Time
+}
+
+type loginAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// newLoginLimiter returns nil when options disable the limiter.
+func newLoginLimiter(options *Options) *loginLimiter {
+	if options.LoginMaxFailures <= 0 {
+		return nil
+	}
+	return &loginLimiter{
+		maxFailures: options.LoginMaxFailures,
+		lockout:     time.Duration(options.LoginLockout) * time.Second,
+		maxLockout:  time.Duration(options.LoginMaxLockout) * time.Second,
+		attempts:    map[string]*loginAttempts{},
+	}
+}
+
+// retryAfter returns how long the longest lockout of keys lasts, 0 if none is locked out.
+func (limiter *loginLimiter) retryAfter(keys ...string) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, key := range keys {
+		if attempts, ok := limiter.attempts[key]; ok && attempts.lockedUntil.Sub(now) > wait {
+			wait = attempts.lockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// fail records a failed login of keys and locks out the ones with too many failures.
+func (limiter *loginLimiter) fail(keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	limiter.pruneLocked(now)
+	for _, key := range keys {
+		attempts, ok := limiter.attempts[key]
+		if !ok {
+			attempts = &loginAttempts{}
+			limiter.attempts[key] = attempts
+		}
+		attempts.failures++
+		attempts.last = now
+
+		excess := attempts.failures - limiter.maxFailures
+		if excess < 0 {
+			continue
+		}
+		lockout := limiter.maxLockout
+		if excess < 32 && limiter.lockout<<excess < limiter.maxLockout {
+			lockout = limiter.lockout << excess
+		}
+		attempts.lockedUntil = now.Add(lockout)
+		log.Printf("Locked out %s for %s after %d failed logins", key, lockout, attempts.failures)
+	}
+}
+
+// succeed forgets the failures of keys.
+func (limiter *loginLimiter) succeed(keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for _, key := range keys {
+		delete(limiter.attempts, key)
+	}
+}
+
+// pruneLocked forgets old failures, at most once a minute.
+func (limiter *loginLimiter) pruneLocked(now time.Time) {
+	if now.Sub(limiter.pruned) < time.Minute {
+		return
+	}
+	limiter.pruned = now
+	for key, attempts := range limiter.attempts {
+		if now.After(attempts.lockedUntil) && now.Sub(attempts.last) > limiter.maxLockout {
+			delete(limiter.attempts, key)
+		}
+	}
+}
+
+// lockoutError is returned instead of authenticating a client that is locked out.
+type lockoutError struct {
+	retryAfter time.Duration
+}
+
+func (err *lockoutError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry after %s", err.retryAfter.Round(time.Second))
+}
+
+// respondLockout responds with 429 Too Many Requests and Retry-After
+// when err is a lockoutError.
+func respondLockout(w http.ResponseWriter, err error) bool {
+	lockout, ok := err.(*lockoutError)
+	if !ok {
+		return false
+	}
+	seconds := int((lockout.retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed logins, retry later", http.StatusTooManyRequests)
+	return true
+}
+
+// clientIP returns the IP address of the client of r.
+// X-Forwarded-For is only read from trusted proxies, the client is the
+// last address before the trusted ones.
+func (server *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !containsIP(server.trustedProxies, host) {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !containsIP(server.trustedProxies, ip) {
+			break
+		}
+	}
+	return host
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	limiter := newLoginLimiter(&Options{LoginMaxFailures: 2, LoginLockout: 60, LoginMaxLockout: 100})
+
+	limiter.fail("IP 192.0.2.1")
+	if wait := limiter.retryAfter("IP 192.0.2.1"); wait != 0 {
+		t.Fatalf("Locked out after one failure: %s", wait)
+	}
+	limiter.fail("IP 192.0.2.1")
+	if wait := limiter.retryAfter("IP 192.0.2.1", "user alice"); wait <= 50*time.Second || wait > 60*time.Second {
+		t.Fatalf("Unexpected first lockout: %s", wait)
+	}
+	// each further failure doubles the lockout up to the maximum
+	limiter.fail("IP 192.0.2.1")
+	if wait := limiter.retryAfter("IP 192.0.2.1"); wait <= 90*time.Second || wait > 100*time.Second {
+		t.Fatalf("Unexpected second lockout: %s", wait)
+	}
+	limiter.succeed("IP 192.0.2.1")
+	if wait := limiter.retryAfter("IP 192.0.2.1"); wait != 0 {
+		t.Fatalf("Locked out after success: %s", wait)
+	}
+
+	if limiter := newLoginLimiter(&Options{LoginMaxFailures: 0}); limiter != nil {
+		t.Fatalf("Limiter enabled without failures")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	options := &Options{
+		Users:              map[string]string{"alice": "secret"},
+		AuthTrustedProxies: "10.0.0.1",
+		LoginMaxFailures:   1,
+		LoginLockout:       60,
+		LoginMaxLockout:    60,
+	}
+	authenticator, err := newAuthenticator(options, nil, newTokenStore(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error from newAuthenticator(): %s", err)
+	}
+	trustedProxies, _ := parseNetworks(options.AuthTrustedProxies)
+	server := &Server{
+		options:        options,
+		authenticator:  authenticator,
+		limiter:        newLoginLimiter(options),
+		trustedProxies: trustedProxies,
+	}
+
+	request := func(remote string, forwarded string, password string) *http.Request {
+		r := httptest.NewRequest("GET", "/api/files", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", forwarded)
+		r.SetBasicAuth("alice", password)
+		return r
+	}
+
+	// X-Forwarded-For is ignored from untrusted clients
+	if ip := server.clientIP(request("192.0.2.1:1234", "198.51.100.1", "")); ip != "192.0.2.1" {
+		t.Fatalf("Unexpected IP of an untrusted client: %s", ip)
+	}
+	if ip := server.clientIP(request("10.0.0.1:1234", "192.0.2.2, 198.51.100.1, 10.0.0.1", "")); ip != "198.51.100.1" {
+		t.Fatalf("Unexpected IP of a client of a trusted proxy: %s", ip)
+	}
+
+	if _, err := server.authenticate(request("10.0.0.1:1234", "198.51.100.1", "wrong")); err != ErrUnauthenticated {
+		t.Fatalf("Unexpected error from authenticate(): %v", err)
+	}
+	w := httptest.NewRecorder()
+	server.wrapAuth(http.NotFoundHandler(), "/").ServeHTTP(w, request("10.0.0.1:1234", "198.51.100.1", "secret"))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Unexpected response to a locked out client: %d, Retry-After: %s", w.Code, w.Header().Get("Retry-After"))
+	}
+}
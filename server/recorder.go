@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/asciicast"
+	"gotty/pkg/homedir"
+)
+
+const (
+	defaultRecordColumns = 80
+	defaultRecordRows    = 24
+)
+
+// recordingSlave is a Slave that records its streams to an asciicast file.
+type recordingSlave struct {
+	Slave
+
+	file   *os.File
+	header asciicast.Header
+
+	mutex sync.Mutex
+	// writer is created with the first event, so that the header
+	// can carry the terminal size sent by the client right after connecting
+	writer *asciicast.Writer
+	// err is the first failure of the recording, it ends the session
+	err error
+}
+
+// newRecordingSlave creates a recording for a connection from remoteAddr in dir,
+// env describes the user in the header of the recording.
+func newRecordingSlave(slave Slave, dir string, remoteAddr string, env map[string]string) (*recordingSlave, error) {
+	dir = homedir.Expand(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create record directory `%s`", dir)
+	}
+
+	name := fmt.Sprintf(
+		"%s_%s.cast",
+		time.Now().UTC().Format("20060102T150405Z"),
+		strings.NewReplacer(":", "_", "[", "", "]", "", "/", "_").Replace(remoteAddr),
+	)
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create record file `%s`", path)
+	}
+
+	vars := slave.WindowTitleVariables()
+	command := fmt.Sprint(vars["command"])
+	if argv, ok := vars["argv"].([]string); ok && len(argv) > 0 {
+		command += " " + strings.Join(argv, " ")
+	}
+
+	header := asciicast.Header{
+		Width:   defaultRecordColumns,
+		Height:  defaultRecordRows,
+		Command: command,
+		Env: map[string]string{
+			"TERM":  "xterm-256color",
+			"SHELL": os.Getenv("SHELL"),
+		},
+	}
+	for key, value := range env {
+		header.Env[key] = value
+	}
+
+	return &recordingSlave{
+		Slave:  slave,
+		file:   file,
+		header: header,
+	}, nil
+}
+
+func (rs *recordingSlave) Read(p []byte) (n int, err error) {
+	n, err = rs.Slave.Read(p)
+	if n > 0 {
+		if recErr := rs.record(asciicast.Output, p[:n]); recErr != nil {
+			return 0, recErr
+		}
+	}
+	return n, err
+}
+
+func (rs *recordingSlave) Write(p []byte) (n int, err error) {
+	if err := rs.failed(); err != nil {
+		return 0, err
+	}
+	n, err = rs.Slave.Write(p)
+	if n > 0 {
+		if recErr := rs.record(asciicast.Input, p[:n]); recErr != nil {
+			return n, recErr
+		}
+	}
+	return n, err
+}
+
+func (rs *recordingSlave) ResizeTerminal(columns int, rows int) error {
+	rs.mutex.Lock()
+	if rs.writer == nil && rs.err == nil {
+		rs.header.Width, rs.header.Height = columns, rows
+		rs.mutex.Unlock()
+	} else {
+		rs.mutex.Unlock()
+		if err := rs.record(asciicast.Resize, []byte(fmt.Sprintf("%dx%d", columns, rows))); err != nil {
+			return err
+		}
+	}
+	return rs.Slave.ResizeTerminal(columns, rows)
+}
+
+func (rs *recordingSlave) Close() error {
+	err := rs.Slave.Close()
+	if closeErr := rs.file.Close(); closeErr != nil && rs.failed() == nil {
+		log.Printf("Failed to close record file %s: %v", rs.file.Name(), closeErr)
+	}
+	return err
+}
+
+// failed returns the first failure of the recording.
+func (rs *recordingSlave) failed() error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.err
+}
+
+// record writes an event. The first failure is logged and returned from then on,
+// a session isn't kept running without its recording.
+func (rs *recordingSlave) record(eventType string, data []byte) error {
+	rs.mutex.Lock()
+	if rs.err != nil {
+		rs.mutex.Unlock()
+		return rs.err
+	}
+	if rs.writer == nil {
+		writer, err := asciicast.NewWriter(rs.file, rs.header)
+		if err != nil {
+			rs.fail(errors.Wrapf(err, "failed to write record header to %s", rs.file.Name()))
+			rs.mutex.Unlock()
+			return rs.err
+		}
+		rs.writer = writer
+	}
+	writer := rs.writer
+	rs.mutex.Unlock()
+
+	if err := writer.WriteEvent(eventType, data); err != nil {
+		rs.mutex.Lock()
+		defer rs.mutex.Unlock()
+		if rs.err == nil {
+			rs.fail(errors.Wrapf(err, "failed to write record to %s", rs.file.Name()))
+		}
+		return rs.err
+	}
+	return nil
+}
+
+// fail records and logs err, rs.mutex must be held.
+func (rs *recordingSlave) fail(err error) {
+	rs.err = err
+	log.Printf("Recording failed, closing the session: %v", err)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotty/pkg/asciicast"
+)
+
+func TestRecordingSlave(t *testing.T) {
+	dir := t.TempDir()
+	slave := newTestSlave()
+	recorder, err := newRecordingSlave(slave, dir, "[::1]:1234", map[string]string{"USER": "alice"})
+	if err != nil {
+		t.Fatalf("Unexpected error from newRecordingSlave(): %s", err)
+	}
+	if filepath.Dir(recorder.file.Name()) != dir || filepath.Ext(recorder.file.Name()) != ".cast" {
+		t.Fatalf("Unexpected record file: %s", recorder.file.Name())
+	}
+
+	// the size sent before the first event goes into the header
+	recorder.ResizeTerminal(100, 30)
+	go slave.output.Write([]byte("$ "))
+	buffer := make([]byte, 16)
+	if n, err := recorder.Read(buffer); err != nil || string(buffer[:n]) != "$ " {
+		t.Fatalf("Unexpected result of Read(): %q %v", buffer[:n], err)
+	}
+	if _, err := recorder.Write([]byte("ls\r")); err != nil || string(slave.input) != "ls\r" {
+		t.Fatalf("Unexpected result of Write(): %q %v", slave.input, err)
+	}
+	if err := recorder.ResizeTerminal(120, 40); err != nil || slave.size != [2]int{120, 40} {
+		t.Fatalf("Unexpected result of ResizeTerminal(): %v %v", slave.size, err)
+	}
+	recorder.Close()
+
+	file, err := os.Open(recorder.file.Name())
+	if err != nil {
+		t.Fatalf("Unexpected error opening the recording: %s", err)
+	}
+	defer file.Close()
+	reader, err := asciicast.NewReader(file)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewReader(): %s", err)
+	}
+	if header := reader.Header; header.Version != 2 || header.Width != 100 || header.Height != 30 || header.Env["USER"] != "alice" || header.Env["TERM"] == "" {
+		t.Fatalf("Unexpected header: %+v", header)
+	}
+	for _, expected := range []asciicast.Event{{Type: "o", Data: "$ "}, {Type: "i", Data: "ls\r"}, {Type: "r", Data: "120x40"}} {
+		event, err := reader.Next()
+		if err != nil || event.Type != expected.Type || event.Data != expected.Data {
+			t.Fatalf("Unexpected event %+v instead of %+v: %v", event, expected, err)
+		}
+	}
+	if event, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Unexpected event at the end of the recording: %+v %v", event, err)
+	}
+}
+
+func TestRecordingSlaveFailure(t *testing.T) {
+	slave := newTestSlave()
+	recorder, err := newRecordingSlave(slave, t.TempDir(), "127.0.0.1:1234", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from newRecordingSlave(): %s", err)
+	}
+	defer recorder.Close()
+	recorder.file.Close()
+
+	// the session ends with the recording
+	if _, err := recorder.Write([]byte("ls\r")); err == nil {
+		t.Fatalf("Write() succeeded without recording")
+	}
+	if _, err := recorder.Write([]byte("rm -rf /\r")); err == nil || string(slave.input) != "ls\r" {
+		t.Fatalf("Input passed to the slave after the recording failed: %q %v", slave.input, err)
+	}
+	if err := recorder.ResizeTerminal(120, 40); err == nil || slave.size != [2]int{} {
+		t.Fatalf("Unexpected result of ResizeTerminal() after the recording failed: %v %v", slave.size, err)
+	}
+	go slave.output.Write([]byte("$ "))
+	if n, err := recorder.Read(make([]byte, 16)); err == nil || n != 0 {
+		t.Fatalf("Output passed after the recording failed: %d %v", n, err)
+	}
+}
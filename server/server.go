@@ -2,8 +2,6 @@ package server
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"html/template"
 	"io/fs"
 	"log"
@@ -34,6 +32,27 @@ type Server struct {
 	indexTemplate    *template.Template
 	titleTemplate    *noesctmpl.Template
 	manifestTemplate *template.Template
+
+	// authenticator identifies users, nil when authentication is disabled
+	authenticator Authenticator
+	// login is the OpenID Connect login, nil when disabled
+	login *oidcAuthenticator
+	// tokens are the sessions of users logged in with a password
+	tokens *tokenStore
+	shares *shareRegistry
+	// fileRoots are the directories of the file manager, sorted by name
+	fileRoots []*fileRoot
+	// uploads are the tus uploads in progress
+	uploads *tusStore
+	// certs are the TLS files in use, nil without TLS
+	certs *certReloader
+	// limiter throttles failed logins, nil when disabled
+	limiter        *loginLimiter
+	trustedProxies []*net.IPNet
+
+	// sessions keeps slaves beyond a single connection,
+	// nil unless shared sessions or session persistence is enabled
+	sessions *sessionRegistry
 }
 
 // New creates a new instance of Server.
@@ -80,19 +99,75 @@ func New(factory Factory, options *Options) (*Server, error) {
 		}
 	}
 
+	var login *oidcAuthenticator
+	if options.OIDCIssuer != "" {
+		login, err = newOIDCAuthenticator(context.Background(), options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	trustedProxies, err := parseNetworks(options.AuthTrustedProxies)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse trusted proxies")
+	}
+	tokens := newTokenStore(time.Duration(options.SessionMaxAge) * time.Second)
+	authenticator, err := newAuthenticator(options, login, tokens)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to setup authentication")
+	}
+
+	shares, err := newShareRegistry(options.ShareSecret, options.ShareStateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fileRoots, err := newFileRoots(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs *certReloader
+	if options.EnableTLS {
+		certs = newCertReloader(options)
+	}
+
+	var sessions *sessionRegistry
+	if options.EnableSharedSession || options.SessionGracePeriod > 0 {
+		sessions, err = newSessionRegistry(
+			options.EnableSharedSession,
+			options.SharedResizePolicy,
+			options.ScrollbackSize,
+			time.Duration(options.SessionGracePeriod)*time.Second,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Server{
 		factory: factory,
 		options: options,
 
 		upgrader: &websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			Subprotocols:    webtty.Protocols,
-			CheckOrigin:     originChekcer,
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			Subprotocols:      webtty.Protocols,
+			CheckOrigin:       originChekcer,
+			EnableCompression: options.EnableWSCompression,
 		},
 		indexTemplate:    indexTemplate,
 		titleTemplate:    titleTemplate,
 		manifestTemplate: manifestTemplate,
+		authenticator:    authenticator,
+		login:            login,
+		tokens:           tokens,
+		limiter:          newLoginLimiter(options),
+		trustedProxies:   trustedProxies,
+		shares:           shares,
+		fileRoots:        fileRoots,
+		uploads:          newTusStore(time.Duration(options.UploadExpiration) * time.Hour),
+		certs:            certs,
+		sessions:         sessions,
 	}, nil
 }
 
@@ -123,6 +198,13 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to setup an HTTP server")
 	}
+	var challenges http.Handler
+	if server.options.EnableTLS {
+		challenges, err = server.setupTLS(srv)
+		if err != nil {
+			return errors.Wrapf(err, "failed to setup TLS")
+		}
+	}
 
 	if server.options.PermitWrite {
 		log.Printf("Permitting clients to write input to the PTY.")
@@ -130,6 +212,15 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	if server.options.Once {
 		log.Printf("Once option is provided, accepting only one client")
 	}
+	if server.login != nil {
+		log.Printf("Logging in users with OpenID Connect provider %s", server.options.OIDCIssuer)
+	}
+	if server.options.EnableSharedSession {
+		log.Printf("Shared session is enabled, all clients attach to the same command")
+	}
+	if server.options.SessionGracePeriod > 0 {
+		log.Printf("Keeping detached sessions for %d seconds", server.options.SessionGracePeriod)
+	}
 
 	if server.options.Port == "0" {
 		log.Printf("Port number configured to `0`, choosing a random port")
@@ -155,12 +246,8 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	srvErr := make(chan error, 1)
 	go func() {
 		if server.options.EnableTLS {
-			crtFile := homedir.Expand(server.options.TLSCrtFile)
-			keyFile := homedir.Expand(server.options.TLSKeyFile)
-			log.Printf("TLS crt file: " + crtFile)
-			log.Printf("TLS key file: " + keyFile)
-
-			err = srv.ServeTLS(listener, crtFile, keyFile)
+			// certificates are set in the TLS configuration
+			err = srv.ServeTLS(listener, "", "")
 		} else {
 			err = srv.Serve(listener)
 		}
@@ -169,6 +256,23 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 		}
 	}()
 
+	if server.options.EnableTLS && server.options.TLSReloadInterval > 0 {
+		go server.certs.watch(cctx, time.Duration(server.options.TLSReloadInterval)*time.Second)
+	}
+	if server.options.UploadExpiration > 0 {
+		go server.collectUploads(cctx)
+	}
+	if challenges != nil {
+		challengeSrv := &http.Server{Addr: server.options.ACMEHTTPAddress, Handler: challenges}
+		log.Printf("Answering ACME HTTP-01 challenges at %s", server.options.ACMEHTTPAddress)
+		go func() {
+			if err := challengeSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("ACME HTTP-01 server stopped: %v", err)
+			}
+		}()
+		defer challengeSrv.Close()
+	}
+
 	go func() {
 		select {
 		case <-opts.gracefullCtx.Done():
@@ -195,6 +299,10 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	}
 	counter.wait()
 
+	if server.sessions != nil {
+		server.sessions.close()
+	}
+
 	return err
 }
 
@@ -219,27 +327,51 @@ func (server *Server) setupHandlers(ctx context.Context, cancel context.CancelFu
 
 	// Authentication endpoint (not protected by basic auth)
 	siteMux.HandleFunc(pathPrefix+"api/auth/verify", server.handleAuthVerify)
+	siteMux.HandleFunc(pathPrefix+"api/auth/login", server.handleAuthLogin)
+	siteMux.HandleFunc(pathPrefix+"api/auth/logout", server.handleAuthLogout)
+	if server.login != nil {
+		siteMux.HandleFunc(pathPrefix+"login", server.login.handleLogin)
+		siteMux.HandleFunc(pathPrefix+"callback", server.login.handleCallback)
+		siteMux.HandleFunc(pathPrefix+"logout", server.login.handleLogout)
+	}
 
 	// File management endpoints
 	siteMux.HandleFunc(pathPrefix+"api/upload", server.handleFileUpload)
 	siteMux.HandleFunc(pathPrefix+"api/upload-chunk", server.handleChunkUpload)
+	siteMux.HandleFunc(pathPrefix+"api/tus", server.handleTusCreation)
+	siteMux.HandleFunc(pathPrefix+"api/tus/", server.handleTusUpload)
 	siteMux.HandleFunc(pathPrefix+"api/download", server.handleFileDownload)
 	siteMux.HandleFunc(pathPrefix+"api/batch-download", server.handleBatchDownload)
 	siteMux.HandleFunc(pathPrefix+"api/files", server.handleFileList)
+	siteMux.HandleFunc(pathPrefix+"api/roots", server.handleFileRoots)
 	siteMux.HandleFunc(pathPrefix+"api/delete", server.handleFileDelete)
+	siteMux.HandleFunc(pathPrefix+"api/mkdir", server.handleFileOp("POST", true, fileMkdir, CapUpload))
+	siteMux.HandleFunc(pathPrefix+"api/rename", server.handleFileOp("POST", true, server.fileRename, CapUpload))
+	siteMux.HandleFunc(pathPrefix+"api/copy", server.handleFileOp("POST", true, server.fileCopy, CapUpload))
+	siteMux.HandleFunc(pathPrefix+"api/chmod", server.handleFileOp("POST", true, fileChmod, CapUpload))
+	siteMux.HandleFunc(pathPrefix+"api/stat", server.handleFileOp("GET", false, fileStat, CapDownload, CapUpload, CapDelete))
+	siteMux.HandleFunc(pathPrefix+"api/share", server.handleShare)
 
 	siteHandler := http.Handler(siteMux)
 
-	if server.options.EnableBasicAuth {
-		log.Printf("Using Basic Authentication")
-		siteHandler = server.wrapBasicAuth(siteHandler, server.options.Credential)
+	if server.authenticator != nil {
+		log.Printf("Using Authentication")
+		siteHandler = server.wrapAuth(siteHandler, pathPrefix)
 	}
 
 	withGz := gziphandler.GzipHandler(server.wrapHeaders(siteHandler))
 	siteHandler = server.wrapLogger(withGz)
 
+	// WebDAV isn't gzipped, clients read ranges of files
+	davHandler := server.newWebDAVHandler(pathPrefix)
+	if server.authenticator != nil {
+		davHandler = server.wrapAuth(davHandler, pathPrefix)
+	}
+	davHandler = server.wrapLogger(server.wrapHeaders(davHandler))
+
 	wsMux := http.NewServeMux()
 	wsMux.Handle("/", siteHandler)
+	wsMux.Handle(pathPrefix+"webdav/", davHandler)
 	wsMux.HandleFunc(pathPrefix+"ws", server.generateHandleWS(ctx, cancel, counter))
 	siteHandler = http.Handler(wsMux)
 
@@ -251,30 +383,14 @@ func (server *Server) setupHTTPServer(handler http.Handler) (*http.Server, error
 		Handler: handler,
 	}
 
-	if server.options.EnableTLSClientAuth {
-		tlsConfig, err := server.tlsConfig()
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to setup TLS configuration")
-		}
-		srv.TLSConfig = tlsConfig
-	}
-
 	return srv, nil
 }
 
-func (server *Server) tlsConfig() (*tls.Config, error) {
-	caFile := homedir.Expand(server.options.TLSCACrtFile)
-	caCert, err := os.ReadFile(caFile)
-	if err != nil {
-		return nil, errors.New("could not open CA crt file " + caFile)
-	}
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		return nil, errors.New("could not parse CA crt file data in " + caFile)
-	}
-	tlsConfig := &tls.Config{
-		ClientCAs:  caCertPool,
-		ClientAuth: tls.RequireAndVerifyClientCert,
+// ReloadTLS reloads the TLS certificate and CA files, the ones in use are
+// kept if the new ones are invalid.
+func (server *Server) ReloadTLS() error {
+	if server.certs == nil {
+		return nil
 	}
-	return tlsConfig, nil
+	return server.certs.reload(true)
 }
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/randomstring"
+	"gotty/webtty"
+)
+
+const (
+	// sharedSessionID is the ID of the session every client joins in shared mode
+	sharedSessionID = "shared"
+	sessionIDLength = 32
+)
+
+// errSharedProfile refuses clients asking for another profile than the shared session runs.
+var errSharedProfile = errors.New("the shared session runs another profile")
+
+var resizePolicies = map[string]webtty.ResizePolicy{
+	"owner":    webtty.ResizeOwner,
+	"latest":   webtty.ResizeLatest,
+	"smallest": webtty.ResizeSmallest,
+}
+
+// session is a slave that can be shared by clients and outlive their connections.
+type session struct {
+	id          string
+	slave       Slave
+	broadcaster *webtty.Broadcaster
+	// user and profile are the name of the user who spawned the session,
+	// empty without authentication, and the profile it runs
+	user    string
+	profile string
+	// graceTimer closes the session when no client reattaches in time
+	graceTimer *time.Timer
+}
+
+// sessionRegistry keeps sessions keyed by their ID.
+// A session is spawned by the first client and closed when its last client leaves,
+// after the grace period if one is configured.
+type sessionRegistry struct {
+	// shared makes every client join the same session
+	shared      bool
+	policy      webtty.ResizePolicy
+	scrollback  int
+	gracePeriod time.Duration
+
+	mutex    sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionRegistry(shared bool, policy string, scrollback int, gracePeriod time.Duration) (*sessionRegistry, error) {
+	resizePolicy, ok := resizePolicies[policy]
+	if !ok {
+		return nil, errors.Errorf("unknown resize policy `%s`", policy)
+	}
+	return &sessionRegistry{
+		shared:      shared,
+		policy:      resizePolicy,
+		scrollback:  scrollback,
+		gracePeriod: gracePeriod,
+		sessions:    map[string]*session{},
+	}, nil
+}
+
+// attach returns a viewer of the session with the given ID,
+// or of the shared session in shared mode.
+// Only the user who spawned a session can reattach to it, and only with the same profile.
+// When there is no such session, a new one is spawned with newSlave and a fresh ID,
+// so that clients can never choose the ID of a new session.
+// owner is true when no other client is attached to the session.
+func (registry *sessionRegistry) attach(id string, user string, profile string, newSlave func() (Slave, error)) (viewer *sessionViewer, owner bool, err error) {
+	if registry.shared {
+		id = sharedSessionID
+	}
+
+	registry.mutex.Lock()
+	if sess := registry.lookupLocked(id); sess != nil {
+		if registry.joinable(sess, user, profile) {
+			defer registry.mutex.Unlock()
+			return registry.attachLocked(sess)
+		}
+		if registry.shared {
+			registry.mutex.Unlock()
+			return nil, false, errSharedProfile
+		}
+		log.Printf("Refused reattaching %s to a session of another user or profile", user)
+	}
+	registry.mutex.Unlock()
+
+	// spawning may be slow, don't block other clients
+	slave, err := newSlave()
+	if err != nil {
+		return nil, false, err
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.shared {
+		if sess := registry.lookupLocked(id); sess != nil {
+			// another client spawned the shared session meanwhile
+			go slave.Close()
+			if !registry.joinable(sess, user, profile) {
+				return nil, false, errSharedProfile
+			}
+			return registry.attachLocked(sess)
+		}
+	} else {
+		id = randomstring.Generate(sessionIDLength)
+	}
+	sess := &session{
+		id:          id,
+		slave:       slave,
+		broadcaster: webtty.NewBroadcaster(slave, registry.policy, webtty.WithScrollback(registry.scrollback)),
+		user:        user,
+		profile:     profile,
+	}
+	registry.sessions[id] = sess
+	return registry.attachLocked(sess)
+}
+
+// joinable reports whether a client of user asking for profile may attach to sess.
+// In shared mode every client joins the session of the first one.
+func (registry *sessionRegistry) joinable(sess *session, user string, profile string) bool {
+	return sess.profile == profile && (registry.shared || sess.user == user)
+}
+
+// lookupLocked returns the live session with the given ID, nil if there is none.
+func (registry *sessionRegistry) lookupLocked(id string) *session {
+	sess, ok := registry.sessions[id]
+	if !ok {
+		return nil
+	}
+	select {
+	case <-sess.broadcaster.Done():
+		// the command has exited, remaining viewers are still draining output
+		registry.removeLocked(sess)
+		go sess.slave.Close()
+		return nil
+	default:
+		return sess
+	}
+}
+
+func (registry *sessionRegistry) attachLocked(sess *session) (*sessionViewer, bool, error) {
+	if sess.graceTimer != nil {
+		sess.graceTimer.Stop()
+		sess.graceTimer = nil
+	}
+
+	owner := sess.broadcaster.Viewers() == 0
+	v, err := sess.broadcaster.Attach()
+	if err != nil {
+		return nil, false, err
+	}
+	return &sessionViewer{Viewer: v, registry: registry, session: sess}, owner, nil
+}
+
+func (registry *sessionRegistry) detach(viewer *sessionViewer) error {
+	registry.mutex.Lock()
+	viewer.Viewer.Close()
+	sess := viewer.session
+	if registry.sessions[sess.id] != sess || sess.broadcaster.Viewers() > 0 {
+		registry.mutex.Unlock()
+		return nil
+	}
+
+	select {
+	case <-sess.broadcaster.Done():
+	default:
+		if registry.gracePeriod > 0 {
+			log.Printf("Session detached, waiting %s for a client to reattach", registry.gracePeriod)
+			sess.graceTimer = time.AfterFunc(registry.gracePeriod, func() {
+				registry.expire(sess)
+			})
+			registry.mutex.Unlock()
+			return nil
+		}
+	}
+
+	registry.removeLocked(sess)
+	registry.mutex.Unlock()
+
+	// closing may wait for the command to exit, don't block other clients
+	return sess.slave.Close()
+}
+
+func (registry *sessionRegistry) expire(sess *session) {
+	registry.mutex.Lock()
+	if registry.sessions[sess.id] != sess || sess.broadcaster.Viewers() > 0 {
+		registry.mutex.Unlock()
+		return
+	}
+	registry.removeLocked(sess)
+	registry.mutex.Unlock()
+
+	log.Printf("Session expired without a client reattaching")
+	sess.slave.Close()
+}
+
+// removeLocked unregisters the session, the caller must close its slave.
+func (registry *sessionRegistry) removeLocked(sess *session) {
+	if sess.graceTimer != nil {
+		sess.graceTimer.Stop()
+		sess.graceTimer = nil
+	}
+	delete(registry.sessions, sess.id)
+}
+
+// close closes every session regardless of attached clients.
+func (registry *sessionRegistry) close() {
+	registry.mutex.Lock()
+	sessions := make([]*session, 0, len(registry.sessions))
+	for _, sess := range registry.sessions {
+		registry.removeLocked(sess)
+		sessions = append(sessions, sess)
+	}
+	registry.mutex.Unlock()
+
+	for _, sess := range sessions {
+		sess.slave.Close()
+	}
+}
+
+// sessionViewer is a Slave for a single client of a session.
+type sessionViewer struct {
+	*webtty.Viewer
+	registry *sessionRegistry
+	session  *session
+}
+
+func (viewer *sessionViewer) Close() error {
+	return viewer.registry.detach(viewer)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// testSlave is a Slave whose output is written to output, until it is closed.
+type testSlave struct {
+	*io.PipeReader
+	output *io.PipeWriter
+	input  []byte
+	size   [2]int
+}
+
+func newTestSlave() *testSlave {
+	reader, writer := io.Pipe()
+	return &testSlave{PipeReader: reader, output: writer}
+}
+
+func (slave *testSlave) Write(p []byte) (int, error) {
+	slave.input = append(slave.input, p...)
+	return len(p), nil
+}
+
+func (slave *testSlave) WindowTitleVariables() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (slave *testSlave) ResizeTerminal(columns int, rows int) error {
+	slave.size = [2]int{columns, rows}
+	return nil
+}
+
+func (slave *testSlave) Close() error {
+	return slave.output.Close()
+}
+
+func spawnTestSlave() (Slave, error) {
+	return newTestSlave(), nil
+}
+
+func TestSessionReattach(t *testing.T) {
+	registry, err := newSessionRegistry(false, "owner", 1024, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error from newSessionRegistry(): %s", err)
+	}
+	defer registry.close()
+
+	viewer, owner, err := registry.attach("", "alice", "", spawnTestSlave)
+	if err != nil || !owner {
+		t.Fatalf("Unexpected result of attach(): %v %v", owner, err)
+	}
+	id := viewer.session.id
+	viewer.Close()
+
+	// other users and profiles get a session of their own
+	for _, client := range [][2]string{{"bob", ""}, {"share:abc", ""}, {"alice", "admin"}} {
+		other, _, err := registry.attach(id, client[0], client[1], spawnTestSlave)
+		if err != nil {
+			t.Fatalf("Unexpected error from attach(): %s", err)
+		}
+		if other.session.id == id {
+			t.Fatalf("%s attached to a session of alice with profile %q", client[0], client[1])
+		}
+		other.Close()
+	}
+
+	again, owner, err := registry.attach(id, "alice", "", spawnTestSlave)
+	if err != nil || !owner || again.session.id != id {
+		t.Fatalf("Unexpected result of reattaching: %v %v", owner, err)
+	}
+	again.Close()
+}
+
+func TestSharedSessionProfile(t *testing.T) {
+	registry, _ := newSessionRegistry(true, "owner", 0, 0)
+	defer registry.close()
+
+	first, _, err := registry.attach("", "alice", "", spawnTestSlave)
+	if err != nil {
+		t.Fatalf("Unexpected error from attach(): %s", err)
+	}
+	defer first.Close()
+	second, owner, err := registry.attach("", "bob", "", spawnTestSlave)
+	if err != nil || owner || second.session != first.session {
+		t.Fatalf("Unexpected result of joining the shared session: %v %v", owner, err)
+	}
+	defer second.Close()
+	if _, _, err := registry.attach("", "bob", "admin", spawnTestSlave); err != errSharedProfile {
+		t.Fatalf("Unexpected error joining the shared session with another profile: %v", err)
+	}
+}
+
+func TestSessionSpawnUnlocked(t *testing.T) {
+	registry, _ := newSessionRegistry(false, "owner", 0, 0)
+	defer registry.close()
+
+	// a slow spawn doesn't block other clients
+	spawning := make(chan struct{})
+	release := make(chan struct{})
+	go registry.attach("", "alice", "", func() (Slave, error) {
+		close(spawning)
+		<-release
+		return newTestSlave(), nil
+	})
+	<-spawning
+	defer close(release)
+
+	done := make(chan error)
+	go func() {
+		viewer, _, err := registry.attach("", "bob", "", spawnTestSlave)
+		if err == nil {
+			viewer.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error from attach(): %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("attach() blocked by a slow spawn")
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/homedir"
+	"gotty/pkg/sharelink"
+)
+
+const defaultShareTTL = 30 * time.Minute
+
+// shareRegistry verifies share links and remembers the created, used and revoked ones.
+// Terminal links can be used once, file links until they expire.
+type shareRegistry struct {
+	secret []byte
+	// path is the file keeping the links across restarts, empty to keep them in memory
+	path string
+
+	mutex sync.Mutex
+	links map[string]*shareRecord
+}
+
+// shareRecord is what the registry knows about a link.
+type shareRecord struct {
+	// Creator is the user who created the link on this server,
+	// empty for links created with the share command
+	Creator string `json:"creator,omitempty"`
+	// Expires is the expiry of the link, 0 for a revoked link never seen
+	Expires int64 `json:"expires"`
+	Used    bool  `json:"used,omitempty"`
+	Revoked bool  `json:"revoked,omitempty"`
+}
+
+var (
+	errShareRevoked = errors.New("share link has been revoked")
+	errShareUsed    = errors.New("share link has already been used")
+	errShareOwner   = errors.New("share link has been created by another user")
+)
+
+// newShareRegistry uses a random secret when secret is empty,
+// links then end when gotty restarts. Otherwise the links are kept in
+// the file at path, if any, so that used and revoked links stay so.
+func newShareRegistry(secret string, path string) (*shareRegistry, error) {
+	registry := &shareRegistry{
+		secret: []byte(secret),
+		links:  map[string]*shareRecord{},
+	}
+	if secret == "" {
+		registry.secret = make([]byte, 32)
+		if _, err := rand.Read(registry.secret); err != nil {
+			return nil, err
+		}
+		return registry, nil
+	}
+
+	if path == "" {
+		return registry, nil
+	}
+	registry.path = homedir.Expand(path)
+	data, err := os.ReadFile(registry.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return registry, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read share links from `%s`", registry.path)
+	}
+	if err := json.Unmarshal(data, &registry.links); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse share links in `%s`", registry.path)
+	}
+	return registry, nil
+}
+
+// create records that link has been created by creator.
+func (registry *shareRegistry) create(link *sharelink.Link, creator string) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.links[link.ID] = &shareRecord{Creator: creator, Expires: link.Expires}
+	return registry.saveLocked()
+}
+
+// use returns the identity of a client with a share link of kind.
+func (registry *shareRegistry) use(token string, kind string) (*Identity, error) {
+	link, err := sharelink.Verify(registry.secret, token)
+	if err != nil {
+		return nil, err
+	}
+	if link.Kind != kind {
+		return nil, sharelink.ErrInvalid
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	record, ok := registry.links[link.ID]
+	if !ok {
+		// created with the share command
+		record = &shareRecord{Expires: link.Expires}
+		registry.links[link.ID] = record
+	}
+	if record.Revoked {
+		return nil, errShareRevoked
+	}
+	if link.Kind == sharelink.Terminal {
+		if record.Used {
+			return nil, errShareUsed
+		}
+		record.Used = true
+		if err := registry.saveLocked(); err != nil {
+			log.Printf("Failed to save the use of share link %s: %v", link.ID, err)
+		}
+	}
+
+	return &Identity{Name: "share:" + link.ID, Provider: "share", Share: link}, nil
+}
+
+// revoke revokes the link id on behalf of user,
+// which must have created it unless admin is true.
+func (registry *shareRegistry) revoke(id string, user string, admin bool) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	record, ok := registry.links[id]
+	if !admin && (!ok || record.Creator == "" || record.Creator != user) {
+		return errShareOwner
+	}
+	if !ok {
+		record = &shareRecord{}
+		registry.links[id] = record
+	}
+	record.Revoked = true
+	return registry.saveLocked()
+}
+
+// saveLocked forgets expired links and writes the other ones to the file of the registry.
+// registry.mutex must be held.
+func (registry *shareRegistry) saveLocked() error {
+	now := time.Now().Unix()
+	for id, record := range registry.links {
+		if record.Expires != 0 && record.Expires < now {
+			delete(registry.links, id)
+		}
+	}
+	if registry.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(registry.links)
+	if err != nil {
+		return err
+	}
+	// replace the file at once, a crash never leaves it half written
+	temp := registry.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write share links to `%s`", temp)
+	}
+	if err := os.Rename(temp, registry.path); err != nil {
+		os.Remove(temp)
+		return errors.Wrapf(err, "failed to write share links to `%s`", registry.path)
+	}
+	return nil
+}
+
+// shareRequest is the body of a request creating a share link.
+type shareRequest struct {
+	Kind       string `json:"kind"`
+	Permission string `json:"permission"`
+	File       string `json:"file"`
+	// Root is the file manager root of File, empty for the default one
+	Root string `json:"root"`
+	// TTL is the lifetime of the link in seconds
+	TTL int `json:"ttl"`
+}
+
+// handleShare creates share links with POST and revokes them with DELETE ?id=.
+// Users can only share what they can access themselves.
+func (server *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+	case "DELETE":
+		if !server.authorize(w, r, CapView, CapDownload) {
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "ID is required", http.StatusBadRequest)
+			return
+		}
+		identity := identityFromRequest(r)
+		user := ""
+		if identity != nil && identity.Share == nil {
+			user = identity.Name
+		}
+		if err := server.shares.revoke(id, user, server.permissions(identity).can(CapAdmin)); err != nil {
+			if err == errShareOwner {
+				log.Printf("Denied revoking share link %s to %s (%s)", id, r.RemoteAddr, user)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to revoke share link %s: %v", id, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Share link %s revoked by %s (%s)", id, r.RemoteAddr, user)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultShareTTL
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+
+	required := CapDownload
+	if req.Kind == sharelink.Terminal {
+		if req.Permission == "" {
+			req.Permission = sharelink.View
+		}
+		required = CapView
+		if req.Permission == sharelink.Write {
+			required = CapWrite
+		}
+	}
+	if req.Kind == sharelink.File {
+		root := server.fileRootByName(req.Root)
+		if root == nil {
+			http.Error(w, "Unknown root", http.StatusNotFound)
+			return
+		}
+		file, err := root.cleanPath(req.File)
+		if err != nil {
+			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			return
+		}
+		req.File = file
+		dir, err := root.open()
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		info, err := dir.Stat(req.File)
+		dir.Close()
+		if err != nil || !info.Mode().IsRegular() {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+	}
+	if !server.authorize(w, r, required) {
+		return
+	}
+
+	link, err := sharelink.New(req.Kind, req.Permission, req.File, ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Kind == sharelink.File {
+		link.Root = req.Root
+	}
+	creator := ""
+	if identity := identityFromRequest(r); identity != nil && identity.Share == nil {
+		creator = identity.Name
+	}
+	token, err := sharelink.Sign(server.shares.secret, link)
+	if err == nil {
+		err = server.shares.create(link, creator)
+	}
+	if err != nil {
+		log.Printf("Failed to create share link: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := (&url.URL{Scheme: scheme, Host: r.Host, Path: strings.TrimSuffix(r.URL.Path, "api/share")}).String()
+	log.Printf("Share link %s (%s) created by %s", link.ID, link.Kind, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      link.ID,
+		"url":     sharelink.URL(base, link, token),
+		"expires": link.ExpiresAt(),
+	})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotty/pkg/sharelink"
+)
+
+func TestShareRegistry(t *testing.T) {
+	registry, err := newShareRegistry("secret", "")
+	if err != nil {
+		t.Fatalf("Unexpected error from newShareRegistry(): %s", err)
+	}
+	sign := func(kind, permission, file string, ttl time.Duration) (*sharelink.Link, string) {
+		link, err := sharelink.New(kind, permission, file, ttl)
+		if err != nil {
+			t.Fatalf("Unexpected error from New(): %s", err)
+		}
+		token, err := sharelink.Sign([]byte("secret"), link)
+		if err != nil {
+			t.Fatalf("Unexpected error from Sign(): %s", err)
+		}
+		return link, token
+	}
+
+	// terminal links can be used once
+	_, token := sign(sharelink.Terminal, sharelink.View, "", time.Minute)
+	identity, err := registry.use(token, sharelink.Terminal)
+	if err != nil {
+		t.Fatalf("Unexpected error from use(): %s", err)
+	}
+	server := &Server{options: &Options{PermitWrite: true, Roles: map[string]*Role{"dev": {Capabilities: []string{"view", "write"}}}, DefaultRole: "dev"}}
+	perms := server.permissions(identity)
+	if !perms.can(CapView) || perms.can(CapWrite) || perms.can(CapDownload) || perms.canStart("top") {
+		t.Fatalf("Unexpected permissions of a view link: %+v", perms)
+	}
+	if _, err := registry.use(token, sharelink.Terminal); err == nil {
+		t.Fatalf("Terminal link used twice")
+	}
+
+	// file links can be used until they expire or are revoked
+	link, token := sign(sharelink.File, "", "report.pdf", time.Minute)
+	if _, err := registry.use(token, sharelink.Terminal); err == nil {
+		t.Fatalf("File link opened a terminal")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := registry.use(token, sharelink.File); err != nil {
+			t.Fatalf("Unexpected error from use(): %s", err)
+		}
+	}
+	registry.revoke(link.ID, "", true)
+	if _, err := registry.use(token, sharelink.File); err == nil {
+		t.Fatalf("Revoked link used")
+	}
+
+	link, _ = sign(sharelink.File, "", "report.pdf", time.Minute)
+	link.Expires = time.Now().Add(-time.Minute).Unix()
+	token, _ = sharelink.Sign([]byte("secret"), link)
+	if _, err := registry.use(token, sharelink.File); err != sharelink.ErrExpired {
+		t.Fatalf("Expired link used")
+	}
+
+	other, _ := newShareRegistry("other", "")
+	_, token = sign(sharelink.Terminal, sharelink.Write, "", time.Minute)
+	if _, err := other.use(token, sharelink.Terminal); err != sharelink.ErrInvalid {
+		t.Fatalf("Unexpected error from use() with another secret: %v", err)
+	}
+}
+
+func TestShareRegistryState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares")
+	registry, err := newShareRegistry("secret", path)
+	if err != nil {
+		t.Fatalf("Unexpected error from newShareRegistry(): %s", err)
+	}
+	terminal, _ := sharelink.New(sharelink.Terminal, sharelink.View, "", time.Minute)
+	terminalToken, _ := sharelink.Sign([]byte("secret"), terminal)
+	file, _ := sharelink.New(sharelink.File, "", "report.pdf", time.Minute)
+	fileToken, _ := sharelink.Sign([]byte("secret"), file)
+	if err := registry.create(file, "alice"); err != nil {
+		t.Fatalf("Unexpected error from create(): %s", err)
+	}
+	if _, err := registry.use(terminalToken, sharelink.Terminal); err != nil {
+		t.Fatalf("Unexpected error from use(): %s", err)
+	}
+	if err := registry.revoke(file.ID, "alice", false); err != nil {
+		t.Fatalf("Unexpected error from revoke(): %s", err)
+	}
+
+	// used and revoked links stay so after a restart
+	registry, err = newShareRegistry("secret", path)
+	if err != nil {
+		t.Fatalf("Unexpected error from newShareRegistry(): %s", err)
+	}
+	if _, err := registry.use(terminalToken, sharelink.Terminal); err != errShareUsed {
+		t.Fatalf("Unexpected error from use() of a used link after a restart: %v", err)
+	}
+	if _, err := registry.use(fileToken, sharelink.File); err != errShareRevoked {
+		t.Fatalf("Unexpected error from use() of a revoked link after a restart: %v", err)
+	}
+
+	// expired links are forgotten
+	registry.links[file.ID].Expires = time.Now().Add(-time.Minute).Unix()
+	registry.revoke(terminal.ID, "", true)
+	if _, ok := registry.links[file.ID]; ok {
+		t.Fatalf("Expired link kept")
+	}
+
+	os.WriteFile(path, []byte("{"), 0600)
+	if _, err := newShareRegistry("secret", path); err == nil {
+		t.Fatalf("Expected an error for an invalid state file")
+	}
+	// links of a random secret end with gotty, there is nothing to keep
+	if registry, err := newShareRegistry("", path); err != nil || registry.path != "" {
+		t.Fatalf("Unexpected result of newShareRegistry() with a random secret: %v", err)
+	}
+}
+
+func TestShareRevokeOwner(t *testing.T) {
+	registry, _ := newShareRegistry("secret", "")
+	server := &Server{
+		options: &Options{Roles: map[string]*Role{
+			"dev":   {Capabilities: []string{"view", "download"}},
+			"admin": {Capabilities: []string{"view", "admin"}},
+		}, DefaultRole: "dev", UserRoles: map[string]string{"carol": "admin"}},
+		shares: registry,
+	}
+	request := func(method string, target string, body string, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if user != "" {
+			r = withIdentity(r, &Identity{Name: user})
+		}
+		w := httptest.NewRecorder()
+		server.handleShare(w, r)
+		return w
+	}
+	create := func(user string) string {
+		w := request("POST", "/api/share", `{"kind":"terminal"}`, user)
+		var response struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); w.Code != 200 || err != nil {
+			t.Fatalf("Unexpected response creating a link: %d %v", w.Code, err)
+		}
+		return response.ID
+	}
+
+	id := create("alice")
+	for _, user := range []string{"bob", ""} {
+		if w := request("DELETE", "/api/share?id="+id, "", user); w.Code != 403 {
+			t.Fatalf("Unexpected status of revoking a link of alice by %q: %d", user, w.Code)
+		}
+	}
+	if w := request("DELETE", "/api/share?id="+id, "", "alice"); w.Code != 200 || !registry.links[id].Revoked {
+		t.Fatalf("Unexpected status of revoking a link by its creator: %d", w.Code)
+	}
+
+	// links of anonymous users or the share command can only be revoked by admins
+	id = create("")
+	if w := request("DELETE", "/api/share?id="+id, "", ""); w.Code != 403 {
+		t.Fatalf("Unexpected status of revoking a link of an anonymous user: %d", w.Code)
+	}
+	for _, id := range []string{id, create("alice"), "unknown"} {
+		if w := request("DELETE", "/api/share?id="+id, "", "carol"); w.Code != 200 || !registry.links[id].Revoked {
+			t.Fatalf("Unexpected status of revoking %s by an admin: %d", id, w.Code)
+		}
+	}
+}
@@ -13,5 +13,41 @@ type Slave interface {
 
 type Factory interface {
 	Name() string
-	New(params map[string][]string, headers map[string][]string) (Slave, error)
+	// New creates a slave for a client.
+	// env holds variables describing the authenticated user, such as GOTTY_USER.
+	New(params map[string][]string, headers map[string][]string, env map[string]string) (Slave, error)
+}
+
+// ProfileFactory is a Factory that can start other commands than its own,
+// for the command profiles of the server.
+type ProfileFactory interface {
+	NewCommand(command []string, params map[string][]string, headers map[string][]string, env map[string]string) (Slave, error)
+}
+
+// ExitReasoner is a Slave that can tell why its command ended,
+// e.g. when a resource limit killed it.
+type ExitReasoner interface {
+	ExitReason() string
+}
+
+// exitReason returns the exit reason of the backend under slave, if any.
+func exitReason(slave Slave) string {
+	switch s := slave.(type) {
+	case *recordingSlave:
+		return exitReason(s.Slave)
+	case *sessionViewer:
+		return exitReason(s.session.slave)
+	case ExitReasoner:
+		return s.ExitReason()
+	}
+	return ""
+}
+
+// slaveExitError is returned for a slave that ended with an exit reason.
+type slaveExitError struct {
+	reason string
+}
+
+func (err *slaveExitError) Error() string {
+	return err.reason
 }
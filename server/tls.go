@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"gotty/pkg/homedir"
+)
+
+const (
+	// selfSignedOrganization marks certificates generated by gotty, only these are regenerated
+	selfSignedOrganization = "GoTTY self-signed"
+	selfSignedValidity     = 365 * 24 * time.Hour
+	// selfSignedRenewBefore is how long before its expiry a self-signed certificate is regenerated
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// setupTLS configures the certificates of srv: from an ACME CA, self-signed
+// or from the configured files, which are reloaded when they change.
+// It returns the handler of ACME HTTP-01 challenges, nil when they are not answered.
+func (server *Server) setupTLS(srv *http.Server) (http.Handler, error) {
+	config := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	srv.TLSConfig = config
+
+	if server.options.EnableTLSClientAuth {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		log.Printf("TLS CA crt file: %s", server.certs.caFile)
+	}
+
+	var challenges http.Handler
+	if server.options.ACMEDomains != "" {
+		manager := server.acmeManager()
+		config.GetCertificate = manager.GetCertificate
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+		log.Printf("Getting TLS certificates for %s from %s", server.options.ACMEDomains, manager.Client.DirectoryURL)
+		if server.options.ACMEHTTPAddress != "" {
+			challenges = manager.HTTPHandler(nil)
+		}
+	} else {
+		if server.options.TLSSelfSigned {
+			if err := ensureSelfSigned(server.certs.crtFile, server.certs.keyFile, server.certificateHosts()); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("TLS crt file: %s", server.certs.crtFile)
+		log.Printf("TLS key file: %s", server.certs.keyFile)
+		config.GetCertificate = server.certs.GetCertificate
+	}
+
+	if err := server.certs.reload(true); err != nil {
+		return nil, err
+	}
+	if server.options.EnableTLSClientAuth {
+		if server.certs.crlFile != "" {
+			config.VerifyConnection = server.certs.verifyConnection
+			log.Printf("TLS CRL file: %s", server.certs.crlFile)
+		}
+		config.GetConfigForClient = server.certs.configForClient(config.Clone())
+	}
+	return challenges, nil
+}
+
+func (server *Server) acmeManager() *autocert.Manager {
+	domains := []string{}
+	for _, domain := range strings.Split(server.options.ACMEDomains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(homedir.Expand(server.options.ACMECacheDir)),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      server.options.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: server.options.ACMEDirectory},
+	}
+}
+
+// certificateHosts returns the names and addresses clients may use to reach gotty.
+func (server *Server) certificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	switch server.options.Address {
+	case "", "0.0.0.0", "::":
+		hosts = append(hosts, listAddresses()...)
+	default:
+		hosts = append(hosts, server.options.Address)
+	}
+
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		if !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+// ensureSelfSigned generates a self-signed certificate for hosts unless the files exist.
+// A certificate generated before is replaced when it expires soon or misses one of hosts,
+// other certificates are never overwritten.
+func ensureSelfSigned(crtFile string, keyFile string, hosts []string) error {
+	data, err := os.ReadFile(crtFile)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.Errorf("failed to parse TLS certificate `%s`", crtFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse TLS certificate `%s`", crtFile)
+		}
+		if !isSelfSigned(cert) || coversHosts(cert, hosts) && time.Until(cert.NotAfter) > selfSignedRenewBefore {
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read TLS certificate `%s`", crtFile)
+	}
+
+	crt, key, err := generateSelfSigned(hosts)
+	if err != nil {
+		return errors.Wrapf(err, "failed to generate self-signed certificate")
+	}
+	for _, dir := range []string{filepath.Dir(crtFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, key, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write TLS key `%s`", keyFile)
+	}
+	if err := os.WriteFile(crtFile, crt, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write TLS certificate `%s`", crtFile)
+	}
+
+	block, _ := pem.Decode(crt)
+	log.Printf("Generated self-signed certificate %s for %s, SHA-256 fingerprint: %s",
+		crtFile, strings.Join(hosts, ", "), fingerprint(block.Bytes))
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	for _, organization := range cert.Subject.Organization {
+		if organization == selfSignedOrganization {
+			return true
+		}
+	}
+	return false
+}
+
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSelfSigned returns the PEM encoded certificate and key for hosts.
+func generateSelfSigned(hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{selfSignedOrganization},
+			CommonName:   hosts[0],
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return crt, keyPEM, nil
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	crtFile := filepath.Join(dir, "gotty.crt")
+	keyFile := filepath.Join(dir, "gotty.key")
+
+	if err := ensureSelfSigned(crtFile, keyFile, []string{"localhost", "192.0.2.1"}); err != nil {
+		t.Fatalf("Unexpected error from ensureSelfSigned(): %s", err)
+	}
+	certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	if err != nil {
+		t.Fatalf("Unexpected error from LoadX509KeyPair(): %s", err)
+	}
+	if !coversHosts(certificate.Leaf, []string{"localhost", "192.0.2.1"}) {
+		t.Fatalf("Unexpected SANs: %v %v", certificate.Leaf.DNSNames, certificate.Leaf.IPAddresses)
+	}
+	if info, _ := os.Stat(keyFile); info.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected permissions of the key: %s", info.Mode())
+	}
+
+	// the certificate is kept while it covers the hosts
+	generated, _ := os.ReadFile(crtFile)
+	if err := ensureSelfSigned(crtFile, keyFile, []string{"192.0.2.1"}); err != nil {
+		t.Fatalf("Unexpected error from ensureSelfSigned(): %s", err)
+	}
+	if kept, _ := os.ReadFile(crtFile); !bytes.Equal(kept, generated) {
+		t.Fatalf("Certificate regenerated although it covers the hosts")
+	}
+	if err := ensureSelfSigned(crtFile, keyFile, []string{"192.0.2.2"}); err != nil {
+		t.Fatalf("Unexpected error from ensureSelfSigned(): %s", err)
+	}
+	if regenerated, _ := os.ReadFile(crtFile); bytes.Equal(regenerated, generated) {
+		t.Fatalf("Certificate not regenerated for a new address")
+	}
+
+	// other certificates are never replaced
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"Internal CA"}},
+		DNSNames:     []string{"gotty.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	foreign := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	os.WriteFile(crtFile, foreign, 0644)
+	if err := ensureSelfSigned(crtFile, keyFile, []string{"localhost"}); err != nil {
+		t.Fatalf("Unexpected error from ensureSelfSigned(): %s", err)
+	}
+	if kept, _ := os.ReadFile(crtFile); !bytes.Equal(kept, foreign) {
+		t.Fatalf("Certificate of another issuer replaced")
+	}
+}
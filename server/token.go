@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenStore keeps the session tokens handed out by the login endpoint,
+// so that clients never need to keep the password of the user.
+// Only hashes of the tokens are stored.
+type tokenStore struct {
+	ttl time.Duration
+
+	mutex    sync.Mutex
+	sessions map[[sha256.Size]byte]*tokenSession
+}
+
+type tokenSession struct {
+	identity *Identity
+	expires  time.Time
+}
+
+func newTokenStore(ttl time.Duration) *tokenStore {
+	return &tokenStore{
+		ttl:      ttl,
+		sessions: map[[sha256.Size]byte]*tokenSession{},
+	}
+}
+
+// issue returns a new token identifying identity until it expires.
+func (store *tokenStore) issue(identity *Identity) (string, time.Time, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	expires := time.Now().Add(store.ttl)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	for key, session := range store.sessions {
+		if now.After(session.expires) {
+			delete(store.sessions, key)
+		}
+	}
+	store.sessions[sha256.Sum256([]byte(token))] = &tokenSession{identity: identity, expires: expires}
+	return token, expires, nil
+}
+
+func (store *tokenStore) revoke(token string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.sessions, sha256.Sum256([]byte(token)))
+}
+
+// Authenticate identifies clients by the token in an Authorization: Bearer header,
+// or in the auth query parameter for WebSocket connections.
+func (store *tokenStore) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	token := tokenFromRequest(r)
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key := sha256.Sum256([]byte(token))
+	session, ok := store.sessions[key]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if time.Now().After(session.expires) {
+		delete(store.sessions, key)
+		return nil, ErrUnauthenticated
+	}
+	return session.identity, nil
+}
+
+func tokenFromRequest(r *http.Request) string {
+	header := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(header) == 2 && strings.ToLower(header[0]) == "bearer" {
+		return header[1]
+	}
+	return r.URL.Query().Get("auth")
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotty/pkg/passwd"
+)
+
+func TestLogin(t *testing.T) {
+	hash, err := passwd.Hash("secret")
+	if err != nil {
+		t.Fatalf("Unexpected error from Hash(): %s", err)
+	}
+	options := &Options{Users: map[string]string{"alice": hash}}
+	tokens := newTokenStore(time.Minute)
+	authenticator, err := newAuthenticator(options, nil, tokens)
+	if err != nil {
+		t.Fatalf("Unexpected error from newAuthenticator(): %s", err)
+	}
+	server := &Server{options: options, authenticator: authenticator, tokens: tokens}
+
+	login := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleAuthLogin(w, httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(body)))
+		return w
+	}
+	if w := login(`{"username":"alice","password":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Unexpected status of a login with a wrong password: %d", w.Code)
+	}
+	w := login(`{"username":"alice","password":"secret"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status of a login: %d", w.Code)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Token == "" {
+		t.Fatalf("No token in the response: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/ws?auth="+resp.Token, nil)
+	identity, err := server.authenticate(r)
+	if err != nil || identity.Name != "alice" {
+		t.Fatalf("Unexpected identity of the token: %+v, %v", identity, err)
+	}
+
+	r = httptest.NewRequest("GET", "/api/files", nil)
+	r.Header.Set("Authorization", "Bearer "+resp.Token)
+	tokens.revoke(resp.Token)
+	if _, err := server.authenticate(r); err != ErrUnauthenticated {
+		t.Fatalf("Revoked token accepted: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/api/files", nil)
+	r.SetBasicAuth("alice", "secret")
+	if _, err := server.authenticate(r); err != nil {
+		t.Fatalf("Unexpected error from authenticate() with Basic Auth: %s", err)
+	}
+}
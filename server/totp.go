@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gotty/pkg/totp"
+)
+
+// totpSkew is the number of time steps a code may be early or late
+const totpSkew = 1
+
+// totpVerifier checks the one-time passwords of users with a TOTP secret.
+// A code is only accepted once, later codes of the same or an earlier
+// time step are rejected as replays.
+type totpVerifier struct {
+	keys map[string][]byte
+
+	mutex sync.Mutex
+	// used is the time step of the last code accepted for each user
+	used map[string]int64
+}
+
+func newTOTPVerifier(secrets map[string]string) (*totpVerifier, error) {
+	keys := map[string][]byte{}
+	for user, secret := range secrets {
+		key, err := totp.ParseSecret(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse TOTP secret of user `%s`", user)
+		}
+		keys[user] = key
+	}
+	return &totpVerifier{keys: keys, used: map[string]int64{}}, nil
+}
+
+func (verifier *totpVerifier) verify(user string, code string) bool {
+	key, ok := verifier.keys[user]
+	if !ok {
+		return true
+	}
+	counter, ok := totp.Validate(key, code, time.Now(), totpSkew)
+	if !ok {
+		return false
+	}
+
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+	if last, ok := verifier.used[user]; ok && counter <= last {
+		return false
+	}
+	verifier.used[user] = counter
+	return true
+}
+
+// totpAuthenticator requires the one-time password of users with a TOTP secret
+// in addition to the password checked by authenticator.
+type totpAuthenticator struct {
+	authenticator Authenticator
+	verifier      *totpVerifier
+}
+
+func (second *totpAuthenticator) Authenticate(r *http.Request, creds *Credentials) (*Identity, error) {
+	identity, err := second.authenticator.Authenticate(r, creds)
+	if err != nil {
+		return nil, err
+	}
+	if !second.verifier.verify(creds.User, creds.OTP) {
+		return nil, ErrUnauthenticated
+	}
+	return identity, nil
+}
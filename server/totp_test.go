@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gotty/pkg/totp"
+)
+
+func TestTOTP(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("Unexpected error from GenerateSecret(): %s", err)
+	}
+	options := &Options{
+		Users:       map[string]string{"alice": "secret", "bob": "secret"},
+		TOTPSecrets: map[string]string{"alice": secret},
+	}
+	authenticator, err := newAuthenticator(options, nil, newTokenStore(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error from newAuthenticator(): %s", err)
+	}
+	key, _ := totp.ParseSecret(secret)
+	r := httptest.NewRequest("POST", "/api/auth/login", nil)
+
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "secret"}); err != ErrUnauthenticated {
+		t.Fatalf("Login without a code: %v", err)
+	}
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "secret", OTP: "000000x"}); err != ErrUnauthenticated {
+		t.Fatalf("Login with an invalid code: %v", err)
+	}
+	code := totp.Code(key, totp.Counter(time.Now()))
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "wrong", OTP: code}); err != ErrUnauthenticated {
+		t.Fatalf("Login with a wrong password: %v", err)
+	}
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "secret", OTP: code}); err != nil {
+		t.Fatalf("Unexpected error from Authenticate(): %s", err)
+	}
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "secret", OTP: code}); err != ErrUnauthenticated {
+		t.Fatalf("Replayed code accepted: %v", err)
+	}
+	// an early code within the skew is valid, older codes are replays afterwards
+	early := totp.Code(key, totp.Counter(time.Now())+1)
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "secret", OTP: early}); err != nil {
+		t.Fatalf("Unexpected error from Authenticate() with an early code: %s", err)
+	}
+	late := totp.Code(key, totp.Counter(time.Now())-1)
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "alice", Password: "secret", OTP: late}); err != ErrUnauthenticated {
+		t.Fatalf("Code older than the last one accepted: %v", err)
+	}
+
+	// users without a secret only need their password
+	if _, err := authenticator.Authenticate(r, &Credentials{User: "bob", Password: "secret"}); err != nil {
+		t.Fatalf("Unexpected error from Authenticate() without TOTP: %s", err)
+	}
+}
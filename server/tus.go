@@ -0,0 +1,601 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Resumable uploads follow the tus protocol 1.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, creation-with-upload, expiration, checksum and termination
+// extensions. POST api/tus creates an upload with the metadata filename, the
+// path of the file to save, and path, the folder to save it in. The returned
+// location api/tus/<id> is then appended to with PATCH at the offset returned
+// by HEAD. Uploads are kept in the tus folder of the temp path of their root
+// until they are complete, and can only be accessed by the user who created them.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,expiration,checksum,termination"
+	tusChecksums  = "sha1,md5,sha256"
+	// tusContentType is the content type of PATCH requests
+	tusContentType = "application/offset+octet-stream"
+	// statusChecksumMismatch is the status of appends failing their checksum
+	statusChecksumMismatch = 460
+	// uploadCollectInterval is the interval of the removal of expired uploads
+	uploadCollectInterval = 10 * time.Minute
+)
+
+var tusIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// tusUpload is an upload in progress, saved as JSON next to its data.
+type tusUpload struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"`
+	// Metadata is the Upload-Metadata header of the creation
+	Metadata string `json:"metadata,omitempty"`
+	// Filename and Dir are the cleaned filename and path of the metadata
+	Filename string `json:"filename"`
+	Dir      string `json:"dir"`
+	// Path is the saved file relative to the root, set once the upload is complete
+	Path string `json:"path,omitempty"`
+	// User is the name of the user who created the upload, empty without authentication
+	User string `json:"user,omitempty"`
+	// Expires is zero when the upload doesn't expire
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// tusStore keeps the uploads being appended to, which can't be appended
+// to or removed by other requests.
+type tusStore struct {
+	expiration time.Duration
+	mutex      sync.Mutex
+	busy       map[string]bool
+}
+
+func newTusStore(expiration time.Duration) *tusStore {
+	return &tusStore{expiration: expiration, busy: map[string]bool{}}
+}
+
+// lock marks the upload id busy, it returns false if it already is.
+func (store *tusStore) lock(id string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.busy[id] {
+		return false
+	}
+	store.busy[id] = true
+	return true
+}
+
+func (store *tusStore) unlock(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.busy, id)
+}
+
+// openTus opens the folder of the uploads of root, in its temp directory.
+func (root *fileRoot) openTus() (*os.Root, error) {
+	return root.openStaging("tus")
+}
+
+func (upload *tusUpload) dataName() string {
+	return upload.ID + ".part"
+}
+
+func (upload *tusUpload) expired(now time.Time) bool {
+	return !upload.Expires.IsZero() && now.After(upload.Expires)
+}
+
+// offset returns the length of the data received.
+func (upload *tusUpload) offset(tus *os.Root) (int64, error) {
+	if upload.Path != "" {
+		return upload.Length, nil
+	}
+	info, err := tus.Stat(upload.dataName())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (upload *tusUpload) save(tus *os.Root) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	name := upload.ID + ".json"
+	if err := tus.WriteFile(name+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return tus.Rename(name+".tmp", name)
+}
+
+func loadTusUpload(tus *os.Root, id string) (*tusUpload, error) {
+	data, err := tus.ReadFile(id + ".json")
+	if err != nil {
+		return nil, err
+	}
+	upload := &tusUpload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, errors.Wrapf(err, "invalid upload `%s`", id)
+	}
+	return upload, nil
+}
+
+func removeTusUpload(tus *os.Root, id string) {
+	tus.Remove(id + ".part")
+	tus.Remove(id + ".json")
+}
+
+// parseTusMetadata parses an Upload-Metadata header,
+// comma separated keys followed by a space and their value in base64.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if key == "" || err != nil {
+			return nil, errors.Errorf("invalid metadata `%s`", pair)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// parseTusChecksum parses an Upload-Checksum header, an algorithm followed
+// by a space and the checksum in base64.
+func parseTusChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.Errorf("invalid checksum `%s`", encoded)
+	}
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	}
+	return nil, nil, errors.Errorf("unsupported checksum algorithm `%s`", algorithm)
+}
+
+// tusRequest checks the protocol version and the authorization of r,
+// answering OPTIONS requests itself. It responds with an error and returns
+// nil when r is answered, or returns the method of r and its root.
+func (server *Server) tusRequest(w http.ResponseWriter, r *http.Request) (string, *fileRoot) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == "POST" {
+		method = override
+	}
+
+	if method == "OPTIONS" {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Checksum-Algorithm", tusChecksums)
+		if root := server.fileRootByName(r.URL.Query().Get("root")); root != nil && root.maxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(root.maxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return "", nil
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return "", nil
+	}
+	if !server.authorize(w, r, CapUpload) {
+		return "", nil
+	}
+	root := server.requestFileRoot(w, r, true)
+	if root == nil {
+		return "", nil
+	}
+	return method, root
+}
+
+// tusUser returns the name of the user of r, who owns the uploads it creates.
+func tusUser(r *http.Request) string {
+	if identity := identityFromRequest(r); identity != nil {
+		return identity.Name
+	}
+	return ""
+}
+
+// handleTusCreation creates uploads and answers OPTIONS requests.
+func (server *Server) handleTusCreation(w http.ResponseWriter, r *http.Request) {
+	method, root := server.tusRequest(w, r)
+	if root == nil {
+		return
+	}
+	if method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if root.maxUploadSize > 0 && length > root.maxUploadSize {
+		http.Error(w, "File exceeds the maximum upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := root.checkQuota(length); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	filename, err := cleanPath(metadata["filename"])
+	if err != nil || filename == "." {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+	dir, err := root.cleanPath(metadata["path"])
+	if err != nil || root.isHidden(filepath.Join(dir, filename)) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	upload := &tusUpload{
+		ID:       hex.EncodeToString(random),
+		Length:   length,
+		Metadata: r.Header.Get("Upload-Metadata"),
+		Filename: filename,
+		Dir:      dir,
+		User:     tusUser(r),
+	}
+	if server.uploads.expiration > 0 {
+		upload.Expires = time.Now().Add(server.uploads.expiration)
+	}
+	server.uploads.lock(upload.ID)
+	defer server.uploads.unlock(upload.ID)
+
+	tus, err := root.openTus()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open upload directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer tus.Close()
+	if err := tus.WriteFile(upload.dataName(), nil, 0600); err != nil {
+		http.Error(w, fmt.Sprintf("Could not create upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := upload.save(tus); err != nil {
+		removeTusUpload(tus, upload.ID)
+		http.Error(w, fmt.Sprintf("Could not create upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// the location is relative to api/tus to keep the path prefix of proxies
+	location := &url.URL{Path: path.Base(r.URL.Path) + "/" + upload.ID}
+	if name := r.URL.Query().Get("root"); name != "" {
+		location.RawQuery = url.Values{"root": {name}}.Encode()
+	}
+	w.Header().Set("Location", location.String())
+
+	offset := int64(0)
+	if r.Header.Get("Content-Type") == tusContentType || length == 0 {
+		var fileErr *fileError
+		if offset, fileErr = server.tusAppend(w, r, root, tus, upload, 0); fileErr != nil {
+			http.Error(w, fileErr.message, fileErr.status)
+			return
+		}
+	}
+	log.Printf("Upload created: %s (%s, %d bytes)", upload.ID, filepath.Join(dir, filename), length)
+
+	setTusHeaders(w, upload, offset)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleTusUpload answers HEAD, PATCH and DELETE requests for the upload at api/tus/<id>.
+func (server *Server) handleTusUpload(w http.ResponseWriter, r *http.Request) {
+	method, root := server.tusRequest(w, r)
+	if root == nil {
+		return
+	}
+	id := path.Base(r.URL.Path)
+	if !tusIDPattern.MatchString(id) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if method != "HEAD" && method != "PATCH" && method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if method != "HEAD" {
+		if !server.uploads.lock(id) {
+			http.Error(w, "Upload is in use", http.StatusLocked)
+			return
+		}
+		defer server.uploads.unlock(id)
+	}
+
+	tus, err := root.openTus()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not open upload directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer tus.Close()
+	upload, err := loadTusUpload(tus, id)
+	// uploads of other users are hidden
+	if err != nil || upload.User != tusUser(r) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if upload.expired(time.Now()) {
+		removeTusUpload(tus, id)
+		http.Error(w, "Upload expired", http.StatusGone)
+		return
+	}
+
+	switch method {
+	case "HEAD":
+		offset, err := upload.offset(tus)
+		if err != nil {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Metadata != "" {
+			w.Header().Set("Upload-Metadata", upload.Metadata)
+		}
+		setTusHeaders(w, upload, offset)
+		w.WriteHeader(http.StatusOK)
+
+	case "PATCH":
+		if r.Header.Get("Content-Type") != tusContentType {
+			http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+			return
+		}
+		offset, fileErr := server.tusAppend(w, r, root, tus, upload, offset)
+		if fileErr != nil {
+			http.Error(w, fileErr.message, fileErr.status)
+			return
+		}
+		setTusHeaders(w, upload, offset)
+		w.WriteHeader(http.StatusNoContent)
+
+	case "DELETE":
+		removeTusUpload(tus, id)
+		log.Printf("Upload terminated: %s", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func setTusHeaders(w http.ResponseWriter, upload *tusUpload, offset int64) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if !upload.Expires.IsZero() {
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+// tusAppend appends the body of r to upload at offset, verifying its
+// Upload-Checksum, and saves the file in root once the upload is complete.
+// It returns the offset after the body. Without a checksum, the data received
+// before an interrupted request is kept for the upload to resume.
+// Complete uploads are kept until they expire for clients to check their offset,
+// or removed right away when uploads don't expire.
+func (server *Server) tusAppend(w http.ResponseWriter, r *http.Request, root *fileRoot, tus *os.Root, upload *tusUpload, offset int64) (int64, *fileError) {
+	current, err := upload.offset(tus)
+	if err != nil {
+		return 0, newFileError(http.StatusInternalServerError, "Could not access upload: %v", err)
+	}
+	if offset != current {
+		return 0, newFileError(http.StatusConflict, "Upload-Offset %d doesn't match the offset %d of the upload", offset, current)
+	}
+
+	var checksum hash.Hash
+	var expected []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		if checksum, expected, err = parseTusChecksum(header); err != nil {
+			return 0, newFileError(http.StatusBadRequest, "%s", err)
+		}
+	}
+
+	if upload.Path == "" {
+		file, err := tus.OpenFile(upload.dataName(), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return 0, newFileError(http.StatusInternalServerError, "Could not open upload: %v", err)
+		}
+		var writer io.Writer = file
+		if checksum != nil {
+			writer = io.MultiWriter(file, checksum)
+		}
+		n, err := io.Copy(writer, http.MaxBytesReader(w, r.Body, upload.Length-offset))
+
+		var tooLarge *http.MaxBytesError
+		var fileErr *fileError
+		switch {
+		case errors.As(err, &tooLarge):
+			fileErr = newFileError(http.StatusRequestEntityTooLarge, "Body exceeds the length of the upload")
+		case err != nil && checksum != nil:
+			fileErr = newFileError(http.StatusBadRequest, "Upload interrupted: %v", err)
+		case checksum != nil && !bytes.Equal(checksum.Sum(nil), expected):
+			fileErr = newFileError(statusChecksumMismatch, "Checksum mismatch")
+		}
+		if fileErr != nil {
+			file.Truncate(offset)
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if fileErr != nil {
+			return 0, fileErr
+		}
+		if err != nil {
+			log.Printf("Upload %s interrupted at %d bytes: %v", upload.ID, offset+n, err)
+			return 0, newFileError(http.StatusBadRequest, "Upload interrupted: %v", err)
+		}
+		offset += n
+	}
+
+	if server.uploads.expiration > 0 {
+		upload.Expires = time.Now().Add(server.uploads.expiration)
+	}
+	if offset == upload.Length && upload.Path == "" {
+		if fileErr := upload.finish(root, tus); fileErr != nil {
+			return 0, fileErr
+		}
+		if server.uploads.expiration == 0 {
+			removeTusUpload(tus, upload.ID)
+			return offset, nil
+		}
+	}
+	if err := upload.save(tus); err != nil {
+		return 0, newFileError(http.StatusInternalServerError, "Could not save upload: %v", err)
+	}
+	return offset, nil
+}
+
+// finish moves the data of the complete upload to its file in root,
+// with a unique name if the file exists.
+func (upload *tusUpload) finish(root *fileRoot, tus *os.Root) *fileError {
+	if err := root.checkQuota(upload.Length); err != nil {
+		removeTusUpload(tus, upload.ID)
+		return newFileError(http.StatusRequestEntityTooLarge, "%s", err)
+	}
+	dir, err := root.open()
+	if err != nil {
+		return newFileError(http.StatusInternalServerError, "Could not open root: %v", err)
+	}
+	defer dir.Close()
+
+	target := filepath.Join(upload.Dir, upload.Filename)
+	if err := dir.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fileErrorOf(err)
+	}
+	dst, name, err := createUnique(dir, target)
+	if err != nil {
+		return fileErrorOf(err)
+	}
+	src, err := tus.Open(upload.dataName())
+	if err == nil {
+		_, err = io.Copy(dst, src)
+		src.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		dir.Remove(name)
+		return newFileError(http.StatusInternalServerError, "Could not save file: %v", err)
+	}
+
+	tus.Remove(upload.dataName())
+	upload.Path = filepath.ToSlash(name)
+	log.Printf("File uploaded successfully (tus): %s (size: %d bytes)", name, upload.Length)
+	return nil
+}
+
+// collectUploads removes expired uploads until ctx is done.
+func (server *Server) collectUploads(ctx context.Context) {
+	ticker := time.NewTicker(uploadCollectInterval)
+	defer ticker.Stop()
+	for {
+		server.removeExpiredUploads(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeExpiredUploads removes the tus uploads expired at now, and the chunks
+// of api/upload-chunk uploads not appended to for the upload expiration.
+func (server *Server) removeExpiredUploads(now time.Time) {
+	expiration := server.uploads.expiration
+	for _, root := range server.fileRoots {
+		// don't create the temp directories of roots never uploaded to
+		if _, err := os.Lstat(root.tempPath); err != nil || root.readOnly {
+			continue
+		}
+		temp, err := root.openStaging("chunks")
+		if err != nil {
+			log.Printf("Could not remove expired uploads: %v", err)
+			continue
+		}
+		entries, _ := fs.ReadDir(temp.FS(), ".")
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if !entry.IsDir() || err != nil || now.Sub(info.ModTime()) < expiration {
+				continue
+			}
+			if err := temp.RemoveAll(entry.Name()); err == nil {
+				log.Printf("Removed expired chunks %s of root %s", entry.Name(), root.name)
+			}
+		}
+		temp.Close()
+
+		tus, err := root.openTus()
+		if err != nil {
+			log.Printf("Could not remove expired uploads: %v", err)
+			continue
+		}
+		entries, _ = fs.ReadDir(tus.FS(), ".")
+		for _, entry := range entries {
+			id, ext, _ := strings.Cut(entry.Name(), ".")
+			if !tusIDPattern.MatchString(id) || !server.uploads.lock(id) {
+				continue
+			}
+			upload, err := loadTusUpload(tus, id)
+			info, infoErr := entry.Info()
+			switch {
+			case err == nil && upload.expired(now),
+				// data without an upload is left by a failed creation
+				err != nil && ext == "part" && infoErr == nil && now.Sub(info.ModTime()) >= expiration:
+				removeTusUpload(tus, id)
+				log.Printf("Removed expired upload %s of root %s", id, root.name)
+			}
+			server.uploads.unlock(id)
+		}
+		tus.Close()
+	}
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTusServer(t *testing.T) (*Server, string) {
+	dir := t.TempDir()
+	options := &Options{FileRoot: dir, MaxUploadSize: 1}
+	roots, err := newFileRoots(options)
+	if err != nil {
+		t.Fatalf("Unexpected error from newFileRoots(): %s", err)
+	}
+	return &Server{options: options, fileRoots: roots, uploads: newTusStore(time.Hour)}, dir
+}
+
+func tusRequest(handler http.HandlerFunc, method string, target string, body string, headers map[string]string) *http.Response {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Result()
+}
+
+func tusMetadata(filename string, dir string) string {
+	return "filename " + base64.StdEncoding.EncodeToString([]byte(filename)) +
+		",path " + base64.StdEncoding.EncodeToString([]byte(dir))
+}
+
+func tusChecksum(data string) string {
+	sum := sha1.Sum([]byte(data))
+	return "sha1 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// createTusUpload creates an upload and returns its URL.
+func createTusUpload(t *testing.T, server *Server, length string, metadata string) string {
+	response := tusRequest(server.handleTusCreation, "POST", "/api/tus", "", map[string]string{
+		"Upload-Length":   length,
+		"Upload-Metadata": metadata,
+	})
+	if response.StatusCode != 201 || response.Header.Get("Upload-Expires") == "" {
+		t.Fatalf("Unexpected response of creation: %d %v", response.StatusCode, response.Header)
+	}
+	location, _ := url.Parse(response.Header.Get("Location"))
+	return "/api/" + location.String()
+}
+
+func TestTusUpload(t *testing.T) {
+	server, dir := newTusServer(t)
+
+	response := tusRequest(server.handleTusCreation, "OPTIONS", "/api/tus", "", nil)
+	if response.StatusCode != 204 || response.Header.Get("Tus-Version") != "1.0.0" ||
+		!strings.Contains(response.Header.Get("Tus-Extension"), "checksum") || response.Header.Get("Tus-Max-Size") != "1048576" {
+		t.Fatalf("Unexpected response of OPTIONS: %d %v", response.StatusCode, response.Header)
+	}
+	r := httptest.NewRequest("POST", "/api/tus", nil)
+	w := httptest.NewRecorder()
+	server.handleTusCreation(w, r)
+	if w.Code != 412 {
+		t.Fatalf("Unexpected status without Tus-Resumable: %d", w.Code)
+	}
+
+	upload := createTusUpload(t, server, "10", tusMetadata("docs/a.txt", "sub"))
+	if !strings.HasPrefix(upload, "/api/tus/") {
+		t.Fatalf("Unexpected upload URL: %s", upload)
+	}
+	offset := func() string {
+		response := tusRequest(server.handleTusUpload, "HEAD", upload, "", nil)
+		if response.StatusCode != 200 || response.Header.Get("Upload-Length") != "10" || response.Header.Get("Cache-Control") != "no-store" {
+			t.Fatalf("Unexpected response of HEAD: %d %v", response.StatusCode, response.Header)
+		}
+		return response.Header.Get("Upload-Offset")
+	}
+	patch := func(offset string, body string, headers map[string]string) *http.Response {
+		all := map[string]string{"Content-Type": tusContentType, "Upload-Offset": offset}
+		for key, value := range headers {
+			all[key] = value
+		}
+		return tusRequest(server.handleTusUpload, "PATCH", upload, body, all)
+	}
+	if offset() != "0" {
+		t.Fatalf("Unexpected offset of a new upload: %s", offset())
+	}
+
+	if response := patch("0", "hello", map[string]string{"Upload-Checksum": tusChecksum("hello")}); response.StatusCode != 204 || response.Header.Get("Upload-Offset") != "5" {
+		t.Fatalf("Unexpected response of PATCH: %d %v", response.StatusCode, response.Header)
+	}
+	for status, response := range map[int]*http.Response{
+		409: patch("0", "hello", nil),
+		460: patch("5", "world", map[string]string{"Upload-Checksum": tusChecksum("other")}),
+		400: patch("5", "world", map[string]string{"Upload-Checksum": "crc32 AAAA"}),
+		413: patch("5", "world and more", nil),
+		415: patch("5", "world", map[string]string{"Content-Type": "text/plain"}),
+	} {
+		if response.StatusCode != status {
+			t.Fatalf("Unexpected status instead of %d: %d", status, response.StatusCode)
+		}
+	}
+	if offset() != "5" {
+		t.Fatalf("Rejected data appended: %s", offset())
+	}
+
+	// the upload can't be appended to by two requests at once
+	id := path.Base(upload)
+	server.uploads.lock(id)
+	if response := patch("5", "world", nil); response.StatusCode != 423 {
+		t.Fatalf("Unexpected status of a PATCH of a busy upload: %d", response.StatusCode)
+	}
+	server.uploads.unlock(id)
+
+	if response := patch("5", "world", nil); response.StatusCode != 204 || response.Header.Get("Upload-Offset") != "10" {
+		t.Fatalf("Unexpected response of the last PATCH: %d %v", response.StatusCode, response.Header)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "sub", "docs", "a.txt")); err != nil || string(data) != "helloworld" {
+		t.Fatalf("Unexpected uploaded file: %s %v", data, err)
+	}
+	if offset() != "10" {
+		t.Fatalf("Unexpected offset of a complete upload: %s", offset())
+	}
+
+	if response := tusRequest(server.handleTusUpload, "DELETE", upload, "", nil); response.StatusCode != 204 {
+		t.Fatalf("Unexpected status of DELETE: %d", response.StatusCode)
+	}
+	if response := tusRequest(server.handleTusUpload, "HEAD", upload, "", nil); response.StatusCode != 404 {
+		t.Fatalf("Unexpected status of HEAD of a deleted upload: %d", response.StatusCode)
+	}
+}
+
+func TestTusCreation(t *testing.T) {
+	server, dir := newTusServer(t)
+
+	// creation with upload
+	response := tusRequest(server.handleTusCreation, "POST", "/api/tus", "data", map[string]string{
+		"Upload-Length":   "4",
+		"Upload-Metadata": tusMetadata("b.txt", ""),
+		"Content-Type":    tusContentType,
+	})
+	if response.StatusCode != 201 || response.Header.Get("Upload-Offset") != "4" {
+		t.Fatalf("Unexpected response of creation with upload: %d %v", response.StatusCode, response.Header)
+	}
+	createTusUpload(t, server, "0", tusMetadata("empty.txt", ""))
+	createTusUpload(t, server, "0", tusMetadata("b.txt", ""))
+	for name, content := range map[string]string{"b.txt": "data", "empty.txt": "", "b_1.txt": ""} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != content {
+			t.Fatalf("Unexpected uploaded file %s: %q %v", name, data, err)
+		}
+	}
+
+	for status, headers := range map[int]map[string]string{
+		400: {"Upload-Length": "10", "Upload-Metadata": tusMetadata("../x", "")},
+		413: {"Upload-Length": "2000000", "Upload-Metadata": tusMetadata("big", "")},
+	} {
+		if response := tusRequest(server.handleTusCreation, "POST", "/api/tus", "", headers); response.StatusCode != status {
+			t.Fatalf("Unexpected status instead of %d: %d", status, response.StatusCode)
+		}
+	}
+	for metadata, status := range map[string]int{
+		tusMetadata("c", "/tmp"): 400,
+		"filename":               400,
+		"filename !!!":           400,
+	} {
+		headers := map[string]string{"Upload-Length": "1", "Upload-Metadata": metadata}
+		if response := tusRequest(server.handleTusCreation, "POST", "/api/tus", "", headers); response.StatusCode != status {
+			t.Fatalf("Unexpected status with metadata %q: %d", metadata, response.StatusCode)
+		}
+	}
+}
+
+func TestRemoveExpiredUploads(t *testing.T) {
+	server, dir := newTusServer(t)
+	upload := createTusUpload(t, server, "10", tusMetadata("a.txt", ""))
+	chunks := filepath.Join(dir, ".temp", "chunks", "123_abc")
+	os.MkdirAll(chunks, 0755)
+	os.WriteFile(filepath.Join(chunks, "0"), []byte("chunk"), 0644)
+
+	server.removeExpiredUploads(time.Now())
+	if response := tusRequest(server.handleTusUpload, "HEAD", upload, "", nil); response.StatusCode != 200 {
+		t.Fatalf("Upload removed before it expires: %d", response.StatusCode)
+	}
+	if _, err := os.Stat(chunks); err != nil {
+		t.Fatalf("Chunks removed before they expire: %s", err)
+	}
+
+	server.removeExpiredUploads(time.Now().Add(2 * time.Hour))
+	if response := tusRequest(server.handleTusUpload, "HEAD", upload, "", nil); response.StatusCode != 404 {
+		t.Fatalf("Unexpected status of HEAD of an expired upload: %d", response.StatusCode)
+	}
+	if _, err := os.Stat(chunks); err == nil {
+		t.Fatalf("Expired chunks not removed")
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, ".temp", "tus"))
+	if len(entries) != 0 {
+		t.Fatalf("Files of expired uploads left: %v", entries)
+	}
+}
+
+func TestTusUploadOwner(t *testing.T) {
+	server, _ := newTusServer(t)
+	as := func(user string, handler http.HandlerFunc, method string, target string, headers map[string]string) int {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Tus-Resumable", tusVersion)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, withIdentity(r, &Identity{Name: user}))
+		return w.Code
+	}
+
+	r := httptest.NewRequest("POST", "/api/tus", nil)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Upload-Length", "10")
+	r.Header.Set("Upload-Metadata", tusMetadata("a.txt", ""))
+	w := httptest.NewRecorder()
+	server.handleTusCreation(w, withIdentity(r, &Identity{Name: "alice"}))
+	if w.Code != 201 {
+		t.Fatalf("Unexpected status of creation: %d", w.Code)
+	}
+	upload := "/api/" + w.Header().Get("Location")
+
+	// uploads of other users can't be seen, appended to or terminated
+	patch := map[string]string{"Content-Type": tusContentType, "Upload-Offset": "0"}
+	for method, headers := range map[string]map[string]string{"HEAD": nil, "PATCH": patch, "DELETE": nil} {
+		if code := as("bob", server.handleTusUpload, method, upload, headers); code != 404 {
+			t.Fatalf("Unexpected status of %s by another user: %d", method, code)
+		}
+	}
+	if response := tusRequest(server.handleTusUpload, "HEAD", upload, "", nil); response.StatusCode != 404 {
+		t.Fatalf("Unexpected status of HEAD without authentication: %d", response.StatusCode)
+	}
+	if code := as("alice", server.handleTusUpload, "HEAD", upload, nil); code != 200 {
+		t.Fatalf("Unexpected status of HEAD by the creator: %d", code)
+	}
+}
+
+func TestTusUploadWithoutExpiration(t *testing.T) {
+	server, dir := newTusServer(t)
+	server.uploads = newTusStore(0)
+
+	response := tusRequest(server.handleTusCreation, "POST", "/api/tus", "", map[string]string{
+		"Upload-Length":   "4",
+		"Upload-Metadata": tusMetadata("a.txt", ""),
+	})
+	if response.StatusCode != 201 || response.Header.Get("Upload-Expires") != "" {
+		t.Fatalf("Unexpected response of creation: %d %v", response.StatusCode, response.Header)
+	}
+	upload := "/api/" + response.Header.Get("Location")
+	response = tusRequest(server.handleTusUpload, "PATCH", upload, "data", map[string]string{"Content-Type": tusContentType, "Upload-Offset": "0"})
+	if response.StatusCode != 204 || response.Header.Get("Upload-Offset") != "4" {
+		t.Fatalf("Unexpected response of PATCH: %d %v", response.StatusCode, response.Header)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(data) != "data" {
+		t.Fatalf("Unexpected uploaded file: %s %v", data, err)
+	}
+	// complete uploads are removed right away when they don't expire
+	if entries, _ := os.ReadDir(filepath.Join(dir, ".temp", "tus")); len(entries) != 0 {
+		t.Fatalf("Files of a complete upload left: %v", entries)
+	}
+}
+
+func TestTusTempLink(t *testing.T) {
+	server, dir := newTusServer(t)
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, ".temp")); err != nil {
+		t.Skipf("Symbolic links not supported: %s", err)
+	}
+
+	headers := map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata("a.txt", "")}
+	if response := tusRequest(server.handleTusCreation, "POST", "/api/tus", "", headers); response.StatusCode != 500 {
+		t.Fatalf("Unexpected status of creation through a linked temp directory: %d", response.StatusCode)
+	}
+	os.Mkdir(filepath.Join(outside, "chunks"), 0755)
+	server.removeExpiredUploads(time.Now().Add(48 * time.Hour))
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Fatalf("Directory outside the root changed: %v", entries)
+	}
+}
+
+func TestTusChunkUploadID(t *testing.T) {
+	server, _ := newTusServer(t)
+	upload := createTusUpload(t, server, "10", tusMetadata("a.txt", ""))
+
+	// the ID of a chunked upload is chosen by the client, it can't name the tus uploads
+	for _, fileID := range []string{"tus", "../tus", "chunks"} {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		for key, value := range map[string]string{"chunkIndex": "0", "totalChunks": "1", "fileId": fileID, "filename": "chunk.txt"} {
+			form.WriteField(key, value)
+		}
+		part, _ := form.CreateFormFile("chunk", "blob")
+		part.Write([]byte("chunk"))
+		form.Close()
+		r := httptest.NewRequest("POST", "/api/upload-chunk", body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.handleChunkUpload(w, r)
+		if w.Code != 200 {
+			t.Fatalf("Unexpected status of a chunked upload with ID %s: %d %s", fileID, w.Code, w.Body)
+		}
+	}
+	if response := tusRequest(server.handleTusUpload, "HEAD", upload, "", nil); response.StatusCode != 200 {
+		t.Fatalf("Upload removed by a chunked upload: %d", response.StatusCode)
+	}
+}
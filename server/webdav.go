@@ -0,0 +1,378 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+// webdavFS is a webdav.FileSystem serving a file root through os.Root,
+// confined like the JSON file APIs.
+type webdavFS struct {
+	root *fileRoot
+}
+
+// resolve opens the root and returns the cleaned path of name, a slash separated
+// path starting with "/" from webdav.Handler. writable operations are refused on
+// read-only roots, the temporary directories of the root don't exist.
+func (fsys *webdavFS) resolve(op string, name string, writable bool) (*os.Root, string, error) {
+	clean, err := cleanPath(strings.TrimPrefix(name, "/"))
+	if err == nil && fsys.root.isHidden(clean) {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil || (writable && fsys.root.readOnly) {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	dir, err := fsys.root.open()
+	if err != nil {
+		return nil, "", err
+	}
+	return dir, clean, nil
+}
+
+func (fsys *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	dir, name, err := fsys.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Mkdir(name, perm)
+}
+
+func (fsys *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	writable := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	dir, name, err := fsys.resolve("open", name, writable)
+	if err != nil {
+		return nil, err
+	}
+	// files stay open when their root is closed
+	defer dir.Close()
+	file, err := dir.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavFile{File: file, root: fsys.root, name: name}, nil
+}
+
+func (fsys *webdavFS) RemoveAll(ctx context.Context, name string) error {
+	dir, name, err := fsys.resolve("remove", name, true)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if fsys.root.holdsHidden(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return dir.RemoveAll(name)
+}
+
+func (fsys *webdavFS) Rename(ctx context.Context, oldName string, newName string) error {
+	dir, oldName, err := fsys.resolve("rename", oldName, true)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	newName, err = cleanPath(strings.TrimPrefix(newName, "/"))
+	if err != nil || fsys.root.holdsHidden(oldName) || fsys.root.holdsHidden(newName) {
+		return &os.PathError{Op: "rename", Path: newName, Err: os.ErrPermission}
+	}
+	return dir.Rename(oldName, newName)
+}
+
+func (fsys *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dir, name, err := fsys.resolve("stat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Stat(name)
+}
+
+// webdavFile is a file of a webdavFS, listing folders without the temporary directories.
+type webdavFile struct {
+	*os.File
+	root *fileRoot
+	// name is the cleaned path of the file in root
+	name string
+}
+
+func (file *webdavFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := file.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !file.root.isHidden(filepath.Join(file.name, info.Name())) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// webdavMethod is what a WebDAV method requires of users and roots.
+type webdavMethod struct {
+	// capabilities permit the method with any of them
+	capabilities []Capability
+	writable     bool
+}
+
+// webdavMethods are the methods served over WebDAV, other ones are not allowed.
+// A MOVE deletes its source and requires the delete capability as well,
+// so does a PUT or a COPY replacing an existing file.
+var webdavMethods = map[string]webdavMethod{
+	"OPTIONS":   {capabilities: []Capability{CapDownload, CapUpload, CapDelete}},
+	"PROPFIND":  {capabilities: []Capability{CapDownload, CapUpload, CapDelete}},
+	"GET":       {capabilities: []Capability{CapDownload}},
+	"HEAD":      {capabilities: []Capability{CapDownload}},
+	"PUT":       {capabilities: []Capability{CapUpload}, writable: true},
+	"MKCOL":     {capabilities: []Capability{CapUpload}, writable: true},
+	"COPY":      {capabilities: []Capability{CapUpload}, writable: true},
+	"MOVE":      {capabilities: []Capability{CapUpload}, writable: true},
+	"PROPPATCH": {capabilities: []Capability{CapUpload}, writable: true},
+	"DELETE":    {capabilities: []Capability{CapDelete}, writable: true},
+	"LOCK":      {capabilities: []Capability{CapUpload, CapDelete}, writable: true},
+	"UNLOCK":    {capabilities: []Capability{CapUpload, CapDelete}, writable: true},
+}
+
+// newWebDAVHandler returns the handler serving the file roots over WebDAV at
+// pathPrefix+"webdav/": the root itself when there is only one, each root at
+// webdav/<name>/ otherwise.
+func (server *Server) newWebDAVHandler(pathPrefix string) http.Handler {
+	base := pathPrefix + "webdav"
+	handlers := map[string]*webdav.Handler{}
+	for _, root := range server.fileRoots {
+		prefix := base
+		if len(server.fileRoots) > 1 {
+			prefix += "/" + root.name
+		}
+		handlers[root.name] = &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: &webdavFS{root: root},
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					log.Printf("WebDAV %s %s failed: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := webdavMethods[r.Method]
+		if !ok {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !server.allowed(r, method.capabilities...) || (r.Method == "MOVE" && !server.allowed(r, CapDelete)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var root *fileRoot
+		if len(server.fileRoots) == 1 {
+			root = server.fileRoots[0]
+		} else if name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, base+"/"), "/"); name != "" {
+			root = server.fileRootByName(name)
+		}
+		if root == nil {
+			http.Error(w, "Unknown root", http.StatusNotFound)
+			return
+		}
+		if method.writable && root.readOnly {
+			http.Error(w, "Root is read-only", http.StatusForbidden)
+			return
+		}
+		handler := handlers[root.name]
+
+		switch r.Method {
+		case "PUT":
+			if exists, err := root.exists(strings.TrimPrefix(r.URL.Path, handler.Prefix)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if exists && !server.allowed(r, CapDelete) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if root.maxUploadSize > 0 && r.ContentLength > root.maxUploadSize {
+				http.Error(w, "File exceeds the maximum upload size", http.StatusRequestEntityTooLarge)
+				return
+			}
+			left, err := root.quotaLeft()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if left >= 0 && r.ContentLength > left {
+				http.Error(w, fmt.Sprintf("quota of root `%s` exceeded", root.name), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// without Content-Length the limits are enforced while writing
+			if limit := root.fileLimit(left); limit >= 0 {
+				serveWebDAVPut(w, r, handler, limit)
+				return
+			}
+		case "LOCK":
+			r.Header.Set("Timeout", webdavLockTimeout(r.Header.Get("Timeout")))
+		case "COPY", "MOVE":
+			if status, message := server.webdavCheckDestination(r, handler.Prefix, root); status != 0 {
+				http.Error(w, message, status)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// webdavCheckDestination returns the status and the message refusing the COPY
+// or MOVE r, 0 when it is fine. webdav.Handler lets a MOVE overwrite a folder
+// containing its source, deleting it, or a COPY into itself never end.
+func (server *Server) webdavCheckDestination(r *http.Request, prefix string, root *fileRoot) (int, string) {
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		// the handler responds with the error
+		return 0, ""
+	}
+	src := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+	dst := path.Clean("/" + strings.TrimPrefix(destination.Path, prefix))
+	inside := func(name string, parent string) bool {
+		return strings.HasPrefix(name, strings.TrimSuffix(parent, "/")+"/")
+	}
+	if src != dst && (inside(dst, src) || inside(src, dst)) {
+		return http.StatusForbidden, "Destination and source are nested"
+	}
+
+	// without Overwrite: F an existing destination is deleted first
+	if r.Method == "COPY" && r.Header.Get("Overwrite") != "F" {
+		exists, err := root.exists(dst)
+		if err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		if exists && !server.allowed(r, CapDelete) {
+			return http.StatusForbidden, "Forbidden"
+		}
+	}
+
+	if r.Method == "COPY" && root.quota > 0 {
+		name, err := cleanPath(strings.TrimPrefix(src, "/"))
+		if err != nil {
+			return http.StatusForbidden, "Invalid path"
+		}
+		dir, err := root.open()
+		if err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		defer dir.Close()
+		// a missing source is responded by the handler
+		if size, err := treeSize(dir.FS(), filepath.ToSlash(name)); err == nil {
+			if err := root.checkQuota(size); err != nil {
+				return http.StatusRequestEntityTooLarge, err.Error()
+			}
+		}
+	}
+	return 0, ""
+}
+
+// serveWebDAVPut serves the PUT r with handler, responding 413 and removing
+// the file when its body exceeds limit, the maximum upload size or the quota left.
+// webdav.Handler would respond 405 and keep the truncated file.
+func serveWebDAVPut(w http.ResponseWriter, r *http.Request, handler *webdav.Handler, limit int64) {
+	body := &webdavBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+	r.Body = body
+	handler.ServeHTTP(&webdavPutWriter{ResponseWriter: w, body: body}, r)
+	if !body.exceeded {
+		return
+	}
+	reqPath := strings.TrimPrefix(r.URL.Path, handler.Prefix)
+	if err := handler.FileSystem.RemoveAll(r.Context(), reqPath); err != nil {
+		log.Printf("Could not remove the incomplete upload %s: %v", reqPath, err)
+	}
+}
+
+// webdavBody is the body of a PUT recording whether it exceeded its limit.
+type webdavBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (body *webdavBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		body.exceeded = true
+	}
+	return n, err
+}
+
+// webdavPutWriter responds 413 instead of the response of the handler once body exceeded its limit.
+type webdavPutWriter struct {
+	http.ResponseWriter
+	body     *webdavBody
+	rejected bool
+}
+
+func (w *webdavPutWriter) WriteHeader(status int) {
+	if w.body.exceeded {
+		w.rejected = true
+		http.Error(w.ResponseWriter, errUploadTooLarge.message, http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *webdavPutWriter) Write(p []byte) (int, error) {
+	if w.rejected {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// exists tells whether the file at name, a slash separated path from
+// webdav.Handler, exists in root. Invalid paths are left to the handler.
+func (root *fileRoot) exists(name string) (bool, error) {
+	clean, err := cleanPath(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	if err != nil {
+		return false, nil
+	}
+	dir, err := root.open()
+	if err != nil {
+		return false, err
+	}
+	defer dir.Close()
+	_, err = dir.Lstat(clean)
+	return err == nil, nil
+}
+
+// maxWebDAVLockDuration is the longest lock granted, so that the locks of
+// clients that went away expire instead of staying in memory.
+// Clients refresh their locks before they expire.
+const maxWebDAVLockDuration = time.Hour
+
+// webdavLockTimeout returns the Timeout header of a LOCK limited to
+// maxWebDAVLockDuration, the handler grants infinite locks without one.
+// Invalid headers are left to the handler.
+func webdavLockTimeout(header string) string {
+	limit := fmt.Sprintf("Second-%d", int(maxWebDAVLockDuration.Seconds()))
+	// clients can list several timeouts, the first one is used
+	first, _, _ := strings.Cut(header, ",")
+	first = strings.TrimSpace(first)
+	if first == "" || first == "Infinite" {
+		return limit
+	}
+	if seconds, ok := strings.CutPrefix(first, "Second-"); ok {
+		if n, err := strconv.ParseInt(seconds, 10, 64); err == nil && n > int64(maxWebDAVLockDuration.Seconds()) {
+			return limit
+		}
+	}
+	return header
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webdavRequest serves a request with handler and returns the response.
+func webdavRequest(handler http.Handler, method string, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestWebDAV(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	handler := server.newWebDAVHandler("/")
+
+	w := webdavRequest(handler, "PROPFIND", "/webdav/data/", "", map[string]string{"Depth": "1"})
+	if w.Code != 207 || !strings.Contains(w.Body.String(), "/webdav/data/notes.txt") || !strings.Contains(w.Body.String(), "/webdav/data/docs/") {
+		t.Fatalf("Unexpected response of PROPFIND: %d %s", w.Code, w.Body)
+	}
+	if w := webdavRequest(handler, "GET", "/webdav/data/notes.txt", "", nil); w.Code != 200 || w.Body.String() != "notes" {
+		t.Fatalf("Unexpected response of GET: %d %s", w.Code, w.Body)
+	}
+	if w := webdavRequest(handler, "GET", "/webdav/data/notes.txt", "", map[string]string{"Range": "bytes=1-2"}); w.Code != 206 || w.Body.String() != "ot" {
+		t.Fatalf("Unexpected response of a range GET: %d %s", w.Code, w.Body)
+	}
+
+	for _, step := range []struct {
+		method  string
+		target  string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"MKCOL", "/webdav/data/a", "", nil, 201},
+		{"PUT", "/webdav/data/a/b.txt", "hello", nil, 201},
+		{"COPY", "/webdav/data/a", "", map[string]string{"Destination": "/webdav/data/c"}, 201},
+		{"MOVE", "/webdav/data/c/b.txt", "", map[string]string{"Destination": "/webdav/data/d.txt"}, 201},
+		{"DELETE", "/webdav/data/c", "", nil, 204},
+		// a MOVE overwriting its parent or a COPY into itself are refused
+		{"MOVE", "/webdav/data/a/b.txt", "", map[string]string{"Destination": "/webdav/data/a", "Overwrite": "T"}, 403},
+		{"COPY", "/webdav/data/a", "", map[string]string{"Destination": "/webdav/data/a/a"}, 403},
+		{"COPY", "/webdav/data/", "", map[string]string{"Destination": "/webdav/data/x"}, 403},
+		{"DELETE", "/webdav/data/", "", nil, 405},
+		{"POST", "/webdav/data/notes.txt", "", nil, 405},
+		{"PROPFIND", "/webdav/", "", nil, 404},
+		{"PROPFIND", "/webdav/missing/", "", nil, 404},
+		// read-only roots
+		{"GET", "/webdav/logs/", "", nil, 405},
+		{"PUT", "/webdav/logs/x.txt", "x", nil, 403},
+		{"MKCOL", "/webdav/logs/x", "", nil, 403},
+	} {
+		w := webdavRequest(handler, step.method, step.target, step.body, step.headers)
+		if w.Code != step.status {
+			t.Fatalf("Unexpected status of %s %s instead of %d: %d %s", step.method, step.target, step.status, w.Code, w.Body)
+		}
+	}
+	for name, content := range map[string]string{"a/b.txt": "hello", "d.txt": "hello"} {
+		if data, err := os.ReadFile(filepath.Join(data, filepath.FromSlash(name))); err != nil || string(data) != content {
+			t.Fatalf("Unexpected file %s: %q %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(data, "c")); err == nil {
+		t.Fatalf("Folder not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs", "x.txt")); err == nil {
+		t.Fatalf("File created in a read-only root")
+	}
+
+	// a single root is served at the top
+	server.fileRoots = server.fileRoots[:1]
+	handler = server.newWebDAVHandler("/gotty/")
+	if w := webdavRequest(handler, "GET", "/gotty/webdav/d.txt", "", nil); w.Code != 200 || w.Body.String() != "hello" {
+		t.Fatalf("Unexpected response of GET in a single root: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWebDAVConfinement(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	handler := server.newWebDAVHandler("/")
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(dir, "data", "secret.txt")); err != nil {
+		t.Skipf("Symbolic links not supported: %s", err)
+	}
+	os.Symlink(dir, filepath.Join(dir, "data", "outside"))
+
+	for _, target := range []string{"/webdav/data/secret.txt", "/webdav/data/outside/secret.txt", "/webdav/data/../secret.txt", "/webdav/data/%2e%2e/secret.txt"} {
+		if w := webdavRequest(handler, "GET", target, "", nil); w.Code == 200 || strings.Contains(w.Body.String(), "secret") {
+			t.Fatalf("File outside the root served at %s: %d %s", target, w.Code, w.Body)
+		}
+	}
+	if w := webdavRequest(handler, "PUT", "/webdav/data/outside/new.txt", "x", nil); w.Code < 400 {
+		t.Fatalf("Unexpected status of PUT through a link leaving the root: %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); err == nil {
+		t.Fatalf("File created outside the root")
+	}
+	w := webdavRequest(handler, "PROPFIND", "/webdav/data/", "", map[string]string{"Depth": "infinity"})
+	if w.Code != 207 || strings.Contains(w.Body.String(), "secret.txt") || !strings.Contains(w.Body.String(), "notes.txt") {
+		t.Fatalf("Unexpected response of PROPFIND with links leaving the root: %d %s", w.Code, w.Body)
+	}
+	webdavRequest(handler, "DELETE", "/webdav/data/outside", "", nil)
+	if data, err := os.ReadFile(filepath.Join(dir, "secret.txt")); err != nil || string(data) != "secret" {
+		t.Fatalf("File outside the root changed: %s %v", data, err)
+	}
+}
+
+func TestWebDAVLimits(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	server.fileRoots[0].maxUploadSize = 4
+	handler := server.newWebDAVHandler("/")
+
+	if w := webdavRequest(handler, "PUT", "/webdav/data/big.txt", "too large", nil); w.Code != 413 {
+		t.Fatalf("Unexpected status of PUT beyond the maximum upload size: %d", w.Code)
+	}
+	// without Content-Length the upload is stopped at the limit
+	r := httptest.NewRequest("PUT", "/webdav/data/big.txt", strings.NewReader("too large"))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 413 || !strings.Contains(w.Body.String(), "maximum upload size") {
+		t.Fatalf("Unexpected response of a chunked PUT beyond the maximum upload size: %d %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data", "big.txt")); err == nil {
+		t.Fatalf("Incomplete upload kept")
+	}
+	if w := webdavRequest(handler, "PUT", "/webdav/data/ok.txt", "fine", nil); w.Code != 201 {
+		t.Fatalf("Unexpected status of PUT within the maximum upload size: %d", w.Code)
+	}
+
+	server.fileRoots[0].quota = 20
+	if w := webdavRequest(handler, "COPY", "/webdav/data/docs", "", map[string]string{"Destination": "/webdav/data/again"}); w.Code != 413 {
+		t.Fatalf("Unexpected status of COPY beyond the quota: %d", w.Code)
+	}
+}
+
+func TestWebDAVAuthorization(t *testing.T) {
+	server, _ := newFileOpsServer(t)
+	server.options.Roles = map[string]*Role{"uploader": {Capabilities: []string{"upload", "download"}}}
+	server.options.DefaultRole = "uploader"
+	handler := server.newWebDAVHandler("/")
+
+	for method, status := range map[string]int{"PROPFIND": 207, "DELETE": 403, "MOVE": 403} {
+		w := webdavRequest(handler, method, "/webdav/data/notes.txt", "", map[string]string{"Destination": "/webdav/data/todo.txt"})
+		if w.Code != status {
+			t.Fatalf("Unexpected status of %s without the delete capability: %d", method, w.Code)
+		}
+	}
+
+	// public paths of the page aren't public under webdav/
+	options := &Options{Users: map[string]string{"alice": "secret"}}
+	authenticator, err := newAuthenticator(options, nil, newTokenStore(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error from newAuthenticator(): %s", err)
+	}
+	server.authenticator = authenticator
+	handler = server.wrapAuth(handler, "/")
+	os.WriteFile(filepath.Join(server.fileRoots[0].path, "docs", "app.js"), []byte("js"), 0644)
+	for _, target := range []string{"/webdav/data/docs/config.json", "/webdav/data/docs/app.js", "/webdav/data/docs/img/dot.png"} {
+		w := webdavRequest(handler, "GET", target, "", nil)
+		if w.Code != 401 || w.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("Unexpected response of %s without credentials: %d", target, w.Code)
+		}
+	}
+	r := httptest.NewRequest("GET", "/webdav/data/docs/config.json", nil)
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Unexpected status with credentials: %d", w.Code)
+	}
+}
+
+func TestWebDAVCapabilities(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	data := filepath.Join(dir, "data")
+	server.options.Roles = map[string]*Role{
+		"uploader": {Capabilities: []string{"upload", "download"}},
+		"editor":   {Capabilities: []string{"upload", "download", "delete"}},
+	}
+	server.options.UserRoles = map[string]string{"alice": "uploader", "bob": "editor"}
+	handler := server.newWebDAVHandler("/")
+	as := func(user string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, withIdentity(r, &Identity{Name: user}))
+		})
+	}
+
+	for _, step := range []struct {
+		user    string
+		method  string
+		target  string
+		headers map[string]string
+		status  int
+	}{
+		// replacing or removing a file requires the delete capability
+		{"alice", "PUT", "/webdav/data/new.txt", nil, 201},
+		{"alice", "PUT", "/webdav/data/notes.txt", nil, 403},
+		{"alice", "COPY", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/copy.txt"}, 201},
+		{"alice", "COPY", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/new.txt"}, 403},
+		{"alice", "COPY", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/new.txt", "Overwrite": "T"}, 403},
+		{"alice", "COPY", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/new.txt", "Overwrite": "F"}, 412},
+		{"alice", "MOVE", "/webdav/data/copy.txt", map[string]string{"Destination": "/webdav/data/moved.txt"}, 403},
+		{"alice", "DELETE", "/webdav/data/copy.txt", nil, 403},
+		{"bob", "PUT", "/webdav/data/notes.txt", nil, 201},
+		{"bob", "COPY", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/new.txt"}, 204},
+		{"bob", "MOVE", "/webdav/data/copy.txt", map[string]string{"Destination": "/webdav/data/moved.txt"}, 201},
+		{"bob", "DELETE", "/webdav/data/moved.txt", nil, 204},
+		{"carol", "PROPFIND", "/webdav/data/", nil, 403},
+	} {
+		w := webdavRequest(as(step.user), step.method, step.target, step.user, step.headers)
+		if w.Code != step.status {
+			t.Fatalf("Unexpected status of %s %s by %s instead of %d: %d %s", step.method, step.target, step.user, step.status, w.Code, w.Body)
+		}
+	}
+	for name, content := range map[string]string{"notes.txt": "bob", "new.txt": "bob"} {
+		if data, err := os.ReadFile(filepath.Join(data, name)); err != nil || string(data) != content {
+			t.Fatalf("Unexpected file %s: %q %v", name, data, err)
+		}
+	}
+	for _, name := range []string{"copy.txt", "moved.txt"} {
+		if _, err := os.Stat(filepath.Join(data, name)); err == nil {
+			t.Fatalf("File %s not removed", name)
+		}
+	}
+}
+
+func TestWebDAVLocks(t *testing.T) {
+	server, _ := newFileOpsServer(t)
+	handler := server.newWebDAVHandler("/")
+	lock := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+
+	// infinite locks would never be cleared
+	w := webdavRequest(handler, "LOCK", "/webdav/data/notes.txt", lock, map[string]string{"Timeout": "Infinite"})
+	token := w.Header().Get("Lock-Token")
+	if w.Code != 200 || token == "" || !strings.Contains(w.Body.String(), "Second-3600") {
+		t.Fatalf("Unexpected response of LOCK: %d %s", w.Code, w.Body)
+	}
+	if w := webdavRequest(handler, "PUT", "/webdav/data/notes.txt", "changed", nil); w.Code != 423 {
+		t.Fatalf("Unexpected status of PUT of a locked file: %d", w.Code)
+	}
+	if w := webdavRequest(handler, "PUT", "/webdav/data/notes.txt", "changed", map[string]string{"If": "(" + token + ")"}); w.Code != 201 {
+		t.Fatalf("Unexpected status of PUT with the lock: %d %s", w.Code, w.Body)
+	}
+	if w := webdavRequest(handler, "UNLOCK", "/webdav/data/notes.txt", "", map[string]string{"Lock-Token": token}); w.Code != 204 {
+		t.Fatalf("Unexpected status of UNLOCK: %d", w.Code)
+	}
+	if w := webdavRequest(handler, "DELETE", "/webdav/data/notes.txt", "", nil); w.Code != 204 {
+		t.Fatalf("Unexpected status of DELETE of an unlocked file: %d", w.Code)
+	}
+
+	for requested, granted := range map[string]string{"": "Second-3600", "Infinite, Second-4100000000": "Second-3600", "Second-7200": "Second-3600", "Second-60": "Second-60", "invalid": "invalid"} {
+		if timeout := webdavLockTimeout(requested); timeout != granted {
+			t.Fatalf("Unexpected timeout of a lock requested for %q: %s", requested, timeout)
+		}
+	}
+}
+
+func TestWebDAVHiddenTemp(t *testing.T) {
+	server, dir := newFileOpsServer(t)
+	handler := server.newWebDAVHandler("/")
+	record := filepath.Join(dir, "data", ".temp", "tus", "abc.json")
+	os.MkdirAll(filepath.Dir(record), 0755)
+	os.WriteFile(record, []byte(`{"user": "alice"}`), 0644)
+
+	w := webdavRequest(handler, "PROPFIND", "/webdav/data/", "", map[string]string{"Depth": "infinity"})
+	if w.Code != 207 || strings.Contains(w.Body.String(), ".temp") || !strings.Contains(w.Body.String(), "notes.txt") {
+		t.Fatalf("Unexpected response of PROPFIND: %d %s", w.Code, w.Body)
+	}
+	for _, step := range []struct {
+		method  string
+		target  string
+		headers map[string]string
+	}{
+		{"PROPFIND", "/webdav/data/.temp/", nil},
+		{"GET", "/webdav/data/.temp/tus/abc.json", nil},
+		{"GET", "/webdav/data/.TEMP/tus/abc.json", nil},
+		{"PUT", "/webdav/data/.temp/tus/abc.json", nil},
+		{"PUT", "/webdav/data/.temp/tus/new.json", nil},
+		{"DELETE", "/webdav/data/.temp/tus/abc.json", nil},
+		{"DELETE", "/webdav/data/.temp", nil},
+		{"MKCOL", "/webdav/data/.temp/other", nil},
+		{"COPY", "/webdav/data/.temp/tus/abc.json", map[string]string{"Destination": "/webdav/data/abc.json"}},
+		{"COPY", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/.temp/tus/abc.json"}},
+		{"MOVE", "/webdav/data/notes.txt", map[string]string{"Destination": "/webdav/data/.temp/tus/abc.json"}},
+		{"MOVE", "/webdav/data/.temp", map[string]string{"Destination": "/webdav/data/temp"}},
+	} {
+		w := webdavRequest(handler, step.method, step.target, `{"user": "mallory"}`, step.headers)
+		if w.Code < 400 || strings.Contains(w.Body.String(), "alice") {
+			t.Fatalf("Temporary files accessed by %s %s: %d %s", step.method, step.target, w.Code, w.Body)
+		}
+	}
+	if content, err := os.ReadFile(record); err != nil || string(content) != `{"user": "alice"}` {
+		t.Fatalf("Temporary file changed: %s %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data", ".temp", "tus", "new.json")); err == nil {
+		t.Fatalf("File created in the temporary directory")
+	}
+}
@@ -5,14 +5,30 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+
+	"gotty/webtty"
 )
 
 type wsWrapper struct {
 	*websocket.Conn
+	// messageType is the frame type used for outgoing messages
+	messageType int
+}
+
+func newWSWrapper(conn *websocket.Conn) *wsWrapper {
+	messageType := websocket.TextMessage
+	if conn.Subprotocol() == webtty.BinaryProtocol {
+		messageType = websocket.BinaryMessage
+	}
+	return &wsWrapper{Conn: conn, messageType: messageType}
+}
+
+func (wsw *wsWrapper) binary() bool {
+	return wsw.messageType == websocket.BinaryMessage
 }
 
 func (wsw *wsWrapper) Write(p []byte) (n int, err error) {
-	writer, err := wsw.Conn.NextWriter(websocket.TextMessage)
+	writer, err := wsw.Conn.NextWriter(wsw.messageType)
 	if err != nil {
 		return 0, err
 	}
@@ -27,7 +43,8 @@ func (wsw *wsWrapper) Read(p []byte) (n int, err error) {
 			return 0, err
 		}
 
-		if msgType != websocket.TextMessage {
+		// binary protocol clients may still send control messages as text
+		if msgType != websocket.TextMessage && !(wsw.binary() && msgType == websocket.BinaryMessage) {
 			continue
 		}
 
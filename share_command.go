@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+
+	"gotty/pkg/sharelink"
+	"gotty/server"
+	"gotty/utils"
+)
+
+type shareOptions struct {
+	Permission string `flagName:"permission" flagDescribe:"Access to the terminal, view or write" default:"view"`
+	File       string `flagName:"file" flagDescribe:"Share this file of the file manager instead of the terminal" default:""`
+	Root       string `flagName:"root" flagDescribe:"File manager root of the shared file (default the first root)" default:""`
+	TTL        int    `flagName:"ttl" flagDescribe:"Seconds the link is valid" default:"1800"`
+}
+
+// shareCommand prints a share link signed with the share secret of a running gotty.
+func shareCommand(appOptions *server.Options, cliFlags []cli.Flag, flagMappings map[string]string) *cli.Command {
+	options := &shareOptions{}
+	if err := utils.ApplyDefaultValues(options); err != nil {
+		exit(err, 1)
+	}
+	shareFlags, shareMappings, err := utils.GenerateFlags(options)
+	if err != nil {
+		exit(err, 3)
+	}
+
+	return &cli.Command{
+		Name:      "share",
+		Usage:     "Create a link to a terminal or a file that expires",
+		ArgsUsage: "<URL of gotty>",
+		Flags:     shareFlags,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				cli.ShowCommandHelp(c, "share")
+				exit(fmt.Errorf("Error: The URL of gotty is required."), 1)
+			}
+
+			loadConfigFile(c, appOptions)
+			utils.ApplyFlags(cliFlags, flagMappings, c, appOptions)
+			utils.ApplyFlags(shareFlags, shareMappings, c, options)
+			if appOptions.ShareSecret == "" {
+				exit(fmt.Errorf("Error: The share_secret of the gotty server is required."), 6)
+			}
+
+			kind := sharelink.Terminal
+			if options.File != "" {
+				kind = sharelink.File
+				options.File = filepath.Clean(options.File)
+			}
+			link, err := sharelink.New(kind, options.Permission, options.File, time.Duration(options.TTL)*time.Second)
+			if err != nil {
+				exit(err, 6)
+			}
+			if kind == sharelink.File {
+				link.Root = options.Root
+			}
+			token, err := sharelink.Sign([]byte(appOptions.ShareSecret), link)
+			if err != nil {
+				exit(err, 3)
+			}
+
+			fmt.Println(sharelink.URL(c.Args().First(), link, token))
+			fmt.Fprintf(os.Stderr, "ID: %s, expires at %s\n", link.ID, link.ExpiresAt().Format(time.RFC3339))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	cli "github.com/urfave/cli/v2"
+
+	"gotty/backend/sshcommand"
+	"gotty/server"
+	"gotty/utils"
+)
+
+// sshCommand serves sessions on a remote host over SSH.
+// Without a host, clients choose one from --allowed-hosts with the host parameter.
+func sshCommand(appOptions *server.Options, cliFlags []cli.Flag, flagMappings map[string]string) *cli.Command {
+	sshOptions := &sshcommand.Options{}
+	if err := utils.ApplyDefaultValues(sshOptions); err != nil {
+		exit(err, 1)
+	}
+	sshFlags, sshMappings, err := utils.GenerateFlags(sshOptions)
+	if err != nil {
+		exit(err, 3)
+	}
+
+	return &cli.Command{
+		Name:      "ssh",
+		Usage:     "Open terminals on a remote host over SSH",
+		ArgsUsage: "[[user@]host[:port] [command...]]",
+		Flags:     sshFlags,
+		Action: func(c *cli.Context) error {
+			loadConfigFile(c, appOptions, sshOptions)
+			utils.ApplyFlags(cliFlags, flagMappings, c, appOptions)
+			utils.ApplyFlags(sshFlags, sshMappings, c, sshOptions)
+			prepareOptions(c, appOptions)
+
+			args := c.Args()
+			command := strings.Join(args.Tail(), " ")
+			factory, err := sshcommand.NewFactory(args.First(), command, sshOptions)
+			if err != nil {
+				exit(err, 3)
+			}
+
+			hostname, _ := os.Hostname()
+			appOptions.TitleVariables = map[string]interface{}{
+				"command":  "ssh",
+				"argv":     args.Slice(),
+				"hostname": hostname,
+			}
+
+			target := args.First()
+			if target == "" {
+				target = sshOptions.AllowedHosts
+			}
+			serve(factory, appOptions, fmt.Sprintf("ssh: %s", target))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	cli "github.com/urfave/cli/v2"
+
+	"gotty/pkg/totp"
+)
+
+// totpEnrollCommand prints a new TOTP secret of a user and the otpauth URI
+// to add it to an authenticator app.
+func totpEnrollCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "totp-enroll",
+		Usage:     "Create a TOTP secret for the two-factor login of a user",
+		ArgsUsage: "<user>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "issuer",
+				Value: "GoTTY",
+				Usage: "Name of the service shown in authenticator apps",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				cli.ShowCommandHelp(c, "totp-enroll")
+				exit(fmt.Errorf("Error: A user is required."), 1)
+			}
+			user := c.Args().First()
+
+			secret, err := totp.GenerateSecret()
+			if err != nil {
+				exit(err, 3)
+			}
+
+			fmt.Println(totp.URI(c.String("issuer"), user, secret))
+			fmt.Println()
+			fmt.Println("Add the URI to an authenticator app, and the secret to the config file:")
+			fmt.Println()
+			fmt.Println("totp_secrets {")
+			fmt.Printf("  %q = %q\n", user, secret)
+			fmt.Println("}")
+			return nil
+		},
+	}
+}
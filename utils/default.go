@@ -34,6 +34,11 @@ func ApplyDefaultValues(struct_ interface{}) (err error) {
 			if err != nil {
 				return err
 			}
+		case reflect.Float64:
+			val, err = strconv.ParseFloat(defaultValue, 64)
+			if err != nil {
+				return err
+			}
 		default:
 			val = field.Value()
 		}
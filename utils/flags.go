@@ -59,6 +59,14 @@ func GenerateFlags(options ...interface{}) (flags []cli.Flag, mappings map[strin
 					EnvVars: []string{envName},
 					Aliases: aliases,
 				})
+			case reflect.Float64:
+				flags = append(flags, &cli.Float64Flag{
+					Name:    flagName,
+					Value:   field.Value().(float64),
+					Usage:   flagDescription,
+					EnvVars: []string{envName},
+					Aliases: aliases,
+				})
 			}
 		}
 	}
@@ -100,6 +108,8 @@ func ApplyFlags(
 			val = c.Bool(flagName)
 		case reflect.Int:
 			val = c.Int(flagName)
+		case reflect.Float64:
+			val = c.Float64(flagName)
 		}
 		field.Set(val)
 	}
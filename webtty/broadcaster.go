@@ -0,0 +1,243 @@
+package webtty
+
+import (
+	"io"
+	"sync"
+)
+
+// ResizePolicy decides which viewers of a Broadcaster control the terminal size.
+type ResizePolicy int
+
+const (
+	// ResizeOwner applies resize requests from the oldest viewer only.
+	// When it detaches, the next oldest viewer takes over.
+	ResizeOwner ResizePolicy = iota
+	// ResizeLatest applies every resize request from any viewer.
+	ResizeLatest
+	// ResizeSmallest fits the terminal into the smallest viewer.
+	ResizeSmallest
+)
+
+// viewerQueueSize is the number of output chunks buffered for each viewer.
+// Viewers that fall further behind are detached.
+const viewerQueueSize = 256
+
+// Broadcaster shares a single Slave between multiple viewers.
+// Output of the slave is copied to every attached Viewer and
+// input from any Viewer is written to the slave.
+// Broadcaster never closes the slave, that is caller's responsibility.
+type Broadcaster struct {
+	slave          Slave
+	policy         ResizePolicy
+	scrollbackSize int
+
+	mutex   sync.Mutex
+	viewers []*Viewer // in order of attachment
+	// scrollback keeps the last scrollbackSize bytes of output
+	scrollback []byte
+	closed     bool
+	done       chan struct{}
+}
+
+// BroadcasterOption is an option for Broadcaster.
+type BroadcasterOption func(*Broadcaster)
+
+// WithScrollback replays up to size bytes of the latest output
+// to viewers when they attach.
+func WithScrollback(size int) BroadcasterOption {
+	return func(b *Broadcaster) {
+		b.scrollbackSize = size
+	}
+}
+
+// NewBroadcaster creates a new instance of Broadcaster and
+// starts reading output from the slave.
+func NewBroadcaster(slave Slave, policy ResizePolicy, options ...BroadcasterOption) *Broadcaster {
+	b := &Broadcaster{
+		slave:  slave,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	for _, option := range options {
+		option(b)
+	}
+	go b.run()
+	return b
+}
+
+func (b *Broadcaster) run() {
+	buffer := make([]byte, 1024)
+	for {
+		n, err := b.slave.Read(buffer)
+		if err != nil {
+			break
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+
+		b.mutex.Lock()
+		if b.scrollbackSize > 0 {
+			b.scrollback = append(b.scrollback, data...)
+			if over := len(b.scrollback) - b.scrollbackSize; over > 0 {
+				b.scrollback = b.scrollback[over:]
+			}
+		}
+		// detaching changes b.viewers, so slow viewers are detached after sending
+		var slow []*Viewer
+		for _, viewer := range b.viewers {
+			select {
+			case viewer.output <- data:
+			default:
+				slow = append(slow, viewer)
+			}
+		}
+		for _, viewer := range slow {
+			b.detachLocked(viewer)
+		}
+		b.mutex.Unlock()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for _, viewer := range b.viewers {
+		close(viewer.output)
+	}
+	b.viewers = nil
+	close(b.done)
+}
+
+// Attach adds a new viewer to the slave.
+// The viewer first receives the scrollback, if any.
+// It returns ErrSlaveClosed when the slave has already finished.
+func (b *Broadcaster) Attach() (*Viewer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrSlaveClosed
+	}
+	viewer := &Viewer{
+		broadcaster: b,
+		output:      make(chan []byte, viewerQueueSize),
+	}
+	if len(b.scrollback) > 0 {
+		viewer.output <- append([]byte(nil), b.scrollback...)
+	}
+	b.viewers = append(b.viewers, viewer)
+	return viewer, nil
+}
+
+// Viewers returns the number of attached viewers.
+func (b *Broadcaster) Viewers() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.viewers)
+}
+
+// Done returns a channel that is closed when the output of the slave ends.
+func (b *Broadcaster) Done() <-chan struct{} {
+	return b.done
+}
+
+func (b *Broadcaster) detachLocked(viewer *Viewer) {
+	for i, v := range b.viewers {
+		if v == viewer {
+			b.viewers = append(b.viewers[:i], b.viewers[i+1:]...)
+			close(viewer.output)
+			b.resizeLocked(nil)
+			return
+		}
+	}
+}
+
+// resizeLocked applies the terminal size decided by the policy.
+// requester is the viewer that asked for a new size, nil when viewers changed.
+func (b *Broadcaster) resizeLocked(requester *Viewer) error {
+	var columns, rows int
+	switch b.policy {
+	case ResizeLatest:
+		if requester == nil {
+			return nil
+		}
+		columns, rows = requester.columns, requester.rows
+	case ResizeSmallest:
+		for _, viewer := range b.viewers {
+			if viewer.columns == 0 || viewer.rows == 0 {
+				continue
+			}
+			if columns == 0 || viewer.columns < columns {
+				columns = viewer.columns
+			}
+			if rows == 0 || viewer.rows < rows {
+				rows = viewer.rows
+			}
+		}
+	default:
+		if len(b.viewers) == 0 || (requester != nil && requester != b.viewers[0]) {
+			return nil
+		}
+		columns, rows = b.viewers[0].columns, b.viewers[0].rows
+	}
+
+	if columns == 0 || rows == 0 {
+		return nil
+	}
+	return b.slave.ResizeTerminal(columns, rows)
+}
+
+// Viewer is a Slave attached to a Broadcaster.
+type Viewer struct {
+	broadcaster *Broadcaster
+	output      chan []byte
+	pending     []byte
+
+	// last size requested by this viewer, guarded by the broadcaster mutex
+	columns int
+	rows    int
+}
+
+// Read returns output of the shared slave.
+// It returns io.EOF once the viewer is detached or the slave is closed.
+func (v *Viewer) Read(p []byte) (int, error) {
+	if len(v.pending) == 0 {
+		data, ok := <-v.output
+		if !ok {
+			return 0, io.EOF
+		}
+		v.pending = data
+	}
+	n := copy(p, v.pending)
+	v.pending = v.pending[n:]
+	return n, nil
+}
+
+// Write sends input to the shared slave.
+func (v *Viewer) Write(p []byte) (int, error) {
+	return v.broadcaster.slave.Write(p)
+}
+
+// WindowTitleVariables returns the variables of the shared slave.
+func (v *Viewer) WindowTitleVariables() map[string]interface{} {
+	return v.broadcaster.slave.WindowTitleVariables()
+}
+
+// ResizeTerminal records the size of this viewer and resizes
+// the shared slave according to the ResizePolicy.
+func (v *Viewer) ResizeTerminal(columns int, rows int) error {
+	v.broadcaster.mutex.Lock()
+	defer v.broadcaster.mutex.Unlock()
+
+	v.columns, v.rows = columns, rows
+	return v.broadcaster.resizeLocked(v)
+}
+
+// Close detaches the viewer from the broadcaster.
+func (v *Viewer) Close() error {
+	v.broadcaster.mutex.Lock()
+	defer v.broadcaster.mutex.Unlock()
+
+	v.broadcaster.detachLocked(v)
+	return nil
+}
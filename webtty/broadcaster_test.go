@@ -0,0 +1,134 @@
+package webtty
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBroadcasterFanOut(t *testing.T) {
+	mSlave := newMockSlave()
+	defer mSlave.close()
+	b := NewBroadcaster(mSlave, ResizeOwner)
+
+	first, err := b.Attach()
+	if err != nil {
+		t.Fatalf("Unexpected error from Attach(): %s", err)
+	}
+	second, err := b.Attach()
+	if err != nil {
+		t.Fatalf("Unexpected error from Attach(): %s", err)
+	}
+
+	message := []byte("foobar")
+	mSlave.slaveToGottyWriter.Write(message)
+
+	for _, viewer := range []*Viewer{first, second} {
+		buf := make([]byte, 1024)
+		n, err := viewer.Read(buf)
+		if err != nil {
+			t.Fatalf("Unexpected error from Read(): %s", err)
+		}
+		if !bytes.Equal(buf[:n], message) {
+			t.Fatalf("Unexpected message received: `%s`", buf[:n])
+		}
+	}
+
+	second.Close()
+	if _, err := second.Read(make([]byte, 1024)); err != io.EOF {
+		t.Fatalf("Expected io.EOF from detached viewer, got %v", err)
+	}
+	if b.Viewers() != 1 {
+		t.Fatalf("Unexpected number of viewers: %d", b.Viewers())
+	}
+}
+
+func TestBroadcasterResizePolicy(t *testing.T) {
+	mSlave := newMockSlave()
+	defer mSlave.close()
+	b := NewBroadcaster(mSlave, ResizeOwner)
+
+	owner, _ := b.Attach()
+	viewer, _ := b.Attach()
+
+	mSlave.wg.Add(1)
+	owner.ResizeTerminal(80, 24)
+	if mSlave.columns != 80 || mSlave.rows != 24 {
+		t.Fatalf("Unexpected size %dx%d", mSlave.columns, mSlave.rows)
+	}
+
+	// only the owner controls the size
+	viewer.ResizeTerminal(120, 40)
+	if mSlave.columns != 80 || mSlave.rows != 24 {
+		t.Fatalf("Unexpected size %dx%d", mSlave.columns, mSlave.rows)
+	}
+
+	// the remaining viewer takes over when the owner leaves
+	mSlave.wg.Add(1)
+	owner.Close()
+	if mSlave.columns != 120 || mSlave.rows != 40 {
+		t.Fatalf("Unexpected size %dx%d", mSlave.columns, mSlave.rows)
+	}
+}
+
+func TestBroadcasterScrollback(t *testing.T) {
+	mSlave := newMockSlave()
+	defer mSlave.close()
+	b := NewBroadcaster(mSlave, ResizeOwner, WithScrollback(6))
+
+	first, _ := b.Attach()
+	mSlave.slaveToGottyWriter.Write([]byte("foo"))
+	mSlave.slaveToGottyWriter.Write([]byte("barbaz"))
+	// wait until both chunks went through the broadcaster
+	buf := make([]byte, 1024)
+	for total := 0; total < 9; {
+		n, err := first.Read(buf)
+		if err != nil {
+			t.Fatalf("Unexpected error from Read(): %s", err)
+		}
+		total += n
+	}
+
+	second, _ := b.Attach()
+	n, err := second.Read(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error from Read(): %s", err)
+	}
+	if string(buf[:n]) != "barbaz" {
+		t.Fatalf("Unexpected scrollback: `%s`", buf[:n])
+	}
+}
+
+func TestBroadcasterSlowViewer(t *testing.T) {
+	mSlave := newMockSlave()
+	defer mSlave.close()
+	b := NewBroadcaster(mSlave, ResizeOwner)
+
+	first, _ := b.Attach()
+	blocked, _ := b.Attach()
+	third, _ := b.Attach()
+	// a viewer after the third one catches frames sent twice
+	last, _ := b.Attach()
+	for i := 0; i < viewerQueueSize; i++ {
+		blocked.output <- []byte("x")
+	}
+
+	mSlave.slaveToGottyWriter.Write([]byte("foo"))
+	mSlave.slaveToGottyWriter.Write([]byte("bar"))
+
+	for _, viewer := range []*Viewer{first, third, last} {
+		for _, message := range []string{"foo", "bar"} {
+			buf := make([]byte, 1024)
+			n, err := viewer.Read(buf)
+			if err != nil {
+				t.Fatalf("Unexpected error from Read(): %s", err)
+			}
+			if string(buf[:n]) != message {
+				t.Fatalf("Unexpected message received instead of `%s`: `%s`", message, buf[:n])
+			}
+		}
+	}
+	if b.Viewers() != 3 {
+		t.Fatalf("Unexpected number of viewers: %d", b.Viewers())
+	}
+}
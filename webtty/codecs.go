@@ -1,5 +1,12 @@
 package webtty
 
+import (
+	"bytes"
+	"compress/flate"
+
+	"github.com/pkg/errors"
+)
+
 type Decoder interface {
 	Decode(dst, src []byte) (int, error)
 }
@@ -17,3 +24,44 @@ func (NullCodec) Encode(dst, src []byte) (int, error) {
 func (NullCodec) Decode(dst, src []byte) (int, error) {
 	return copy(dst, src), nil
 }
+
+// DeflateEncoder compresses a stream with raw DEFLATE (RFC 1951).
+// The compression window is shared between calls to Encode,
+// so the peer must inflate the encoded chunks in order with a single stream.
+type DeflateEncoder struct {
+	buffer bytes.Buffer
+	writer *flate.Writer
+}
+
+// NewDeflateEncoder creates a DeflateEncoder with the given compression level.
+func NewDeflateEncoder(level int) (*DeflateEncoder, error) {
+	enc := &DeflateEncoder{}
+	writer, err := flate.NewWriter(&enc.buffer, level)
+	if err != nil {
+		return nil, err
+	}
+	enc.writer = writer
+	return enc, nil
+}
+
+// Encode compresses src and flushes the stream so that the peer can
+// inflate dst immediately. dst must be at least DeflateBound(len(src)) bytes.
+func (enc *DeflateEncoder) Encode(dst, src []byte) (int, error) {
+	enc.buffer.Reset()
+	if _, err := enc.writer.Write(src); err != nil {
+		return 0, err
+	}
+	if err := enc.writer.Flush(); err != nil {
+		return 0, err
+	}
+	if enc.buffer.Len() > len(dst) {
+		return 0, errors.New("destination buffer too small for deflate output")
+	}
+	return copy(dst, enc.buffer.Bytes()), nil
+}
+
+// DeflateBound returns the maximum size of a flushed deflate block for n bytes of input.
+func DeflateBound(n int) int {
+	// stored blocks cost 5 bytes per 64KiB, plus the empty block emitted by Flush
+	return n + (n/65535+1)*5 + 10
+}
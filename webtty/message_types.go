@@ -2,7 +2,17 @@ package webtty
 
 // Protocols defines the name of this protocol,
 // which is supposed to be used to the subprotocol of Websockt streams.
-var Protocols = []string{"webtty"}
+// Protocols are listed in the order of preference.
+var Protocols = []string{BinaryProtocol, TextProtocol}
+
+const (
+	// TextProtocol sends every message as a text frame,
+	// terminal output is base64 encoded.
+	TextProtocol = "webtty"
+	// BinaryProtocol sends messages as binary frames,
+	// terminal input and output travel as raw bytes after the type prefix.
+	BinaryProtocol = "webtty.v2"
+)
 
 const (
 	// Unknown message type, maybe sent by a bug
@@ -15,6 +25,10 @@ const (
 	ResizeTerminal = '3'
 	// Change encoding
 	SetEncoding = '4'
+	// Ask for compressed output
+	RequestCompression = '5'
+	// Control message for slaves implementing Controller
+	Control = '6'
 )
 
 const (
@@ -32,4 +46,15 @@ const (
 	SetReconnect = '5'
 	// Set the input buffer size
 	SetBufferSize = '6'
+	// Notify that following output is compressed
+	SetCompression = '7'
+	// Set the ID to reattach to the session after reconnection
+	SetSessionID = '8'
+	// Report the state of a slave implementing Controller
+	SetControlState = '9'
+)
+
+const (
+	// CompressionDeflate compresses output with a shared raw DEFLATE stream
+	CompressionDeflate = "deflate"
 )
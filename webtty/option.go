@@ -17,6 +17,27 @@ func WithPermitWrite() Option {
 	}
 }
 
+// WithBinaryProtocol sends terminal output to the master without base64 encoding.
+// Use this when the master negotiated BinaryProtocol.
+func WithBinaryProtocol() Option {
+	return func(wt *WebTTY) error {
+		wt.binary = true
+		return nil
+	}
+}
+
+// WithCompression permits the master to request compressed output
+// with the given algorithm. Currently only CompressionDeflate is supported.
+func WithCompression(algorithm string) Option {
+	return func(wt *WebTTY) error {
+		if algorithm != CompressionDeflate {
+			return errors.Errorf("unsupported compression `%s`", algorithm)
+		}
+		wt.compression = algorithm
+		return nil
+	}
+}
+
 // WithFixedColumns sets a fixed width to TTY master.
 func WithFixedColumns(columns int) Option {
 	return func(wt *WebTTY) error {
@@ -49,6 +70,14 @@ func WithReconnect(timeInSeconds int) Option {
 	}
 }
 
+// WithSessionID tells the master which session to reattach to when it reconnects.
+func WithSessionID(id string) Option {
+	return func(wt *WebTTY) error {
+		wt.sessionID = id
+		return nil
+	}
+}
+
 // WithMasterPreferences sets an optional configuration of master.
 func WithMasterPreferences(preferences interface{}) Option {
 	return func(wt *WebTTY) error {
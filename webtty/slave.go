@@ -15,3 +15,11 @@ type Slave interface {
 	// ResizeTerminal sets a new size of the terminal.
 	ResizeTerminal(columns int, rows int) error
 }
+
+// Controller is implemented by slaves that accept control messages
+// from the master, such as playback controls of a recording.
+type Controller interface {
+	// Control applies a control message and returns the state to report to the master.
+	// A nil message only queries the state.
+	Control(message []byte) (state []byte, err error)
+}
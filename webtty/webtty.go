@@ -1,6 +1,7 @@
 package webtty
 
 import (
+	"compress/flate"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -23,8 +24,13 @@ type WebTTY struct {
 	columns     int
 	rows        int
 	reconnect   int // in seconds
+	sessionID   string
 	masterPrefs []byte
 	decoder     Decoder
+	binary      bool
+	compression string
+	// encoder compresses output once the master requested compression
+	encoder Encoder
 
 	bufferSize int
 	writeMutex sync.Mutex
@@ -48,7 +54,9 @@ func New(masterConn Master, slave Slave, options ...Option) (*WebTTY, error) {
 	}
 
 	for _, option := range options {
-		option(wt)
+		if err := option(wt); err != nil {
+			return nil, err
+		}
 	}
 
 	return wt, nil
@@ -72,10 +80,13 @@ func (wt *WebTTY) Run(ctx context.Context) error {
 		errs <- func() error {
 			buffer := make([]byte, wt.bufferSize)
 			for {
-				//base64 length
 				effectiveBufferSize := wt.bufferSize - 1
 				//max raw data length
-				maxChunkSize := int(effectiveBufferSize/4) * 3
+				maxChunkSize := effectiveBufferSize
+				if !wt.binary {
+					//base64 length
+					maxChunkSize = int(effectiveBufferSize/4) * 3
+				}
 
 				n, err := wt.slave.Read(buffer[:maxChunkSize])
 				if err != nil {
@@ -136,6 +147,24 @@ func (wt *WebTTY) sendInitializeMessage() error {
 		}
 	}
 
+	if wt.sessionID != "" {
+		err := wt.masterWrite(append([]byte{SetSessionID}, wt.sessionID...))
+		if err != nil {
+			return errors.Wrapf(err, "failed to set session ID")
+		}
+	}
+
+	if controller, ok := wt.slave.(Controller); ok {
+		state, err := controller.Control(nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get control state")
+		}
+		err = wt.masterWrite(append([]byte{SetControlState}, state...))
+		if err != nil {
+			return errors.Wrapf(err, "failed to send control state")
+		}
+	}
+
 	if wt.masterPrefs != nil {
 		err := wt.masterWrite(append([]byte{SetPreferences}, wt.masterPrefs...))
 		if err != nil {
@@ -147,8 +176,27 @@ func (wt *WebTTY) sendInitializeMessage() error {
 }
 
 func (wt *WebTTY) handleSlaveReadEvent(data []byte) error {
-	safeMessage := base64.StdEncoding.EncodeToString(data)
-	err := wt.masterWrite(append([]byte{Output}, []byte(safeMessage)...))
+	// the encoder state must follow the order of messages on the wire
+	wt.writeMutex.Lock()
+	defer wt.writeMutex.Unlock()
+
+	if wt.encoder != nil {
+		encoded := make([]byte, DeflateBound(len(data)))
+		n, err := wt.encoder.Encode(encoded, data)
+		if err != nil {
+			return errors.Wrapf(err, "failed to compress output")
+		}
+		data = encoded[:n]
+	}
+
+	var message []byte
+	if wt.binary {
+		message = append([]byte{Output}, data...)
+	} else {
+		safeMessage := base64.StdEncoding.EncodeToString(data)
+		message = append([]byte{Output}, []byte(safeMessage)...)
+	}
+	err := wt.masterWriteLocked(message)
 	if err != nil {
 		return errors.Wrapf(err, "failed to send message to master")
 	}
@@ -156,10 +204,35 @@ func (wt *WebTTY) handleSlaveReadEvent(data []byte) error {
 	return nil
 }
 
+func (wt *WebTTY) enableCompression(algorithm string) error {
+	encoder, err := NewDeflateEncoder(flate.DefaultCompression)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create compressor")
+	}
+
+	wt.writeMutex.Lock()
+	defer wt.writeMutex.Unlock()
+
+	if wt.encoder != nil {
+		return nil
+	}
+	err = wt.masterWriteLocked(append([]byte{SetCompression}, algorithm...))
+	if err != nil {
+		return errors.Wrapf(err, "failed to send compression to master")
+	}
+	wt.encoder = encoder
+
+	return nil
+}
+
 func (wt *WebTTY) masterWrite(data []byte) error {
 	wt.writeMutex.Lock()
 	defer wt.writeMutex.Unlock()
 
+	return wt.masterWriteLocked(data)
+}
+
+func (wt *WebTTY) masterWriteLocked(data []byte) error {
 	_, err := wt.masterConn.Write(data)
 	if err != nil {
 		return errors.Wrapf(err, "failed to write to master")
@@ -208,6 +281,27 @@ func (wt *WebTTY) handleMasterReadEvent(data []byte) error {
 			wt.decoder = NullCodec{}
 		}
 
+	case RequestCompression:
+		algorithm := string(data[1:])
+		if wt.compression == "" || algorithm != wt.compression {
+			break
+		}
+		return wt.enableCompression(algorithm)
+
+	case Control:
+		controller, ok := wt.slave.(Controller)
+		if !ok || len(data) <= 1 {
+			break
+		}
+		state, err := controller.Control(data[1:])
+		if err != nil {
+			return errors.Wrapf(err, "received malformed control message")
+		}
+		err = wt.masterWrite(append([]byte{SetControlState}, state...))
+		if err != nil {
+			return errors.Wrapf(err, "failed to send control state")
+		}
+
 	case ResizeTerminal:
 		if wt.columns != 0 && wt.rows != 0 {
 			break
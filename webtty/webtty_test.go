@@ -2,6 +2,7 @@ package webtty
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
 	"encoding/base64"
 	"io"
@@ -47,6 +48,15 @@ func TestInitializationWithReconnect(t *testing.T) {
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetReconnect)
 }
 
+func TestNewOptionError(t *testing.T) {
+	if _, err := New(nil, nil, WithCompression("gzip")); err == nil {
+		t.Fatalf("No error from New() with an unsupported compression")
+	}
+	if _, err := New(nil, nil, WithMasterPreferences(func() {})); err == nil {
+		t.Fatalf("No error from New() with preferences that can't be marshalled")
+	}
+}
+
 func TestWriteFromSlaveCommand(t *testing.T) {
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -87,6 +97,87 @@ func TestWriteFromSlaveCommand(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestWriteFromSlaveCommandBinary(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	mMaster, mSlave, _, cancel := prepareSUT(t, &wg, WithBinaryProtocol())
+	defer cancel()
+
+	// Check that the initialization happens as expected
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+
+	// Bytes that are not valid UTF-8 must make it through untouched
+	message := []byte{'f', 'o', 'o', 0x00, 0xff, 0xfe}
+	mSlave.slaveToGottyWriter.Write(message)
+
+	buf := make([]byte, 1024)
+	n, err := mMaster.gottyToMasterReader.Read(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error from Read(): %s", err)
+	}
+	if buf[0] != Output {
+		t.Fatalf("Unexpected message type `%c`", buf[0])
+	}
+	if !bytes.Equal(buf[1:n], message) {
+		t.Fatalf("Unexpected message received: `%v`", buf[1:n])
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestCompressedOutput(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	mMaster, mSlave, _, cancel := prepareSUT(t, &wg, WithBinaryProtocol(), WithCompression(CompressionDeflate))
+	defer cancel()
+
+	// Absorb initialization messages
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+
+	mMaster.masterToGottyWriter.Write([]byte("5" + CompressionDeflate))
+	msgType, payload := nextMsg(t, mMaster.gottyToMasterReader)
+	if msgType != SetCompression {
+		t.Fatalf("Unexpected message type `%c`", msgType)
+	}
+	if !bytes.HasPrefix(payload, []byte(CompressionDeflate)) {
+		t.Fatalf("Unexpected compression `%s`", payload)
+	}
+
+	// Both messages must inflate from a single stream
+	inflater, writer := io.Pipe()
+	reader := flate.NewReader(inflater)
+	for _, message := range []string{"foobar", "foobarbaz"} {
+		mSlave.slaveToGottyWriter.Write([]byte(message))
+
+		buf := make([]byte, 1024)
+		n, err := mMaster.gottyToMasterReader.Read(buf)
+		if err != nil {
+			t.Fatalf("Unexpected error from Read(): %s", err)
+		}
+		if buf[0] != Output {
+			t.Fatalf("Unexpected message type `%c`", buf[0])
+		}
+		go writer.Write(buf[1:n])
+
+		decoded := make([]byte, len(message))
+		if _, err := io.ReadFull(reader, decoded); err != nil {
+			t.Fatalf("Unexpected error from inflate: %s", err)
+		}
+		if string(decoded) != message {
+			t.Fatalf("Unexpected message received: `%s`", decoded)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
 func TestWriteFromFrontend(t *testing.T) {
 	var wg sync.WaitGroup
 	defer wg.Wait()